@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Anomaly key prefixes
+const (
+	anomalyEntryPrefix = "anomaly:entry:"   // chain index -> []Anomaly found in the entry
+	anomalyRegistryKey = "anomaly:registry" // []Anomaly found across the current registrations
+	anomalyVersionKey  = "metadata:anomalyVersion"
+)
+
+// anomalyVersion is bumped when the checks change so existing entries are
+// re-validated
+const anomalyVersion = "1"
+
+// Kinds of anomaly
+const (
+	anomalyInvalidEntry    = "invalidEntry"     // The entry is not a valid registration
+	anomalyInvalidURL      = "invalidURL"       // A URL field is not a valid acc:// URL
+	anomalyForeignAccount  = "foreignAccount"   // An account is not under the identity
+	anomalyUnknownType     = "unknownType"      // An account has an unknown type
+	anomalyUnknownStatus   = "unknownStatus"    // The registration has an unknown status
+	anomalyNoAccounts      = "noAccounts"       // A registered identity has no accounts
+	anomalyPayoutNotFound  = "payoutNotFound"   // A payout account does not exist
+	anomalyDelegateMissing = "delegateMissing"  // A delegate is not a registered identity
+	anomalyDelegateRejects = "delegateRejects"  // A delegate rejects delegates
+	anomalyDuplicate       = "duplicateAccount" // An account is registered by several identities
+)
+
+// knownAccountTypes are the staking account types
+var knownAccountTypes = map[string]bool{
+	"pure":             true,
+	"delegated":        true,
+	"coreValidator":    true,
+	"coreFollower":     true,
+	"stakingValidator": true,
+}
+
+// Anomaly is a problem found in a staking registration
+type Anomaly struct {
+	Index    int64  `json:"index"` // Chain index of the offending entry
+	Hash     string `json:"hash,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	Account  string `json:"account,omitempty"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+}
+
+// validAccURL returns true if u is an acc:// URL with an authority and no
+// whitespace
+func validAccURL(u string) bool {
+	rest, ok := strings.CutPrefix(u, "acc://")
+	if !ok || rest == "" || strings.HasPrefix(rest, "/") || strings.ContainsAny(u, " \t\r\n") {
+		return false
+	}
+	_, err := url.Parse(u)
+	return err == nil
+}
+
+// underIdentity returns true if account is the identity or one of its
+// sub-accounts. URLs are case-insensitive.
+func underIdentity(account, identity string) bool {
+	account, identity = strings.ToLower(account), strings.ToLower(identity)
+	return account == identity || strings.HasPrefix(account, identity+"/")
+}
+
+// validateRegistration returns the anomalies of a registration that can be
+// found from the entry alone
+func validateRegistration(entry *RegistryEntry, identityURL string, r *RegistrationIdentity) []Anomaly {
+	var anomalies []Anomaly
+	add := func(account, kind, detail string) {
+		anomalies = append(anomalies, Anomaly{
+			Index:    entry.Index,
+			Hash:     entry.Hash,
+			Identity: identityURL,
+			Account:  account,
+			Kind:     kind,
+			Detail:   detail,
+		})
+	}
+	checkURL := func(account, field, value string) {
+		if value != "" && !validAccURL(value) {
+			add(account, anomalyInvalidURL, fmt.Sprintf("%s %q is not a valid URL", field, value))
+		}
+	}
+
+	checkURL("", "identity", identityURL)
+	checkURL("", "delegatorPayout", r.DelegatorPayout)
+	switch r.Status {
+	case "", "registered":
+		if len(r.Accounts) == 0 {
+			add("", anomalyNoAccounts, "registered identity has no accounts")
+		}
+	case "deleted":
+	default:
+		add("", anomalyUnknownStatus, fmt.Sprintf("unknown status %q", r.Status))
+	}
+
+	for _, account := range r.Accounts {
+		if !validAccURL(account.Url) {
+			add(account.Url, anomalyInvalidURL, fmt.Sprintf("account %q is not a valid URL", account.Url))
+		} else if !underIdentity(account.Url, identityURL) {
+			add(account.Url, anomalyForeignAccount, fmt.Sprintf("account is not under %s", identityURL))
+		}
+		if !knownAccountTypes[account.Type] {
+			add(account.Url, anomalyUnknownType, fmt.Sprintf("unknown account type %q", account.Type))
+		}
+		checkURL(account.Url, "payout", account.Payout)
+		checkURL(account.Url, "delegate", account.Delegate)
+	}
+	return anomalies
+}
+
+// findMissingPayouts returns an anomaly for each payout account of a
+// registration that does not exist. Payouts that cannot be queried are
+// skipped; the check is best effort.
+func (s *Service) findMissingPayouts(ctx context.Context, entry *RegistryEntry, identityURL string, r *RegistrationIdentity) []Anomaly {
+	owners := map[string]string{} // payout -> account that pays to it
+	if validAccURL(r.DelegatorPayout) {
+		owners[r.DelegatorPayout] = ""
+	}
+	for _, account := range r.Accounts {
+		if validAccURL(account.Payout) {
+			if _, ok := owners[account.Payout]; !ok {
+				owners[account.Payout] = account.Url
+			}
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	payouts := make([]string, 0, len(owners))
+	for payout := range owners {
+		payouts = append(payouts, payout)
+	}
+	sort.Strings(payouts)
+
+	var anomalies []Anomaly
+	for _, payout := range payouts {
+		_, err := s.client.QueryAccount(ctx, payout)
+		switch {
+		case isNotFound(err):
+			anomalies = append(anomalies, Anomaly{
+				Index:    entry.Index,
+				Hash:     entry.Hash,
+				Identity: identityURL,
+				Account:  owners[payout],
+				Kind:     anomalyPayoutNotFound,
+				Detail:   fmt.Sprintf("payout %s does not exist", payout),
+			})
+		case err != nil:
+			log.Printf("Warning: Failed to check payout %s of registry entry %d: %v", payout, entry.Index, err)
+		}
+	}
+	return anomalies
+}
+
+func (s *Service) anomalyEntryKey(index int64) []byte {
+	return s.key(fmt.Sprintf("%s%020d", anomalyEntryPrefix, index))
+}
+
+// putEntryAnomalies adds the anomalies of a registry entry to batch,
+// replacing those of a previous attempt
+func (s *Service) putEntryAnomalies(batch *leveldb.Batch, index int64, anomalies []Anomaly) error {
+	if len(anomalies) == 0 {
+		batch.Delete(s.anomalyEntryKey(index))
+		return nil
+	}
+	data, err := json.Marshal(anomalies)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.anomalyEntryKey(index), data)
+	return nil
+}
+
+// registryAnomalies returns the anomalies across the current registrations:
+// accounts registered by several identities and delegates that are not
+// registered or reject delegates
+func (s *Service) registryAnomalies() ([]Anomaly, error) {
+	identities, err := s.getAllIdentitiesFromDB()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(identities))
+	for identityURL := range identities {
+		urls = append(urls, identityURL)
+	}
+	sort.Strings(urls)
+
+	// Registry anomalies are attributed to the entry the identity was last set from
+	var anomalies []Anomaly
+	add := func(identityURL, account, kind, detail string) error {
+		anomaly := Anomaly{Index: s.getLatestIndex(identityURL), Identity: identityURL, Account: account, Kind: kind, Detail: detail}
+		entry, err := s.getRegistryEntry(anomaly.Index)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			anomaly.Hash = entry.Hash
+		}
+		anomalies = append(anomalies, anomaly)
+		return nil
+	}
+
+	owners := map[string][]string{}
+	for _, identityURL := range urls {
+		identity := identities[identityURL]
+		if identityStatus(identity) != "registered" {
+			continue
+		}
+		for _, account := range identity.Accounts {
+			owners[account.Url] = append(owners[account.Url], identityURL)
+			if account.Delegate == "" {
+				continue
+			}
+			delegate, ok := identities[account.Delegate]
+			switch {
+			case !ok || identityStatus(delegate) != "registered":
+				err = add(identityURL, account.Url, anomalyDelegateMissing, fmt.Sprintf("delegate %s is not a registered identity", account.Delegate))
+			case delegate.RejectDelegates:
+				err = add(identityURL, account.Url, anomalyDelegateRejects, fmt.Sprintf("delegate %s rejects delegates", account.Delegate))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for account, identities := range owners {
+		if len(identities) < 2 {
+			continue
+		}
+		for _, identityURL := range identities {
+			if err := add(identityURL, account, anomalyDuplicate, fmt.Sprintf("account is registered by %s", strings.Join(identities, ", "))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return anomalies, nil
+}
+
+// updateRegistryAnomalies recomputes and stores the registry anomalies
+func (s *Service) updateRegistryAnomalies() error {
+	anomalies, err := s.registryAnomalies()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(anomalies)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(s.key(anomalyRegistryKey), data, nil)
+}
+
+// ensureAnomalies validates the ingested entries if they were validated by
+// an older version or not at all. Entries are re-validated from the ledger
+// and the registration history, without the payout check.
+func (s *Service) ensureAnomalies() error {
+	version, err := s.db.Get(s.key(anomalyVersionKey), nil)
+	if err == nil && string(version) == anomalyVersion {
+		return nil
+	}
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	count := 0
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(registryEntryPrefix)), nil)
+	for iter.Next() {
+		var entry RegistryEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			iter.Release()
+			return err
+		}
+		anomalies, err := s.revalidateEntry(&entry)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		if err := s.putEntryAnomalies(batch, entry.Index, anomalies); err != nil {
+			iter.Release()
+			return err
+		}
+		count++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	batch.Put(s.key(anomalyVersionKey), []byte(anomalyVersion))
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to write anomalies: %w", err)
+	}
+	if count > 0 {
+		log.Printf("[%s] Validated %d staking registry entries", s.network.Name, count)
+	}
+	return s.updateRegistryAnomalies()
+}
+
+// revalidateEntry returns the anomalies of an ingested entry from its ledger
+// record and revision
+func (s *Service) revalidateEntry(entry *RegistryEntry) ([]Anomaly, error) {
+	switch entry.Outcome {
+	case entryInvalid:
+		return []Anomaly{{Index: entry.Index, Hash: entry.Hash, Kind: anomalyInvalidEntry, Detail: entry.Error}}, nil
+	case entryApplied, entrySuperseded:
+	default:
+		return nil, nil
+	}
+
+	data, err := s.db.Get(s.historyKey(entry.Identity, entry.Index), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var revision Revision
+	if err := json.Unmarshal(data, &revision); err != nil {
+		return nil, err
+	}
+	return validateRegistration(entry, entry.Identity, revision.Registration), nil
+}
+
+// AnomalyReport is the list of anomalies of a network
+type AnomalyReport struct {
+	Counts    map[string]int `json:"counts"` // Anomalies per kind
+	Anomalies []Anomaly      `json:"anomalies"`
+}
+
+// getAnomalies returns the registry anomalies and the anomalies of the
+// entries the current registrations were set from, and of all earlier
+// entries if history is set. Registry anomalies are always current.
+func (s *Service) getAnomalies(history bool) (*AnomalyReport, error) {
+	var anomalies []Anomaly
+	if data, err := s.db.Get(s.key(anomalyRegistryKey), nil); err == nil {
+		if err := json.Unmarshal(data, &anomalies); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, leveldb.ErrNotFound) {
+		return nil, err
+	}
+
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(anomalyEntryPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var found []Anomaly
+		if err := json.Unmarshal(iter.Value(), &found); err != nil {
+			return nil, err
+		}
+		for _, anomaly := range found {
+			// Anomalies without an identity are entries that were never applied
+			if history || anomaly.Identity == "" || s.getLatestIndex(anomaly.Identity) == anomaly.Index {
+				anomalies = append(anomalies, anomaly)
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Account < b.Account
+	})
+	report := &AnomalyReport{Anomalies: anomalies}
+	return report.filter(func(Anomaly) bool { return true }), nil
+}
+
+// filter returns the anomalies of the report that match keep, with their
+// counts
+func (r *AnomalyReport) filter(keep func(Anomaly) bool) *AnomalyReport {
+	filtered := &AnomalyReport{Counts: map[string]int{}, Anomalies: []Anomaly{}}
+	for _, anomaly := range r.Anomalies {
+		if keep(anomaly) {
+			filtered.Counts[anomaly.Kind]++
+			filtered.Anomalies = append(filtered.Anomalies, anomaly)
+		}
+	}
+	return filtered
+}
+
+// Get staking registry anomalies handler
+func (s *Service) getAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	history := false
+	if v := query.Get("history"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid history %q", v), http.StatusBadRequest)
+			return
+		}
+		history = b
+	}
+
+	report, err := s.getAnomalies(history)
+	if err != nil {
+		log.Printf("Error reading anomalies: %v", err)
+		http.Error(w, "Failed to read anomalies", http.StatusInternalServerError)
+		return
+	}
+
+	if kind := query.Get("kind"); kind != "" {
+		report = report.filter(func(a Anomaly) bool { return a.Kind == kind })
+	}
+	if identity := query.Get("identity"); identity != "" {
+		identity = strings.TrimSuffix(normalizeAccURL(identity), "/")
+		report = report.filter(func(a Anomaly) bool { return a.Identity == identity })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// anomalyKinds returns the kind of each anomaly keyed by account, or by
+// identity for anomalies of the registration as a whole
+func anomalyKinds(report *AnomalyReport) map[string][]string {
+	kinds := map[string][]string{}
+	for _, a := range report.Anomalies {
+		key := a.Account
+		if key == "" {
+			key = a.Identity
+		}
+		kinds[key] = append(kinds[key], a.Kind)
+	}
+	return kinds
+}
+
+func TestAnomalies(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://alice.acme/rewards", TokenURL: acmeTokenURL})
+	garbage := fake.AddRegistration(t, "garbage")
+	mallory := RegistrationIdentity{
+		Identity: "acc://mallory.acme",
+		Status:   "registered",
+		Accounts: []Account{
+			{Type: "pure", Url: "acc://alice.acme/staking"},
+			{Type: "whale", Url: "acc://mallory.acme/staking", Payout: "not a url", Delegate: "acc://carol.acme"},
+		},
+	}
+	fake.AddRegistration(t, mallory)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://dave.acme", Status: "paused", Accounts: []Account{{Type: "pure", Url: "acc://dave.acme/staking"}}})
+	server := newTestServer(t, fake)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	rec := get(t, router, "/staking/anomalies")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var report AnomalyReport
+	decode(t, rec, &report)
+	want := map[string][]string{
+		"acc://bob.acme/staking":     {"payoutNotFound"},
+		"":                           {"invalidEntry"},
+		"acc://alice.acme/staking":   {"duplicateAccount", "duplicateAccount", "foreignAccount"},
+		"acc://mallory.acme/staking": {"delegateMissing", "invalidURL", "unknownType"},
+		"acc://dave.acme":            {"unknownStatus"},
+	}
+	if got := anomalyKinds(&report); !reflect.DeepEqual(got, want) {
+		t.Errorf("anomalies = %v, want %v", got, want)
+	}
+	if report.Anomalies[2].Index != 2 || report.Anomalies[2].Hash != garbage || report.Counts["duplicateAccount"] != 2 {
+		t.Errorf("report = %+v", report)
+	}
+
+	decode(t, get(t, router, "/staking/anomalies?identity=mallory.acme&kind=invalidURL"), &report)
+	if len(report.Anomalies) != 1 || report.Anomalies[0].Index != 3 || report.Counts["invalidURL"] != 1 {
+		t.Errorf("filtered = %+v", report)
+	}
+
+	// Fixing the registration clears its anomalies, except from the history
+	mallory.Accounts = []Account{{Type: "pure", Url: "acc://mallory.acme/staking", Delegate: "acc://alice.acme"}}
+	fake.AddRegistration(t, mallory)
+	syncRegistry(t, server)
+
+	decode(t, get(t, router, "/staking/anomalies?identity=mallory.acme"), &report)
+	if len(report.Anomalies) != 0 {
+		t.Errorf("anomalies = %+v", report.Anomalies)
+	}
+	decode(t, get(t, router, "/staking/anomalies?identity=mallory.acme&history=true"), &report)
+	if len(report.Anomalies) != 3 || report.Anomalies[0].Index != 3 {
+		t.Errorf("history = %+v", report.Anomalies)
+	}
+}
+
+func TestAnomaliesBackfill(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://dave.acme", Status: "registered"})
+	server := newTestServer(t, fake)
+	syncRegistry(t, server)
+
+	// A database validated by an older version is re-validated on the next update
+	service := server.primary
+	if err := service.db.Delete(service.key(anomalyVersionKey), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.db.Delete(service.anomalyEntryKey(2), nil); err != nil {
+		t.Fatal(err)
+	}
+	syncRegistry(t, server)
+
+	var report AnomalyReport
+	decode(t, get(t, server.Router(), "/staking/anomalies?kind=noAccounts"), &report)
+	if len(report.Anomalies) != 1 || report.Anomalies[0].Identity != "acc://dave.acme" || report.Anomalies[0].Index != 2 {
+		t.Errorf("anomalies = %+v", report.Anomalies)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Staking rewards are 16% of the unissued supply per year, paid weekly
+const (
+	rewardRatePercent = 16
+	rewardPeriodDays  = 7
+	rewardPeriods     = 52 // Reward periods per year, for compounding
+)
+
+// StakingAPR is the estimated weekly reward pool and APR of a network.
+// Rewards are assumed to be pro rata to balance, so every group of a
+// breakdown earns the network APR.
+type StakingAPR struct {
+	AsOf          string     `json:"asOf"`
+	Stale         bool       `json:"stale,omitempty"`
+	Unissued      Amount     `json:"unissued"`
+	Staked        Amount     `json:"staked"`
+	WeeklyRewards Amount     `json:"weeklyRewards"` // Reward pool of one period
+	WeeklyRate    float64    `json:"weeklyRate"`    // WeeklyRewards / Staked
+	APR           float64    `json:"apr"`           // (1 + WeeklyRate)^52 - 1
+	ByType        []APRGroup `json:"byType,omitempty"`
+	ByLockup      []APRGroup `json:"byLockup,omitempty"`
+}
+
+// APRGroup is the stake and share of the reward pool of a group of staking
+// accounts
+type APRGroup struct {
+	Group         string  `json:"group"` // Account type, or lockup in quarters
+	Accounts      int     `json:"accounts"`
+	Staked        Amount  `json:"staked"`
+	Share         float64 `json:"share"` // Fraction of the total stake
+	WeeklyRewards Amount  `json:"weeklyRewards"`
+	APR           float64 `json:"apr"`
+}
+
+// weeklyRewards returns the reward pool of one period: 16% of the unissued
+// supply over a year, pro rated to a week
+func weeklyRewards(unissued *big.Int) *big.Int {
+	pool := new(big.Int).Mul(unissued, big.NewInt(rewardRatePercent*rewardPeriodDays))
+	return pool.Quo(pool, big.NewInt(100*365))
+}
+
+// ratio returns a / b as a float
+func ratio(a, b *big.Int) float64 {
+	r, _ := new(big.Float).Quo(new(big.Float).SetInt(a), new(big.Float).SetInt(b)).Float64()
+	return r
+}
+
+// computeAPR returns the reward pool and APR of supply, broken down by
+// account type and lockup if requested. The breakdowns need the balances of
+// the staking accounts.
+func (s *Service) computeAPR(supply *Supply, byType, byLockup bool) (*StakingAPR, error) {
+	if supply.Staked == nil || supply.Staked.Sign() <= 0 {
+		return nil, fmt.Errorf("staked amount is not available")
+	}
+	unissued := new(big.Int).Sub(supply.Max, supply.Total)
+	if unissued.Sign() < 0 {
+		unissued.SetInt64(0)
+	}
+
+	rewards := weeklyRewards(unissued)
+	rate := ratio(rewards, supply.Staked)
+	apr := &StakingAPR{
+		Unissued:      newAmount(unissued, supply.Precision),
+		Staked:        newAmount(supply.Staked, supply.Precision),
+		WeeklyRewards: newAmount(rewards, supply.Precision),
+		WeeklyRate:    rate,
+		APR:           math.Pow(1+rate, rewardPeriods) - 1,
+	}
+	if !byType && !byLockup {
+		return apr, nil
+	}
+	if supply.Balances == nil {
+		return nil, fmt.Errorf("staking balances are not available")
+	}
+
+	identities, err := s.getAllIdentitiesFromDB()
+	if err != nil {
+		return nil, err
+	}
+	types := map[string]*aprBucket{}
+	lockups := map[string]*aprBucket{}
+	seen := map[string]bool{}
+	for _, identity := range identities {
+		if identityStatus(identity) != "registered" {
+			continue
+		}
+		for _, account := range identity.Accounts {
+			if seen[account.Url] {
+				continue
+			}
+			seen[account.Url] = true
+			balance, ok := supply.Balances[account.Url]
+			if !ok {
+				balance = new(big.Int)
+			}
+			addToBucket(types, account.Type, balance)
+			addToBucket(lockups, strconv.FormatUint(account.Lockup, 10), balance)
+		}
+	}
+
+	if byType {
+		apr.ByType = aprGroups(types, supply, rewards, apr.APR)
+	}
+	if byLockup {
+		apr.ByLockup = aprGroups(lockups, supply, rewards, apr.APR)
+	}
+	return apr, nil
+}
+
+// aprBucket accumulates the accounts of a breakdown group
+type aprBucket struct {
+	accounts int
+	staked   *big.Int
+}
+
+func addToBucket(buckets map[string]*aprBucket, group string, balance *big.Int) {
+	bucket, ok := buckets[group]
+	if !ok {
+		bucket = &aprBucket{staked: new(big.Int)}
+		buckets[group] = bucket
+	}
+	bucket.accounts++
+	bucket.staked.Add(bucket.staked, balance)
+}
+
+// aprGroups returns the groups of a breakdown by descending stake
+func aprGroups(buckets map[string]*aprBucket, supply *Supply, rewards *big.Int, apr float64) []APRGroup {
+	groups := []APRGroup{}
+	for name, bucket := range buckets {
+		share := new(big.Int).Mul(rewards, bucket.staked)
+		share.Quo(share, supply.Staked)
+		groups = append(groups, APRGroup{
+			Group:         name,
+			Accounts:      bucket.accounts,
+			Staked:        newAmount(bucket.staked, supply.Precision),
+			Share:         ratio(bucket.staked, supply.Staked),
+			WeeklyRewards: newAmount(share, supply.Precision),
+			APR:           apr,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if c := compareAmounts(groups[i].Staked, groups[j].Staked); c != 0 {
+			return c > 0
+		}
+		return groups[i].Group < groups[j].Group
+	})
+	return groups
+}
+
+// compareAmounts compares the raw values of two amounts
+func compareAmounts(a, b Amount) int {
+	x, _ := new(big.Int).SetString(a.Raw, 10)
+	y, _ := new(big.Int).SetString(b.Raw, 10)
+	return x.Cmp(y)
+}
+
+// Get staking APR handler
+func (s *Service) getStakingAPRHandler(w http.ResponseWriter, r *http.Request) {
+	var byType, byLockup bool
+	for _, by := range r.URL.Query()["by"] {
+		switch by {
+		case "type":
+			byType = true
+		case "lockup":
+			byLockup = true
+		default:
+			http.Error(w, fmt.Sprintf("invalid breakdown %q (want type or lockup)", by), http.StatusBadRequest)
+			return
+		}
+	}
+
+	snapshot, err := s.supply.Get(r.Context())
+	if err != nil {
+		log.Printf("Error fetching metrics: %v", err)
+		http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
+		return
+	}
+
+	apr, err := s.computeAPR(snapshot.Supply, byType, byLockup)
+	if err != nil {
+		log.Printf("Error computing APR: %v", err)
+		http.Error(w, "Failed to compute APR: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	apr.AsOf = snapshot.AsOf.UTC().Format(time.RFC3339)
+	apr.Stale = snapshot.Stale
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", snapshot.Status)
+	w.Header().Set("Last-Modified", snapshot.AsOf.UTC().Format(http.TimeFormat))
+	json.NewEncoder(w).Encode(apr)
+}
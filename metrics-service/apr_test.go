@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestStakingAPR(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptStakers(t, fake)
+	server := newTestServer(t, fake)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	rec := get(t, router, "/v1/staking/apr")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var apr StakingAPR
+	decode(t, rec, &apr)
+
+	// The explorer's formula, in whole tokens
+	unissued := (5e16 - 3e16) / 1e8
+	rewards := unissued * 0.16 / 365 * 7
+	rate := rewards / (2.3e14 / 1e8)
+	want := math.Pow(1+rate, 52) - 1
+	if math.Abs(apr.WeeklyRate-rate) > 1e-9 || math.Abs(apr.APR-want)/want > 1e-9 {
+		t.Errorf("rate = %v, apr = %v, want %v, %v", apr.WeeklyRate, apr.APR, rate, want)
+	}
+	if apr.Unissued.Raw != "20000000000000000" || apr.Staked.Raw != "230000000000000" || apr.WeeklyRewards.Raw != "61369863013698" {
+		t.Errorf("apr = %+v", apr)
+	}
+	if apr.ByType != nil || apr.ByLockup != nil {
+		t.Errorf("unrequested breakdown %+v %+v", apr.ByType, apr.ByLockup)
+	}
+
+	decode(t, get(t, router, "/v1/staking/apr?by=type&by=lockup"), &apr)
+	var types []string
+	for _, group := range apr.ByType {
+		types = append(types, group.Group)
+	}
+	if len(types) != 3 || types[0] != "coreValidator" || types[1] != "pure" || types[2] != "delegated" {
+		t.Errorf("types = %v", types)
+	}
+	if pure := apr.ByType[1]; pure.Accounts != 2 || pure.Staked.Raw != "80000000000000" || pure.WeeklyRewards.Raw != "21346039309112" || pure.APR != apr.APR {
+		t.Errorf("pure = %+v", pure)
+	}
+	if len(apr.ByLockup) != 2 || apr.ByLockup[0].Group != "0" || apr.ByLockup[1].Group != "4" || apr.ByLockup[1].Accounts != 1 {
+		t.Errorf("lockups = %+v", apr.ByLockup)
+	}
+
+	if rec := get(t, router, "/v1/staking/apr?by=identity"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
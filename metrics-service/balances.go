@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceFailure is a staking account whose balance could not be fetched
+type BalanceFailure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// Reasons a registered staking account is excluded from the staked total
+const (
+	excludedOutsideIdentity = "outsideIdentity" // Not the identity or one of its sub-accounts
+	excludedNotTokenAccount = "notTokenAccount" // Exists but is not a token account
+	excludedNotACME         = "notACME"         // A token account for another token
+)
+
+// ExcludedAccount is a registered staking account whose balance is not
+// counted as staked
+type ExcludedAccount struct {
+	URL      string `json:"url"`
+	Identity string `json:"identity,omitempty"` // Registering identity
+	Reason   string `json:"reason"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// StakedBalances is the sum of the balances of the staking accounts
+type StakedBalances struct {
+	Total    *big.Int
+	Balances map[string]*big.Int // Balance of each account in Total
+	Accounts int                 // Number of accounts queried
+	Missing  []string            // Accounts that do not exist, counted as zero
+	Failed   []BalanceFailure    // Accounts whose balance is unknown and not in Total
+	Excluded []ExcludedAccount   // Accounts that are not ACME token accounts, not in Total
+	ByType   map[string]*big.Int // Total by registered account type
+}
+
+// IncompleteBalancesError is returned when the balance of one or more staking
+// accounts could not be fetched, so the staked total is unknown
+type IncompleteBalancesError struct {
+	Accounts int
+	Failed   []BalanceFailure
+}
+
+func (e *IncompleteBalancesError) Error() string {
+	return fmt.Sprintf("failed to fetch %d of %d staking account balances (%s: %s)",
+		len(e.Failed), e.Accounts, e.Failed[0].URL, e.Failed[0].Error)
+}
+
+// balanceFetcher fetches account balances with a bounded pool of workers.
+// Each worker queries a batch of accounts with one JSON-RPC batch request
+// (or one request per account if the API does not support batches), and
+// retries the queries that failed with exponential backoff.
+type balanceFetcher struct {
+	client    AccumulateClient
+	workers   int
+	batchSize int
+	timeout   time.Duration
+	retries   int
+	backoff   time.Duration
+
+	// Set once the API has rejected a batch request
+	noBatch atomic.Bool
+}
+
+func newBalanceFetcher(client AccumulateClient, config *Config) *balanceFetcher {
+	return &balanceFetcher{
+		client:    client,
+		workers:   config.BalanceWorkers,
+		batchSize: config.BalanceBatchSize,
+		timeout:   config.RequestTimeout,
+		retries:   config.RequestRetries,
+		backoff:   config.RetryBackoff,
+	}
+}
+
+// balanceResult is the outcome of fetching the balance of one account
+type balanceResult struct {
+	url      string
+	balance  *big.Int
+	missing  bool
+	excluded *ExcludedAccount
+	err      error
+}
+
+// Fetch returns the sum of the balances of urls. Accounts that could not be
+// fetched are reported in Failed and are not included in Total.
+func (f *balanceFetcher) Fetch(ctx context.Context, urls []string) *StakedBalances {
+	urls = append([]string(nil), urls...)
+	sort.Strings(urls)
+
+	var batches [][]string
+	for start := 0; start < len(urls); start += f.batchSize {
+		end := start + f.batchSize
+		if end > len(urls) {
+			end = len(urls)
+		}
+		batches = append(batches, urls[start:end])
+	}
+
+	jobs := make(chan []string)
+	results := make(chan []balanceResult)
+	var wg sync.WaitGroup
+	for i := 0; i < f.workers && i < len(batches); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				results <- f.fetchBatch(ctx, batch)
+			}
+		}()
+	}
+	go func() {
+		for _, batch := range batches {
+			jobs <- batch
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	balances := &StakedBalances{Total: new(big.Int), Balances: map[string]*big.Int{}, Accounts: len(urls)}
+	for batch := range results {
+		for _, r := range batch {
+			switch {
+			case r.err != nil:
+				balances.Failed = append(balances.Failed, BalanceFailure{URL: r.url, Error: r.err.Error()})
+			case r.missing:
+				balances.Missing = append(balances.Missing, r.url)
+			case r.excluded != nil:
+				balances.Excluded = append(balances.Excluded, *r.excluded)
+			default:
+				balances.Total.Add(balances.Total, r.balance)
+				balances.Balances[r.url] = r.balance
+			}
+		}
+	}
+	sort.Strings(balances.Missing)
+	sort.Slice(balances.Failed, func(i, j int) bool { return balances.Failed[i].URL < balances.Failed[j].URL })
+	sort.Slice(balances.Excluded, func(i, j int) bool { return balances.Excluded[i].URL < balances.Excluded[j].URL })
+	return balances
+}
+
+// fetchBatch fetches the balances of a batch of accounts, retrying the
+// queries that failed with a retryable error
+func (f *balanceFetcher) fetchBatch(ctx context.Context, urls []string) []balanceResult {
+	done := make([]balanceResult, 0, len(urls))
+	pending := urls
+	for attempt := 0; ; attempt++ {
+		var retry []balanceResult
+		for _, r := range f.query(ctx, pending) {
+			if r.err != nil && isRetryable(r.err) {
+				retry = append(retry, r)
+			} else {
+				done = append(done, r)
+			}
+		}
+		if len(retry) == 0 || attempt >= f.retries || ctx.Err() != nil {
+			return append(done, retry...)
+		}
+
+		select {
+		case <-time.After(f.backoff << attempt):
+		case <-ctx.Done():
+			return append(done, retry...)
+		}
+
+		pending = nil
+		for _, r := range retry {
+			pending = append(pending, r.url)
+		}
+	}
+}
+
+// query queries the balances of urls once
+func (f *balanceFetcher) query(ctx context.Context, urls []string) []balanceResult {
+	if len(urls) > 1 && !f.noBatch.Load() {
+		reqCtx, cancel := context.WithTimeout(ctx, f.timeout)
+		accounts, err := f.client.QueryAccounts(reqCtx, urls)
+		cancel()
+
+		switch {
+		case err == nil:
+			results := make([]balanceResult, len(urls))
+			for i, account := range accounts {
+				results[i] = newBalanceResult(urls[i], account.Account, account.Err)
+			}
+			return results
+		case errors.Is(err, errBatchUnsupported):
+			f.noBatch.Store(true)
+		default:
+			results := make([]balanceResult, len(urls))
+			for i, url := range urls {
+				results[i] = balanceResult{url: url, err: err}
+			}
+			return results
+		}
+	}
+
+	results := make([]balanceResult, len(urls))
+	for i, url := range urls {
+		reqCtx, cancel := context.WithTimeout(ctx, f.timeout)
+		account, err := f.client.QueryAccount(reqCtx, url)
+		cancel()
+		results[i] = newBalanceResult(url, account, err)
+	}
+	return results
+}
+
+func newBalanceResult(url string, account *AccountRecord, err error) balanceResult {
+	switch {
+	case isNotFound(err):
+		return balanceResult{url: url, missing: true}
+	case err != nil:
+		return balanceResult{url: url, err: err}
+	case account.Type != "tokenAccount":
+		return balanceResult{url: url, excluded: &ExcludedAccount{
+			URL:    url,
+			Reason: excludedNotTokenAccount,
+			Detail: fmt.Sprintf("account type is %s", account.Type),
+		}}
+	case !strings.EqualFold(account.TokenURL, acmeTokenURL):
+		return balanceResult{url: url, excluded: &ExcludedAccount{
+			URL:    url,
+			Reason: excludedNotACME,
+			Detail: fmt.Sprintf("token is %s", account.TokenURL),
+		}}
+	case account.Balance == "":
+		return balanceResult{url: url, balance: new(big.Int)}
+	}
+
+	balance, err := parseAmount(account.Balance)
+	if err != nil {
+		return balanceResult{url: url, err: &permanentError{err}}
+	}
+	return balanceResult{url: url, balance: balance}
+}
+
+// permanentError is an error that will not go away by retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isRetryable returns true unless err is permanent
+func isRetryable(err error) bool {
+	var permanent *permanentError
+	return !errors.As(err, &permanent)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newBalanceTestServer returns a mainnet server with small balance batches
+// and a short retry backoff
+func newBalanceTestServer(t *testing.T, fake *fakeAccumulate) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.BalanceBatchSize = 2
+	config.RetryBackoff = time.Millisecond
+	return newMultiNetworkServer(t, config, map[string]*fakeAccumulate{"mainnet": fake})
+}
+
+func TestStakedBalancesBatched(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	for i := 0; i < 3; i++ {
+		url := fmt.Sprintf("acc://staker%d.acme/staking", i)
+		fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: url, TokenURL: acmeTokenURL, Balance: "100000000"})
+		fake.AddRegistration(t, RegistrationIdentity{
+			Identity: fmt.Sprintf("acc://staker%d.acme", i),
+			Status:   "registered",
+			Accounts: []Account{{Type: "pure", Url: url}},
+		})
+	}
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://ghost.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "pure", Url: "acc://ghost.acme/staking"}},
+	})
+	router := newBalanceTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Staked.Raw != "150000300000000" {
+		t.Errorf("staked = %s, want 150000300000000", metrics.Staked.Raw)
+	}
+	if metrics.StakingAccounts != 6 {
+		t.Errorf("stakingAccounts = %d, want 6", metrics.StakingAccounts)
+	}
+	if len(metrics.MissingAccounts) != 1 || metrics.MissingAccounts[0] != "acc://ghost.acme/staking" {
+		t.Errorf("missingAccounts = %v", metrics.MissingAccounts)
+	}
+
+	// 6 accounts in batches of 2
+	if got := fake.Calls("batch"); got != 3 {
+		t.Errorf("batch requests = %d, want 3", got)
+	}
+}
+
+func TestStakedBalancesRetry(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.FailScope("acc://alice.acme/staking", 2)
+	router := newBalanceTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Staked.Raw != "150000000000000" {
+		t.Errorf("staked = %s, want 150000000000000", metrics.Staked.Raw)
+	}
+	if got := fake.ScopeCalls("acc://alice.acme/staking"); got != 3 {
+		t.Errorf("alice queries = %d, want 3", got)
+	}
+	if got := fake.ScopeCalls("acc://bob.acme/staking"); got != 1 {
+		t.Errorf("bob queries = %d, want 1 (only failed queries are retried)", got)
+	}
+}
+
+func TestStakedBalancesIncomplete(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.FailScope("acc://bob.acme/staking", 100)
+	server := newBalanceTestServer(t, fake)
+	router := server.Router()
+
+	// Without a complete sum there is nothing to serve
+	if rec := get(t, router, "/v2/supply"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	fake.FailScope("acc://bob.acme/staking", 0)
+	service, _ := server.Network("mainnet")
+	if err := service.supply.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later incomplete refresh keeps the previous supply and reports the failure
+	fake.FailScope("acc://bob.acme/staking", 100)
+	if err := service.supply.Refresh(context.Background()); err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+
+	rec := get(t, router, "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Staked.Raw != "150000000000000" {
+		t.Errorf("staked = %s, want the previous total", metrics.Staked.Raw)
+	}
+	if metrics.RefreshError == "" {
+		t.Error("refreshError is not set")
+	}
+	if len(metrics.FailedAccounts) != 1 || metrics.FailedAccounts[0].URL != "acc://bob.acme/staking" {
+		t.Errorf("failedAccounts = %+v", metrics.FailedAccounts)
+	}
+}
+
+func TestStakedBalancesWithoutBatch(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.DisableBatch()
+	server := newBalanceTestServer(t, fake)
+
+	rec := get(t, server.Router(), "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Staked.Raw != "150000000000000" {
+		t.Errorf("staked = %s, want 150000000000000", metrics.Staked.Raw)
+	}
+
+	// Once rejected, batches are not attempted again
+	service, _ := server.Network("mainnet")
+	if err := service.supply.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Calls("batch"); got != 1 {
+		t.Errorf("batch requests = %d, want 1", got)
+	}
+}
+
+func TestStakedBalancesBatchError(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.FailBatch(1)
+	server := newBalanceTestServer(t, fake)
+
+	// A transient error of a whole batch is retried as a batch
+	rec := get(t, server.Router(), "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Staked.Raw != "150000000000000" {
+		t.Errorf("staked = %s, want 150000000000000", metrics.Staked.Raw)
+	}
+
+	// And does not turn batches off for later refreshes
+	service, _ := server.Network("mainnet")
+	batches := fake.Calls("batch")
+	if err := service.supply.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Calls("batch") == batches {
+		t.Error("later refresh did not use batches")
+	}
+}
+
+func TestStakedBalancesTimeout(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+
+	config := DefaultConfig()
+	config.RequestTimeout = 20 * time.Millisecond
+	config.RequestRetries = 1
+	config.RetryBackoff = time.Millisecond
+	fetcher := newBalanceFetcher(fake.Client(), config)
+
+	release := fake.Hold()
+	t.Cleanup(release)
+
+	start := time.Now()
+	balances := fetcher.Fetch(context.Background(), []string{"acc://alice.acme/staking", "acc://bob.acme/staking"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch took %v", elapsed)
+	}
+	if len(balances.Failed) != 2 {
+		t.Fatalf("failed = %+v, want both accounts", balances.Failed)
+	}
+	if balances.Total.Sign() != 0 {
+		t.Errorf("total = %s, want 0", balances.Total)
+	}
+}
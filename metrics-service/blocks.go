@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Minor block key prefixes
+const (
+	minorBlockPrefix = "block:minor:" // partition:index -> MinorBlock
+	minorTimePrefix  = "block:time:"  // partition:time (Unix nanoseconds) -> index
+)
+
+// directoryPartition is the partition ID of the Directory Network
+const directoryPartition = "directory"
+
+// X-Cache values of minor block responses
+const (
+	cacheHitMinor  = "HIT"  // From the store, never re-queried
+	cacheMissMinor = "MISS" // Queried from the partition, now stored
+)
+
+// errNoBlockTime is returned for a minor block the partition has no time for
+var errNoBlockTime = errors.New("minor block has no time")
+
+// MinorBlock is a stored minor block of a partition. Blocks are immutable,
+// so they are stored permanently.
+type MinorBlock struct {
+	Partition string    `json:"partition"`
+	Index     int64     `json:"index"`
+	Time      time.Time `json:"time"`
+}
+
+// MinorBlockResponse is a minor block and the major block it belongs to
+type MinorBlockResponse struct {
+	*MinorBlock
+	MajorBlock int64 `json:"majorBlock"`
+	Estimated  bool  `json:"estimated,omitempty"` // The major block is outside the major block index
+}
+
+// MajorBlockResponse is an indexed major block
+type MajorBlockResponse struct {
+	*MajorBlock
+	End *time.Time `json:"end,omitempty"` // Start of the next major block, once indexed
+}
+
+// BlockAtResponse is the major block in progress at a time and the stored
+// minor blocks in progress
+type BlockAtResponse struct {
+	Time        time.Time           `json:"time"`
+	MajorBlock  int64               `json:"majorBlock"`
+	Estimated   bool                `json:"estimated,omitempty"` // Outside the major block index, from the schedule
+	Block       *MajorBlockResponse `json:"block,omitempty"`     // The indexed major block
+	MinorBlocks []*MinorBlock       `json:"minorBlocks"`         // Per partition, the last stored block of the major block at or before the time
+}
+
+// parsePartition returns the partition ID of a route variable: directory
+// (or dn) or a BVN name, with or without the bvn- prefix, in any case
+func parsePartition(v string) (string, error) {
+	partition := strings.TrimPrefix(strings.ToLower(v), "bvn-")
+	if partition == "dn" {
+		partition = directoryPartition
+	}
+	if !networkNamePattern.MatchString(partition) {
+		return "", fmt.Errorf("invalid partition %q", v)
+	}
+	return partition, nil
+}
+
+// partitionURL returns the URL of a partition
+func partitionURL(partition string) string {
+	if partition == directoryPartition {
+		return directoryURL
+	}
+	return "acc://bvn-" + partition + ".acme"
+}
+
+func (s *Service) minorBlockKey(partition string, index int64) []byte {
+	return s.key(fmt.Sprintf("%s%s:%020d", minorBlockPrefix, partition, index))
+}
+
+func (s *Service) minorTimeKey(partition string, t time.Time) []byte {
+	return s.key(fmt.Sprintf("%s%s:%020d", minorTimePrefix, partition, t.UnixNano()))
+}
+
+// putMinorBlock adds a minor block and its time index entry to batch
+func (s *Service) putMinorBlock(batch *leveldb.Batch, block *MinorBlock) error {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.minorBlockKey(block.Partition, block.Index), data)
+	batch.Put(s.minorTimeKey(block.Partition, block.Time), []byte(strconv.FormatInt(block.Index, 10)))
+	return nil
+}
+
+// minorBlocksAt returns, for each partition, the last stored minor block at
+// or before t that is not before since, in partition order
+func (s *Service) minorBlocksAt(t, since time.Time) ([]*MinorBlock, error) {
+	prefix := s.key(minorTimePrefix)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	blocks := []*MinorBlock{}
+	for ok := iter.First(); ok; {
+		partition, _, _ := strings.Cut(string(iter.Key()[len(prefix):]), ":")
+		partitionPrefix := s.key(minorTimePrefix + partition + ":")
+
+		// The last key of the partition at or before t
+		key := s.minorTimeKey(partition, t)
+		found := iter.Seek(key)
+		if !found || !bytes.Equal(iter.Key(), key) {
+			if found {
+				found = iter.Prev()
+			} else {
+				found = iter.Last()
+			}
+		}
+		if found && bytes.HasPrefix(iter.Key(), partitionPrefix) {
+			index, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			start, err := strconv.ParseInt(string(iter.Key()[len(partitionPrefix):]), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if !time.Unix(0, start).Before(since) {
+				blocks = append(blocks, &MinorBlock{Partition: partition, Index: index, Time: time.Unix(0, start).UTC()})
+			}
+		}
+
+		// Skip to the next partition (';' follows ':')
+		ok = iter.Seek(s.key(minorTimePrefix + partition + ";"))
+	}
+	return blocks, iter.Error()
+}
+
+// getMinorBlock returns a minor block and its X-Cache value. Blocks are
+// served from the store, or queried from the partition once and stored.
+func (s *Service) getMinorBlock(ctx context.Context, partition string, index int64) (*MinorBlock, string, error) {
+	data, err := s.db.Get(s.minorBlockKey(partition, index), nil)
+	if err == nil {
+		var block MinorBlock
+		if err := json.Unmarshal(data, &block); err == nil {
+			return &block, cacheHitMinor, nil
+		}
+		log.Printf("[%s] Error deserializing minor block %s/%d: %v", s.network.Name, partition, index, err)
+	} else if !errors.Is(err, leveldb.ErrNotFound) {
+		return nil, "", err
+	}
+
+	record, err := s.client.QueryMinorBlock(ctx, partitionURL(partition), uint64(index))
+	if err != nil {
+		return nil, "", err
+	}
+	if record.Time == nil {
+		return nil, "", errNoBlockTime
+	}
+
+	block := &MinorBlock{Partition: partition, Index: index, Time: record.Time.UTC()}
+	batch := new(leveldb.Batch)
+	if err := s.putMinorBlock(batch, block); err != nil {
+		return nil, "", err
+	}
+	if err := s.db.Write(batch, nil); err != nil {
+		log.Printf("[%s] Error storing minor block %s/%d: %v", s.network.Name, partition, index, err)
+	}
+	return block, cacheMissMinor, nil
+}
+
+// minorBlockResponse resolves the major block of a minor block: from the
+// Directory Network's minor block ranges for directory blocks, and from the
+// block time otherwise
+func (s *Service) minorBlockResponse(block *MinorBlock) *MinorBlockResponse {
+	if block.Partition == directoryPartition {
+		if major, indexed := s.majorBlockOfMinor(block.Index); indexed {
+			return &MinorBlockResponse{MinorBlock: block, MajorBlock: major}
+		}
+	}
+	major, indexed := s.majorBlockAt(block.Time)
+	return &MinorBlockResponse{MinorBlock: block, MajorBlock: major, Estimated: !indexed}
+}
+
+// majorBlockResponse returns an indexed major block and the start of the
+// next one, or nil
+func (s *Service) majorBlockResponse(index int64) (*MajorBlockResponse, error) {
+	block, err := s.getMajorBlock(index)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	response := &MajorBlockResponse{MajorBlock: block}
+	next, err := s.getMajorBlock(index + 1)
+	if err != nil {
+		return nil, err
+	}
+	if next != nil {
+		response.End = &next.Start
+	}
+	return response, nil
+}
+
+// Get minor block handler
+func (s *Service) getMinorBlockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	partition, err := parsePartition(vars["partition"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.ParseInt(vars["index"], 10, 64)
+	if err != nil || index <= 0 {
+		http.Error(w, fmt.Sprintf("invalid minor block %q", vars["index"]), http.StatusBadRequest)
+		return
+	}
+
+	block, cache, err := s.getMinorBlock(r.Context(), partition, index)
+	switch {
+	case isNotFound(err), errors.Is(err, errNoBlockTime):
+		http.Error(w, "Minor block not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Error querying minor block %s/%d: %v", partition, index, err)
+		http.Error(w, "Failed to query minor block", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", cache)
+	json.NewEncoder(w).Encode(s.minorBlockResponse(block))
+}
+
+// Get major block handler
+func (s *Service) getMajorBlockHandler(w http.ResponseWriter, r *http.Request) {
+	index, err := parseMajorBlock(mux.Vars(r)["index"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := s.majorBlockResponse(index)
+	if err != nil {
+		log.Printf("Error reading major block %d: %v", index, err)
+		http.Error(w, "Failed to read major block", http.StatusInternalServerError)
+		return
+	}
+	if response == nil {
+		http.Error(w, "Major block not indexed", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Get block at time handler
+func (s *Service) getBlockAtHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("time")
+	if v == "" {
+		http.Error(w, "time is required", http.StatusBadRequest)
+		return
+	}
+	t, err := parseCalendarTime(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	major, indexed := s.majorBlockAt(t)
+	if major <= 0 {
+		http.Error(w, "No major block at this time", http.StatusNotFound)
+		return
+	}
+	response := &BlockAtResponse{Time: t.UTC(), MajorBlock: major, Estimated: !indexed}
+	if indexed {
+		response.Block, err = s.majorBlockResponse(major)
+		if err != nil {
+			log.Printf("Error reading major block %d: %v", major, err)
+			http.Error(w, "Failed to read major block", http.StatusInternalServerError)
+			return
+		}
+	}
+	// An estimated major block may start after t if the last indexed block
+	// was late; its minor blocks then go back one interval
+	since := s.majorBlockStart(major)
+	if since.After(t) {
+		since = t.Add(-s.network.MajorBlockInterval)
+	}
+	response.MinorBlocks, err = s.minorBlocksAt(t, since)
+	if err != nil {
+		log.Printf("Error reading minor blocks at %v: %v", t, err)
+		http.Error(w, "Failed to read minor blocks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
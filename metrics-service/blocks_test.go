@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBlockLookup(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	at := func(day, hour int) time.Time { return time.Date(2025, 7, day, hour, 0, 0, 0, time.UTC) }
+	fake.SetMajorBlock(1, at(14, 0), 100)
+	fake.SetMajorBlock(2, at(14, 15), 200) // Three hours late
+	fake.SetMinorBlock("acc://bvn-apollo.acme", 5000, at(14, 13))
+
+	server := newTestServer(t, fake)
+	router := server.Router()
+	if err := server.primary.updateMajorBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// First minor blocks of major blocks are stored by the indexer
+	calls := fake.Calls("block")
+	var minor MinorBlockResponse
+	rec := get(t, router, "/v1/blocks/minor/dn/100")
+	decode(t, rec, &minor)
+	if rec.Header().Get("X-Cache") != cacheHitMinor || minor.Partition != directoryPartition || !minor.Time.Equal(at(14, 0)) || minor.MajorBlock != 1865 || minor.Estimated {
+		t.Errorf("directory block = %+v (%s)", minor, rec.Header().Get("X-Cache"))
+	}
+	if fake.Calls("block") != calls {
+		t.Error("stored minor block queried upstream")
+	}
+
+	// Other blocks are queried once and resolved from their time
+	for _, want := range []string{cacheMissMinor, cacheHitMinor} {
+		rec := get(t, router, "/v1/mainnet/blocks/minor/Apollo/5000")
+		decode(t, rec, &minor)
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != want || minor.Partition != "apollo" || minor.MajorBlock != 1865 || minor.Estimated {
+			t.Errorf("BVN block = %d %+v (%s)", rec.Code, minor, rec.Header().Get("X-Cache"))
+		}
+	}
+	if got := fake.Calls("block") - calls; got != 1 {
+		t.Errorf("minor block queries = %d, want 1", got)
+	}
+
+	var major MajorBlockResponse
+	decode(t, get(t, router, "/v1/blocks/major/1865"), &major)
+	if major.MajorBlock == nil || major.ChainIndex != 1 || major.LastMinorBlock != 199 || major.End == nil || !major.End.Equal(at(14, 15)) {
+		t.Errorf("major block = %+v", major)
+	}
+
+	var block BlockAtResponse
+	decode(t, get(t, router, "/v1/blocks/at?time=2025-07-14T14:00:00Z"), &block)
+	if block.MajorBlock != 1865 || block.Estimated || block.Block == nil || block.Block.FirstMinorBlock != 100 {
+		t.Errorf("block at = %+v", block)
+	}
+	// The stored minor blocks in progress, per partition
+	if m := block.MinorBlocks; len(m) != 2 || m[0].Partition != "apollo" || m[0].Index != 5000 || m[1].Partition != directoryPartition || m[1].Index != 100 {
+		t.Errorf("minor blocks at = %+v", m)
+	}
+	// Blocks of an earlier major block are not in progress
+	var next BlockAtResponse
+	decode(t, get(t, router, "/v1/blocks/at?time=2025-07-14T16:00:00Z"), &next)
+	if m := next.MinorBlocks; next.MajorBlock != 1866 || len(m) != 1 || m[0].Partition != directoryPartition || m[0].Index != 200 {
+		t.Errorf("minor blocks at = %d %+v", next.MajorBlock, m)
+	}
+	var estimated BlockAtResponse
+	decode(t, get(t, router, "/v1/blocks/at?time=2025-07-16"), &estimated)
+	if estimated.MajorBlock != 1868 || !estimated.Estimated || estimated.Block != nil || len(estimated.MinorBlocks) != 0 {
+		t.Errorf("estimated block at = %+v", estimated)
+	}
+
+	for path, code := range map[string]int{
+		"/v1/blocks/minor/apollo/6000":      http.StatusNotFound,
+		"/v1/blocks/minor/apollo/0":         http.StatusBadRequest,
+		"/v1/blocks/minor/apollo.acme/5000": http.StatusBadRequest,
+		"/v1/blocks/major/1867":             http.StatusNotFound,
+		"/v1/blocks/major/first":            http.StatusBadRequest,
+		"/v1/blocks/at":                     http.StatusBadRequest,
+		"/v1/blocks/at?time=yesterday":      http.StatusBadRequest,
+		"/v1/blocks/at?time=2025-01-01":     http.StatusNotFound,
+	} {
+		if rec := get(t, router, path); rec.Code != code {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, code)
+		}
+	}
+}
+
+func TestTimestampStoresMinorBlocks(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	txid := "a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"
+	fake.SetTransaction("acc://"+txid+"@unknown", TransactionRecord{Status: "delivered"})
+	fake.SetTimestamp(txid, []ChainEntry{
+		{Chain: "main", Block: 18745449, Time: "2026-02-21T19:22:52Z"},
+		{Chain: "signature", Block: 0, Time: "2026-02-21T19:22:40Z"},
+	})
+	router := newTestServer(t, fake).Router()
+
+	if rec := get(t, router, "/v1/timestamp/"+txid); rec.Code != http.StatusOK {
+		t.Fatalf("timestamp status = %d", rec.Code)
+	}
+
+	// The block the lookup learned is served without querying the chain
+	calls := fake.Calls("block")
+	var minor MinorBlockResponse
+	rec := get(t, router, "/v1/blocks/minor/directory/18745449")
+	decode(t, rec, &minor)
+	if rec.Header().Get("X-Cache") != cacheHitMinor || !minor.Time.Equal(time.Date(2026, 2, 21, 19, 22, 52, 0, time.UTC)) {
+		t.Errorf("minor block = %+v (%s)", minor, rec.Header().Get("X-Cache"))
+	}
+	if fake.Calls("block") != calls {
+		t.Error("minor block learned from a timestamp queried upstream")
+	}
+}
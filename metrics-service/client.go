@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"accumulate-metrics/registry"
+)
+
+// AccumulateClient is the subset of the Accumulate v2/v3 APIs used by the
+// metrics service. Handlers and background jobs only talk to the network
+// through this interface so they can be exercised against a fake.
+type AccumulateClient interface {
+	// QueryAccount returns the account record at the given URL (v3 query)
+	QueryAccount(ctx context.Context, url string) (*AccountRecord, error)
+
+	// QueryAccounts queries several accounts with a single JSON-RPC batch
+	// request. Results are in the order of urls and a failed query only
+	// fails its own result. Returns errBatchUnsupported if the API does not
+	// accept batch requests.
+	QueryAccounts(ctx context.Context, urls []string) ([]AccountResult, error)
+
+	// QueryChainCount returns the number of entries in the named chain of an account
+	QueryChainCount(ctx context.Context, scope, chain string) (int64, error)
+
+	// QueryChainRange returns count entries of the named chain starting at start
+	QueryChainRange(ctx context.Context, scope, chain string, start, count int64) ([]ChainRecord, error)
+
+	// QueryTransaction returns the transaction (message) record for a scope
+	// such as acc://{hash}@unknown
+	QueryTransaction(ctx context.Context, scope string) (*TransactionRecord, error)
+
+	// QueryTimestamp returns the chain entries from the v2 /timestamp endpoint
+	QueryTimestamp(ctx context.Context, txid string) ([]ChainEntry, error)
+
+	// QueryMajorBlock returns a major block of the Directory Network with
+	// its first minor block
+	QueryMajorBlock(ctx context.Context, index uint64) (*MajorBlockRecord, error)
+
+	// QueryMinorBlock returns a minor block of the partition at scope
+	QueryMinorBlock(ctx context.Context, scope string, index uint64) (*MinorBlockRecord, error)
+}
+
+// AccountRecord is the account part of a v3 account query response.
+// Amounts are kept as strings exactly as returned by the API.
+type AccountRecord struct {
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	Symbol      string `json:"symbol,omitempty"`
+	Precision   int    `json:"precision,omitempty"`
+	Issued      string `json:"issued,omitempty"`
+	SupplyLimit string `json:"supplyLimit,omitempty"`
+	Balance     string `json:"balance,omitempty"`
+	TokenURL    string `json:"tokenUrl,omitempty"`
+}
+
+// directoryURL is the Directory Network, whose blocks define the major blocks
+const directoryURL = "acc://dn.acme"
+
+// MajorBlockRecord is the subset of a v3 major block query response used by
+// the service
+type MajorBlockRecord struct {
+	Index       uint64    `json:"index"`
+	Time        time.Time `json:"time"`
+	MinorBlocks struct {
+		Records []MinorBlockRecord `json:"records"`
+		Total   uint64             `json:"total"`
+	} `json:"minorBlocks"`
+}
+
+// MinorBlockRecord is a minor block of a v3 block query response
+type MinorBlockRecord struct {
+	Index uint64     `json:"index"`
+	Time  *time.Time `json:"time,omitempty"`
+}
+
+// AccountResult is the outcome of one query of a batch
+type AccountResult struct {
+	Account *AccountRecord
+	Err     error
+}
+
+// Registry query types, shared with the registry package
+type (
+	ChainRecord       = registry.ChainRecord
+	TransactionRecord = registry.TransactionRecord
+	TransactionBody   = registry.TransactionBody
+	TransactionError  = registry.TransactionError
+	RPCError          = registry.RPCError
+)
+
+// JSON-RPC error codes
+const (
+	rpcInvalidRequest = -32600 // The request, or a batch of requests, is not accepted
+	rpcMethodNotFound = -32601
+	rpcNotFound       = -33404 // The queried record does not exist
+)
+
+// isNotFound returns true if err is an API error reporting that the queried
+// record does not exist
+func isNotFound(err error) bool {
+	var rpcErr *RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == rpcNotFound
+}
+
+// errBatchUnsupported is returned by QueryAccounts if the API rejects batch requests
+var errBatchUnsupported = errors.New("JSON-RPC batch requests are not supported")
+
+// httpClient implements AccumulateClient over HTTP JSON-RPC. The chain and
+// transaction queries are those of the registry client.
+type httpClient struct {
+	*registry.HTTPClient
+	v2URL string
+}
+
+// NewHTTPClient returns a client for the given v3 JSON-RPC endpoint and v2 base URL
+func NewHTTPClient(v3URL, v2URL string) AccumulateClient {
+	return &httpClient{
+		HTTPClient: registry.NewHTTPClient(v3URL),
+		v2URL:      strings.TrimSuffix(v2URL, "/"),
+	}
+}
+
+func (c *httpClient) QueryAccount(ctx context.Context, url string) (*AccountRecord, error) {
+	var result struct {
+		Account AccountRecord `json:"account"`
+	}
+	err := c.Call(ctx, "query", map[string]interface{}{
+		"scope": url,
+		"query": map[string]interface{}{},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result.Account, nil
+}
+
+func (c *httpClient) QueryAccounts(ctx context.Context, urls []string) ([]AccountResult, error) {
+	batch := make([]registry.RPCRequest, len(urls))
+	for i, url := range urls {
+		batch[i] = registry.RPCRequest{JSONRPC: "2.0", ID: i, Method: "query", Params: map[string]interface{}{
+			"scope": url,
+			"query": map[string]interface{}{},
+		}}
+	}
+
+	data, err := c.Post(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []registry.RPCResponse
+	if err := json.Unmarshal(data, &responses); err != nil {
+		// A server without batch support answers with a single invalid
+		// request or method not found error. Other single errors, such as
+		// rate limits, fail the whole batch.
+		var single registry.RPCResponse
+		if json.Unmarshal(data, &single) == nil && single.Error != nil {
+			if single.Error.Code == rpcInvalidRequest || single.Error.Code == rpcMethodNotFound {
+				return nil, fmt.Errorf("%w: %v", errBatchUnsupported, single.Error)
+			}
+			return nil, single.Error
+		}
+		return nil, fmt.Errorf("failed to decode batch response: %w", err)
+	}
+
+	results := make([]AccountResult, len(urls))
+	for i := range results {
+		results[i].Err = fmt.Errorf("no response for %s in batch", urls[i])
+	}
+	for _, resp := range responses {
+		if resp.ID < 0 || resp.ID >= len(urls) {
+			continue
+		}
+		var result struct {
+			Account AccountRecord `json:"account"`
+		}
+		if err := resp.Decode(&result); err != nil {
+			results[resp.ID] = AccountResult{Err: err}
+			continue
+		}
+		results[resp.ID] = AccountResult{Account: &result.Account}
+	}
+	return results, nil
+}
+
+func (c *httpClient) QueryMajorBlock(ctx context.Context, index uint64) (*MajorBlockRecord, error) {
+	var result MajorBlockRecord
+	err := c.Call(ctx, "query", map[string]interface{}{
+		"scope": directoryURL,
+		"query": map[string]interface{}{
+			"queryType":  "block",
+			"major":      index,
+			"minorRange": map[string]interface{}{"start": 0, "count": 1},
+		},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *httpClient) QueryMinorBlock(ctx context.Context, scope string, index uint64) (*MinorBlockRecord, error) {
+	var result MinorBlockRecord
+	err := c.Call(ctx, "query", map[string]interface{}{
+		"scope": scope,
+		"query": map[string]interface{}{
+			"queryType":  "block",
+			"minor":      index,
+			"entryRange": map[string]interface{}{"start": 0, "count": 0},
+		},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *httpClient) QueryTimestamp(ctx context.Context, txid string) ([]ChainEntry, error) {
+	v2URL := fmt.Sprintf("%s/timestamp/%s@unknown", c.v2URL, url.PathEscape(txid))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v2URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query timestamp endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var v2Data struct {
+		Chains []ChainEntry `json:"chains"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v2Data); err != nil {
+		return nil, fmt.Errorf("failed to decode timestamp response: %w", err)
+	}
+	return v2Data.Chains, nil
+}
@@ -0,0 +1,147 @@
+// Command extract-all-accounts lists the staking accounts of the registered
+// identities of the staking registry, resolved the same way as the metrics
+// service: the latest entry of each identity wins, legacy entries count as
+// registered and deleted identities are omitted.
+//
+// The registry is crawled from an Accumulate v3 endpoint, or read from the
+// identity database of a metrics service with -db.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"accumulate-metrics/registry"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// StakingAccount is a staking account of a registered identity
+type StakingAccount struct {
+	Identity string `json:"identity"`
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	Payout   string `json:"payout,omitempty"`
+	Delegate string `json:"delegate,omitempty"`
+	Lockup   uint64 `json:"lockup,omitempty"`
+	HardLock bool   `json:"hardLock,omitempty"`
+}
+
+func main() {
+	endpoint := flag.String("endpoint", "https://mainnet.accumulatenetwork.io/v3", "Accumulate v3 JSON-RPC endpoint")
+	dbPath := flag.String("db", "", "read the identities from this metrics service database instead of the network")
+	network := flag.String("network", "", "with -db, the network to read if it is not the service's primary network")
+	format := flag.String("format", "json", "output format: json or csv")
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *format != "json" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "invalid format %q\n", *format)
+		os.Exit(2)
+	}
+
+	var identities map[string]*registry.Registration
+	var err error
+	if *dbPath != "" {
+		identities, err = readDatabase(*dbPath, *network)
+	} else {
+		identities, err = crawl(context.Background(), *endpoint)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts := listAccounts(identities)
+	log.Printf("%d staking accounts of %d identities", len(accounts), len(identities))
+	if *format == "csv" {
+		err = writeCSV(os.Stdout, accounts)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(accounts)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// crawl reads every registry entry from the network
+func crawl(ctx context.Context, endpoint string) (map[string]*registry.Registration, error) {
+	identities, err := registry.Load(ctx, registry.NewHTTPClient(endpoint), func(entry *registry.Entry) {
+		log.Printf("Warning: Skipping registry entry %d (%s): %v", entry.Index, entry.Hash, entry.Err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return identities.Registrations(), nil
+}
+
+// readDatabase reads the identities ingested by a metrics service. The
+// service must be stopped, or the database copied, since LevelDB allows a
+// single process.
+func readDatabase(path, network string) (map[string]*registry.Registration, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	keyspace := ""
+	if network != "" {
+		keyspace = network + ":"
+	}
+	return registry.LoadIdentities(db, keyspace)
+}
+
+// listAccounts returns the accounts of the registered identities, ordered by
+// identity
+func listAccounts(identities map[string]*registry.Registration) []StakingAccount {
+	urls := make([]string, 0, len(identities))
+	for identity := range identities {
+		urls = append(urls, identity)
+	}
+	sort.Strings(urls)
+
+	accounts := []StakingAccount{}
+	for _, identity := range urls {
+		r := identities[identity]
+		if !r.Registered() {
+			continue
+		}
+		for _, a := range r.Accounts {
+			if a.Url == "" {
+				continue
+			}
+			accounts = append(accounts, StakingAccount{
+				Identity: identity,
+				Type:     a.Type,
+				URL:      a.Url,
+				Payout:   a.Payout,
+				Delegate: a.Delegate,
+				Lockup:   a.Lockup,
+				HardLock: a.HardLock,
+			})
+		}
+	}
+	return accounts
+}
+
+func writeCSV(w io.Writer, accounts []StakingAccount) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"identity", "type", "url", "payout", "delegate", "lockup", "hardLock"})
+	for _, a := range accounts {
+		out.Write([]string{a.Identity, a.Type, a.URL, a.Payout, a.Delegate,
+			strconv.FormatUint(a.Lockup, 10), strconv.FormatBool(a.HardLock)})
+	}
+	out.Flush()
+	return out.Error()
+}
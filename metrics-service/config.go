@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables read by LoadConfig
+const envPrefix = "ACCUMULATE_METRICS_"
+
+// Config holds the runtime configuration of the metrics service
+type Config struct {
+	// HTTP listen address
+	Listen string `yaml:"listen" toml:"listen"`
+	// LevelDB path for the timestamp cache and identity map
+	DBPath string `yaml:"dbPath" toml:"dbPath"`
+
+	// How long supply metrics are served from memory
+	CacheDuration time.Duration `yaml:"cacheDuration" toml:"cacheDuration"`
+	// How often the background updater checks the staking registry
+	UpdateInterval time.Duration `yaml:"updateInterval" toml:"updateInterval"`
+
+	// Staking account balance queries: number of concurrent workers,
+	// accounts per JSON-RPC batch request, timeout of each request, and
+	// number of retries of a failed query with exponential backoff
+	BalanceWorkers   int           `yaml:"balanceWorkers" toml:"balanceWorkers"`
+	BalanceBatchSize int           `yaml:"balanceBatchSize" toml:"balanceBatchSize"`
+	RequestTimeout   time.Duration `yaml:"requestTimeout" toml:"requestTimeout"`
+	RequestRetries   int           `yaml:"requestRetries" toml:"requestRetries"`
+	RetryBackoff     time.Duration `yaml:"retryBackoff" toml:"retryBackoff"`
+
+	// POST /v1/timestamps: transactions per request, and number of
+	// concurrent upstream resolutions
+	TimestampBatchLimit int `yaml:"timestampBatchLimit" toml:"timestampBatchLimit"`
+	TimestampWorkers    int `yaml:"timestampWorkers" toml:"timestampWorkers"`
+	// Delay before a pending transaction is first rechecked in the
+	// background, doubled on each recheck up to maxPendingBackoff
+	PendingBackoff time.Duration `yaml:"pendingBackoff" toml:"pendingBackoff"`
+
+	// Bearer token required by the /admin routes, which are
+	// disabled if it is unset
+	AdminToken string `yaml:"adminToken" toml:"adminToken"`
+	// Webhook deliveries: attempts before a delivery is marked failed, and
+	// delay before the first retry, doubled on each retry
+	WebhookAttempts int           `yaml:"webhookAttempts" toml:"webhookAttempts"`
+	WebhookBackoff  time.Duration `yaml:"webhookBackoff" toml:"webhookBackoff"`
+
+	// The primary network, served on the unprefixed routes and stored in
+	// the root keyspace of the database
+	NetworkConfig `yaml:",inline"`
+
+	// Additional networks, served under /v1/{network}/... and
+	// /{network}/staking/... and stored under the "{network}:" key prefix.
+	// Unset endpoints default to the well-known URLs of the network and an
+	// unset major block schedule defaults to the primary network's.
+	Networks []NetworkConfig `yaml:"networks" toml:"networks"`
+}
+
+// NetworkConfig is the configuration of one Accumulate network
+type NetworkConfig struct {
+	// Network name, used in routes and database keys
+	Name string `yaml:"network" toml:"network"`
+
+	// Accumulate API endpoints
+	API   string `yaml:"api" toml:"api"`     // v3 JSON-RPC endpoint
+	APIv2 string `yaml:"apiV2" toml:"apiV2"` // v2 base URL (for /timestamp)
+
+	// v3 JSON-RPC endpoint of the network before its genesis reset, whose
+	// major blocks are indexed as absolute blocks 1-PreGenesisBlockOffset
+	// (optional)
+	ArchiveAPI string `yaml:"archiveApi" toml:"archiveApi"`
+
+	// Major block schedule, see calculateMajorBlock. PreGenesisBlockOffset
+	// also numbers the indexed major blocks, see majorblocks.go
+	GenesisResetTime      time.Time     `yaml:"genesisResetTime" toml:"genesisResetTime"`
+	MajorBlockInterval    time.Duration `yaml:"majorBlockInterval" toml:"majorBlockInterval"`
+	PreGenesisBlockOffset int64         `yaml:"preGenesisBlockOffset" toml:"preGenesisBlockOffset"`
+
+	// How long a transaction can stay pending before the network drops it;
+	// pending transactions older than this are reported as expired
+	PendingWindow time.Duration `yaml:"pendingWindow" toml:"pendingWindow"`
+}
+
+// knownNetworks are the v2 base URLs of the networks listed in the
+// Explorer's networks.tsx. The v3 endpoint is the base URL + /v3.
+var knownNetworks = map[string]string{
+	"mainnet": "https://mainnet.accumulatenetwork.io",
+	"kermit":  "https://kermit.accumulatenetwork.io",
+	"fozzie":  "https://fozzie.accumulatenetwork.io",
+	"local":   "http://127.0.0.1:26660",
+}
+
+// networkNamePattern restricts network names to values that are safe in
+// routes and database key prefixes
+var networkNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// reservedNetworkNames are the key namespaces of the primary network, which
+// has no key prefix. Another network named after one would have its keys
+// inside the primary's namespace. Add new namespaces here.
+var reservedNetworkNames = map[string]bool{
+	"anomaly":   true,
+	"block":     true,
+	"history":   true,
+	"identity":  true,
+	"index":     true,
+	"major":     true,
+	"metadata":  true,
+	"registry":  true,
+	"snapshot":  true,
+	"stream":    true,
+	"timestamp": true,
+	"tx":        true,
+	"webhook":   true,
+}
+
+// DefaultConfig returns the mainnet configuration the service was built with
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:         ":8080",
+		DBPath:         "./data/timestamps.db",
+		CacheDuration:  5 * time.Minute,
+		UpdateInterval: 30 * time.Second,
+
+		BalanceWorkers:   8,
+		BalanceBatchSize: 50,
+		RequestTimeout:   10 * time.Second,
+		RequestRetries:   3,
+		RetryBackoff:     500 * time.Millisecond,
+
+		TimestampBatchLimit: 100,
+		TimestampWorkers:    8,
+		PendingBackoff:      time.Minute,
+
+		WebhookAttempts: 8,
+		WebhookBackoff:  30 * time.Second,
+
+		NetworkConfig: NetworkConfig{
+			Name:  "mainnet",
+			API:   "https://mainnet.accumulatenetwork.io/v3",
+			APIv2: "https://mainnet.accumulatenetwork.io",
+
+			// Genesis reset on July 14, 2025 - post-genesis block 1 started at this time
+			// Major blocks occur every 12 hours (cron: "0 */12 * * *")
+			GenesisResetTime:   time.Date(2025, 7, 14, 0, 0, 0, 0, time.UTC),
+			MajorBlockInterval: 12 * time.Hour,
+			// Pre-genesis offset: the old chain had 1,864 major blocks before the reset
+			// Absolute block number = post-genesis block + 1864
+			PreGenesisBlockOffset: 1864,
+
+			PendingWindow: 14 * 24 * time.Hour,
+		},
+	}
+}
+
+// AllNetworks returns the primary network followed by the additional networks
+func (c *Config) AllNetworks() []*NetworkConfig {
+	networks := []*NetworkConfig{&c.NetworkConfig}
+	for i := range c.Networks {
+		networks = append(networks, &c.Networks[i])
+	}
+	return networks
+}
+
+// applyNetworkDefaults fills in the unset values of the additional networks
+func (c *Config) applyNetworkDefaults() {
+	for i := range c.Networks {
+		n := &c.Networks[i]
+		if base, ok := knownNetworks[n.Name]; ok {
+			if n.APIv2 == "" {
+				n.APIv2 = base
+			}
+			if n.API == "" {
+				n.API = base + "/v3"
+			}
+		}
+		if n.GenesisResetTime.IsZero() && n.MajorBlockInterval == 0 {
+			n.GenesisResetTime = c.GenesisResetTime
+			n.MajorBlockInterval = c.MajorBlockInterval
+			n.PreGenesisBlockOffset = c.PreGenesisBlockOffset
+		}
+		if n.PendingWindow == 0 {
+			n.PendingWindow = c.PendingWindow
+		}
+	}
+}
+
+// configSetting is a configuration value that can be set from a flag or an
+// environment variable
+type configSetting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var configSettings = []configSetting{
+	{"network", "NETWORK", "name of the primary network", func(c *Config, v string) error {
+		c.Name = v
+		return nil
+	}},
+	{"listen", "LISTEN", "HTTP listen address", func(c *Config, v string) error {
+		c.Listen = v
+		return nil
+	}},
+	{"db", "DB_PATH", "LevelDB database path", func(c *Config, v string) error {
+		c.DBPath = v
+		return nil
+	}},
+	{"api", "API", "Accumulate v3 JSON-RPC endpoint", func(c *Config, v string) error {
+		c.API = v
+		return nil
+	}},
+	{"api-v2", "API_V2", "Accumulate v2 base URL", func(c *Config, v string) error {
+		c.APIv2 = v
+		return nil
+	}},
+	{"archive-api", "ARCHIVE_API", "v3 JSON-RPC endpoint of the network before its genesis reset", func(c *Config, v string) error {
+		c.ArchiveAPI = v
+		return nil
+	}},
+	{"cache-duration", "CACHE_DURATION", "supply metrics cache duration", func(c *Config, v string) error {
+		return setDuration(&c.CacheDuration, v)
+	}},
+	{"update-interval", "UPDATE_INTERVAL", "staking registry update interval", func(c *Config, v string) error {
+		return setDuration(&c.UpdateInterval, v)
+	}},
+	{"balance-workers", "BALANCE_WORKERS", "concurrent staking balance queries", func(c *Config, v string) error {
+		return setInt(&c.BalanceWorkers, v)
+	}},
+	{"balance-batch-size", "BALANCE_BATCH_SIZE", "staking accounts per JSON-RPC batch request", func(c *Config, v string) error {
+		return setInt(&c.BalanceBatchSize, v)
+	}},
+	{"request-timeout", "REQUEST_TIMEOUT", "timeout of each staking balance request", func(c *Config, v string) error {
+		return setDuration(&c.RequestTimeout, v)
+	}},
+	{"request-retries", "REQUEST_RETRIES", "retries of a failed staking balance query", func(c *Config, v string) error {
+		return setInt(&c.RequestRetries, v)
+	}},
+	{"retry-backoff", "RETRY_BACKOFF", "delay before the first retry, doubled on each retry", func(c *Config, v string) error {
+		return setDuration(&c.RetryBackoff, v)
+	}},
+	{"timestamp-batch-limit", "TIMESTAMP_BATCH_LIMIT", "transactions per POST /v1/timestamps request", func(c *Config, v string) error {
+		return setInt(&c.TimestampBatchLimit, v)
+	}},
+	{"timestamp-workers", "TIMESTAMP_WORKERS", "concurrent upstream timestamp queries of a batch", func(c *Config, v string) error {
+		return setInt(&c.TimestampWorkers, v)
+	}},
+	{"pending-backoff", "PENDING_BACKOFF", "delay before the first background recheck of a pending transaction, doubled on each recheck", func(c *Config, v string) error {
+		return setDuration(&c.PendingBackoff, v)
+	}},
+	{"admin-token", "ADMIN_TOKEN", "bearer token of the admin API", func(c *Config, v string) error {
+		c.AdminToken = v
+		return nil
+	}},
+	{"webhook-attempts", "WEBHOOK_ATTEMPTS", "attempts of a webhook delivery before it fails", func(c *Config, v string) error {
+		return setInt(&c.WebhookAttempts, v)
+	}},
+	{"webhook-backoff", "WEBHOOK_BACKOFF", "delay before the first webhook retry, doubled on each retry", func(c *Config, v string) error {
+		return setDuration(&c.WebhookBackoff, v)
+	}},
+	{"genesis-reset-time", "GENESIS_RESET_TIME", "start of post-genesis major block 1 (RFC 3339)", func(c *Config, v string) error {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return err
+		}
+		c.GenesisResetTime = t
+		return nil
+	}},
+	{"major-block-interval", "MAJOR_BLOCK_INTERVAL", "time between major blocks", func(c *Config, v string) error {
+		return setDuration(&c.MajorBlockInterval, v)
+	}},
+	{"pre-genesis-block-offset", "PRE_GENESIS_BLOCK_OFFSET", "number of major blocks before the genesis reset", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.PreGenesisBlockOffset = n
+		return nil
+	}},
+	{"pending-window", "PENDING_WINDOW", "how long a transaction can stay pending before it expires", func(c *Config, v string) error {
+		return setDuration(&c.PendingWindow, v)
+	}},
+}
+
+func setInt(n *int, v string) error {
+	parsed, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*n = parsed
+	return nil
+}
+
+func setDuration(d *time.Duration, v string) error {
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// LoadConfig builds the configuration from, in increasing order of
+// precedence: the defaults, the config file, ACCUMULATE_METRICS_* environment
+// variables and command-line flags. The config file is given by -config or
+// ACCUMULATE_METRICS_CONFIG and may be YAML (.yaml, .yml) or TOML (.toml).
+func LoadConfig(args []string, getenv func(string) string, output io.Writer) (*Config, error) {
+	fs := flag.NewFlagSet("metrics-service", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	configFile := fs.String("config", getenv(envPrefix+"CONFIG"), "config file (YAML or TOML)")
+	flagValues := make(map[string]*string, len(configSettings))
+	for _, s := range configSettings {
+		flagValues[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s%s)", s.usage, envPrefix, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	config := DefaultConfig()
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range configSettings {
+		v := getenv(envPrefix + s.env)
+		if v == "" {
+			continue
+		}
+		if err := s.set(config, v); err != nil {
+			return nil, fmt.Errorf("invalid %s%s: %w", envPrefix, s.env, err)
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range configSettings {
+			if s.flag != f.Name || flagErr != nil {
+				continue
+			}
+			if err := s.set(config, *flagValues[s.flag]); err != nil {
+				flagErr = fmt.Errorf("invalid -%s: %w", s.flag, err)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	config.applyNetworkDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadFile overlays the values set in a YAML or TOML file onto c
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("failed to parse config file %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("unsupported config file type %q (want .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address is required")
+	}
+	if c.DBPath == "" {
+		return fmt.Errorf("database path is required")
+	}
+	if c.CacheDuration <= 0 {
+		return fmt.Errorf("cacheDuration must be positive")
+	}
+	if c.UpdateInterval <= 0 {
+		return fmt.Errorf("updateInterval must be positive")
+	}
+	if c.BalanceWorkers <= 0 {
+		return fmt.Errorf("balanceWorkers must be positive")
+	}
+	if c.BalanceBatchSize <= 0 {
+		return fmt.Errorf("balanceBatchSize must be positive")
+	}
+	if c.RequestTimeout <= 0 {
+		return fmt.Errorf("requestTimeout must be positive")
+	}
+	if c.RequestRetries < 0 {
+		return fmt.Errorf("requestRetries must not be negative")
+	}
+	if c.RetryBackoff < 0 {
+		return fmt.Errorf("retryBackoff must not be negative")
+	}
+	if c.TimestampBatchLimit <= 0 {
+		return fmt.Errorf("timestampBatchLimit must be positive")
+	}
+	if c.TimestampWorkers <= 0 {
+		return fmt.Errorf("timestampWorkers must be positive")
+	}
+	if c.PendingBackoff <= 0 {
+		return fmt.Errorf("pendingBackoff must be positive")
+	}
+	if c.WebhookAttempts <= 0 {
+		return fmt.Errorf("webhookAttempts must be positive")
+	}
+	if c.WebhookBackoff < 0 {
+		return fmt.Errorf("webhookBackoff must not be negative")
+	}
+
+	seen := map[string]bool{}
+	for _, n := range c.AllNetworks() {
+		if err := n.Validate(); err != nil {
+			return err
+		}
+		if seen[n.Name] {
+			return fmt.Errorf("network %s is configured more than once", n.Name)
+		}
+		seen[n.Name] = true
+	}
+	return nil
+}
+
+// Validate checks that the network configuration is usable
+func (n *NetworkConfig) Validate() error {
+	if !networkNamePattern.MatchString(n.Name) {
+		return fmt.Errorf("invalid network name %q (want lower-case letters, digits and dashes)", n.Name)
+	}
+	if reservedNetworkNames[n.Name] {
+		return fmt.Errorf("network name %q is reserved", n.Name)
+	}
+	if err := validateURL(n.Name+" api", n.API); err != nil {
+		return err
+	}
+	if err := validateURL(n.Name+" apiV2", n.APIv2); err != nil {
+		return err
+	}
+	if n.ArchiveAPI != "" {
+		if err := validateURL(n.Name+" archiveApi", n.ArchiveAPI); err != nil {
+			return err
+		}
+	}
+	if n.GenesisResetTime.IsZero() {
+		return fmt.Errorf("%s genesisResetTime is required", n.Name)
+	}
+	if n.MajorBlockInterval <= 0 {
+		return fmt.Errorf("%s majorBlockInterval must be positive", n.Name)
+	}
+	if n.PreGenesisBlockOffset < 0 {
+		return fmt.Errorf("%s preGenesisBlockOffset must not be negative", n.Name)
+	}
+	if n.PendingWindow <= 0 {
+		return fmt.Errorf("%s pendingWindow must be positive", n.Name)
+	}
+	return nil
+}
+
+func validateURL(name, u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%s must be an http(s) URL, got %q", name, u)
+	}
+	return nil
+}
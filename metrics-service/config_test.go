@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func envMap(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := LoadConfig(nil, envMap(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, DefaultConfig()) {
+		t.Errorf("config = %+v, want defaults", config)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+listen: ":9000"
+dbPath: /var/lib/metrics/file.db
+api: https://kermit.accumulatenetwork.io/v3
+cacheDuration: 1m
+`)
+
+	env := map[string]string{
+		"ACCUMULATE_METRICS_CONFIG":  path,
+		"ACCUMULATE_METRICS_DB_PATH": "/var/lib/metrics/env.db",
+		"ACCUMULATE_METRICS_LISTEN":  ":9001",
+	}
+	config, err := LoadConfig([]string{"-listen", ":9002"}, envMap(env), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Listen != ":9002" {
+		t.Errorf("listen = %q, want flag value", config.Listen)
+	}
+	if config.DBPath != "/var/lib/metrics/env.db" {
+		t.Errorf("dbPath = %q, want env value", config.DBPath)
+	}
+	if config.API != "https://kermit.accumulatenetwork.io/v3" || config.CacheDuration != time.Minute {
+		t.Errorf("file values not applied: %+v", config)
+	}
+	if config.APIv2 != DefaultConfig().APIv2 {
+		t.Errorf("apiV2 = %q, want default", config.APIv2)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+updateInterval = "2m"
+genesisResetTime = 2025-01-01T00:00:00Z
+preGenesisBlockOffset = 0
+`)
+
+	config, err := LoadConfig([]string{"-config", path}, envMap(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.UpdateInterval != 2*time.Minute {
+		t.Errorf("updateInterval = %v", config.UpdateInterval)
+	}
+	if !config.GenesisResetTime.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("genesisResetTime = %v", config.GenesisResetTime)
+	}
+	if config.PreGenesisBlockOffset != 0 {
+		t.Errorf("preGenesisBlockOffset = %d", config.PreGenesisBlockOffset)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		file string
+	}{
+		"bad flag duration":   {args: []string{"-cache-duration", "soon"}},
+		"bad env int":         {env: map[string]string{"ACCUMULATE_METRICS_PRE_GENESIS_BLOCK_OFFSET": "many"}},
+		"bad api scheme":      {args: []string{"-api", "ftp://example.com"}},
+		"bad archive api":     {args: []string{"-archive-api", "archive"}},
+		"zero interval":       {args: []string{"-update-interval", "0s"}},
+		"zero workers":        {args: []string{"-balance-workers", "0"}},
+		"zero batch limit":    {args: []string{"-timestamp-batch-limit", "0"}},
+		"zero pending window": {args: []string{"-pending-window", "0s"}},
+		"unknown file key":    {file: "lisen: \":80\"\n"},
+		"extra argument":      {args: []string{"serve-now"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			args := c.args
+			if c.file != "" {
+				args = append(args, "-config", writeFile(t, "config.yml", c.file))
+			}
+			if _, err := LoadConfig(args, envMap(c.env), io.Discard); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigNetworks(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+networks:
+  - network: kermit
+  - network: devnet
+    api: http://10.0.0.1:26660/v3
+    apiV2: http://10.0.0.1:26660
+    genesisResetTime: 2026-01-01T00:00:00Z
+    majorBlockInterval: 1h
+`)
+
+	config, err := LoadConfig([]string{"-config", path}, envMap(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks := config.AllNetworks()
+	if len(networks) != 3 || networks[0].Name != "mainnet" {
+		t.Fatalf("networks = %+v", networks)
+	}
+	kermit := networks[1]
+	if kermit.API != "https://kermit.accumulatenetwork.io/v3" || kermit.APIv2 != "https://kermit.accumulatenetwork.io" {
+		t.Errorf("kermit endpoints = %s, %s", kermit.API, kermit.APIv2)
+	}
+	if !kermit.GenesisResetTime.Equal(config.GenesisResetTime) || kermit.PreGenesisBlockOffset != 1864 {
+		t.Errorf("kermit schedule not inherited: %+v", kermit)
+	}
+	devnet := networks[2]
+	if devnet.MajorBlockInterval != time.Hour || devnet.PreGenesisBlockOffset != 0 {
+		t.Errorf("devnet schedule = %+v", devnet)
+	}
+
+	for name, file := range map[string]string{
+		"duplicate":      "networks:\n  - network: mainnet\n",
+		"unknown no api": "networks:\n  - network: devnet\n",
+		"invalid name":   "networks:\n  - network: Dev/Net\n    api: http://x/v3\n    apiV2: http://x\n",
+		"reserved name":  "networks:\n  - network: index\n    api: http://x/v3\n    apiV2: http://x\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig([]string{"-config", writeFile(t, "config.yaml", file)}, envMap(nil), io.Discard)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
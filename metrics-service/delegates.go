@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Delegator is a staking account delegated to an identity
+type Delegator struct {
+	Account  string  `json:"account"`
+	Identity string  `json:"identity"`
+	Type     string  `json:"type,omitempty"`
+	Lockup   uint64  `json:"lockup"`
+	HardLock bool    `json:"hardLock"`
+	Balance  *Amount `json:"balance,omitempty"` // As of the latest supply refresh, if known
+}
+
+// DelegateInfo is the delegation state of an identity
+type DelegateInfo struct {
+	Delegate         string      `json:"delegate"`
+	Registered       bool        `json:"registered"`       // The delegate is a registered staking identity
+	RejectDelegates  bool        `json:"rejectDelegates"`  // The delegate's registration rejects delegates
+	AcceptsDelegates bool        `json:"acceptsDelegates"` // Registered and does not reject delegates
+	DelegatorPayout  string      `json:"delegatorPayout,omitempty"`
+	Delegators       []Delegator `json:"delegators"`
+	TotalDelegated   *Amount     `json:"totalDelegated,omitempty"` // Sum of the delegator balances, if known
+}
+
+// getDelegateInfo returns the accounts delegating to an identity with their
+// balances from supply, which may be nil
+func (s *Service) getDelegateInfo(delegateURL string, supply *Supply) (*DelegateInfo, error) {
+	info := &DelegateInfo{Delegate: delegateURL, Delegators: []Delegator{}}
+
+	identity, err := s.getIdentityFromDB(delegateURL)
+	switch {
+	case err == nil:
+		info.Registered = identityStatus(identity) == "registered"
+		info.RejectDelegates = identity.RejectDelegates
+		info.AcceptsDelegates = info.Registered && !identity.RejectDelegates
+		info.DelegatorPayout = identity.DelegatorPayout
+	case !errors.Is(err, leveldb.ErrNotFound):
+		return nil, err
+	}
+
+	delegated, err := s.getDelegatedAccounts(delegateURL)
+	if err != nil {
+		return nil, err
+	}
+
+	total := new(big.Int)
+	for _, d := range delegated {
+		delegator := Delegator{Account: d.Account, Identity: d.Identity}
+		if owner, err := s.getIdentityFromDB(d.Identity); err == nil {
+			for _, account := range owner.Accounts {
+				if account.Url == d.Account {
+					delegator.Type = account.Type
+					delegator.Lockup = account.Lockup
+					delegator.HardLock = account.HardLock
+				}
+			}
+		}
+		if supply != nil && supply.Balances != nil {
+			balance, ok := supply.Balances[d.Account]
+			if !ok {
+				balance = new(big.Int)
+			}
+			amount := newAmount(balance, supply.Precision)
+			delegator.Balance = &amount
+			total.Add(total, balance)
+		}
+		info.Delegators = append(info.Delegators, delegator)
+	}
+
+	if supply != nil && supply.Balances != nil {
+		amount := newAmount(total, supply.Precision)
+		info.TotalDelegated = &amount
+	}
+	return info, nil
+}
+
+// latestBalances returns the latest supply if it has account balances, or nil
+func (s *Service) latestBalances(r *http.Request) *Supply {
+	snapshot, err := s.supply.Get(r.Context())
+	if err != nil || snapshot.Supply.Balances == nil {
+		return nil
+	}
+	return snapshot.Supply
+}
+
+// Get delegate info handler
+func (s *Service) getDelegateHandler(w http.ResponseWriter, r *http.Request) {
+	delegateURL := strings.TrimSuffix(normalizeAccURL(mux.Vars(r)["url"]), "/")
+
+	info, err := s.getDelegateInfo(delegateURL, s.latestBalances(r))
+	if err != nil {
+		log.Printf("Error querying delegate %s: %v", delegateURL, err)
+		http.Error(w, "Failed to query staking registry", http.StatusInternalServerError)
+		return
+	}
+	if !info.Registered && len(info.Delegators) == 0 {
+		http.Error(w, "Identity is neither registered nor delegated to", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// DelegationGraph is the delegation graph of a network. Nodes are identities;
+// an edge is a staking account delegating to an identity.
+type DelegationGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is an identity of the delegation graph
+type GraphNode struct {
+	Identity        string `json:"identity"`
+	Registered      bool   `json:"registered"`
+	RejectDelegates bool   `json:"rejectDelegates"`
+}
+
+// GraphEdge is a delegation. Invalid is set if the delegate is not registered
+// or rejects delegates.
+type GraphEdge struct {
+	From    string  `json:"from"` // Delegating identity
+	To      string  `json:"to"`   // Delegate identity
+	Account string  `json:"account"`
+	Balance *Amount `json:"balance,omitempty"`
+	Invalid bool    `json:"invalid"`
+}
+
+// getDelegationGraph builds the delegation graph of all stored identities
+func (s *Service) getDelegationGraph(supply *Supply) (*DelegationGraph, error) {
+	identities, err := s.getAllIdentitiesFromDB()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &DelegationGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	nodes := map[string]bool{}
+	addNode := func(url string) {
+		if nodes[url] {
+			return
+		}
+		nodes[url] = true
+		node := GraphNode{Identity: url}
+		if identity, ok := identities[url]; ok {
+			node.Registered = identityStatus(identity) == "registered"
+			node.RejectDelegates = identity.RejectDelegates
+		}
+		graph.Nodes = append(graph.Nodes, node)
+	}
+
+	for url, identity := range identities {
+		for _, account := range identity.Accounts {
+			if account.Delegate == "" {
+				continue
+			}
+			addNode(url)
+			addNode(account.Delegate)
+
+			edge := GraphEdge{From: url, To: account.Delegate, Account: account.Url}
+			delegate, ok := identities[account.Delegate]
+			edge.Invalid = !ok || identityStatus(delegate) != "registered" || delegate.RejectDelegates
+			if supply != nil && supply.Balances != nil {
+				balance, ok := supply.Balances[account.Url]
+				if !ok {
+					balance = new(big.Int)
+				}
+				amount := newAmount(balance, supply.Precision)
+				edge.Balance = &amount
+			}
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].Identity < graph.Nodes[j].Identity })
+	sort.Slice(graph.Edges, func(i, j int) bool { return graph.Edges[i].Account < graph.Edges[j].Account })
+	return graph, nil
+}
+
+// writeDOT writes the graph in GraphViz DOT format. Unregistered delegates
+// are dashed, delegates that reject delegates are red, and invalid
+// delegations are red edges.
+func (g *DelegationGraph) writeDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph delegations {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, node := range g.Nodes {
+		var attrs []string
+		if !node.Registered {
+			attrs = append(attrs, "style=dashed")
+		}
+		if node.RejectDelegates {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(w, "  %q%s;\n", node.Identity, dotAttrs(attrs))
+	}
+	for _, edge := range g.Edges {
+		label := edge.Account
+		if edge.Balance != nil {
+			label += "\n" + edge.Balance.Decimal + " ACME"
+		}
+		attrs := []string{fmt.Sprintf("label=%q", label)}
+		if edge.Invalid {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(w, "  %q -> %q%s;\n", edge.From, edge.To, dotAttrs(attrs))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func dotAttrs(attrs []string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(attrs, ", ") + "]"
+}
+
+// Get delegation graph handler
+func (s *Service) getDelegationGraphHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		http.Error(w, fmt.Sprintf("invalid format %q (want json or dot)", format), http.StatusBadRequest)
+		return
+	}
+
+	graph, err := s.getDelegationGraph(s.latestBalances(r))
+	if err != nil {
+		log.Printf("Error building delegation graph: %v", err)
+		http.Error(w, "Failed to build delegation graph", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		graph.writeDOT(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDelegates(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptStakers(t, fake)
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://dave.acme/staking", TokenURL: acmeTokenURL, Balance: "20000000000000"})
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://dave.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://dave.acme/staking", Delegate: "acc://carol.acme", Lockup: 2}},
+	})
+	server := newTestServer(t, fake)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	rec := get(t, router, "/staking/delegates/alice.acme")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var info DelegateInfo
+	decode(t, rec, &info)
+	if !info.Registered || !info.AcceptsDelegates || len(info.Delegators) != 1 {
+		t.Fatalf("alice = %+v", info)
+	}
+	bob := info.Delegators[0]
+	if bob.Account != "acc://bob.acme/staking" || bob.Identity != "acc://bob.acme" || bob.Type != "delegated" || bob.Balance == nil || bob.Balance.Raw != "50000000000000" {
+		t.Errorf("bob = %+v", bob)
+	}
+	if info.TotalDelegated == nil || info.TotalDelegated.Raw != "50000000000000" {
+		t.Errorf("total = %+v", info.TotalDelegated)
+	}
+
+	decode(t, get(t, router, "/staking/delegates/carol.acme"), &info)
+	if !info.Registered || !info.RejectDelegates || info.AcceptsDelegates || len(info.Delegators) != 1 || info.Delegators[0].Lockup != 2 {
+		t.Errorf("carol = %+v", info)
+	}
+
+	if rec := get(t, router, "/staking/delegates/nobody.acme"); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = get(t, router, "/staking/graph")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var graph DelegationGraph
+	decode(t, rec, &graph)
+	if len(graph.Nodes) != 4 || len(graph.Edges) != 2 {
+		t.Fatalf("graph = %+v", graph)
+	}
+	for _, edge := range graph.Edges {
+		if want := edge.To == "acc://carol.acme"; edge.Invalid != want {
+			t.Errorf("edge %+v: invalid = %v, want %v", edge, edge.Invalid, want)
+		}
+	}
+
+	rec = get(t, router, "/staking/graph?format=dot")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/vnd.graphviz" {
+		t.Fatalf("status %d (%s): %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	dot := rec.Body.String()
+	if !strings.HasPrefix(dot, "digraph delegations {") || !strings.Contains(dot, `"acc://dave.acme" -> "acc://carol.acme" [label="acc://dave.acme/staking\n200000.00000000 ACME", color=red];`) {
+		t.Errorf("dot = %s", dot)
+	}
+
+	if rec := get(t, router, "/staking/graph?format=svg"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
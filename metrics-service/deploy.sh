@@ -22,8 +22,11 @@ ssh $SERVER "mkdir -p $DEPLOY_DIR"
 # Copy binary to /tmp first, then move
 scp metrics-service $SERVER:/tmp/metrics-service-new
 scp README.md $SERVER:/tmp/README-new.md
+scp config.example.yaml $SERVER:/tmp/config-example.yaml
 ssh $SERVER "mv /tmp/metrics-service-new $DEPLOY_DIR/metrics-service && \
              mv /tmp/README-new.md $DEPLOY_DIR/README.md && \
+             mv /tmp/config-example.yaml $DEPLOY_DIR/config.example.yaml && \
+             (test -f $DEPLOY_DIR/config.yaml || cp $DEPLOY_DIR/config.example.yaml $DEPLOY_DIR/config.yaml) && \
              chmod +x $DEPLOY_DIR/metrics-service && \
              chown -R accumulate:accumulate $DEPLOY_DIR"
 
@@ -37,7 +40,8 @@ After=network.target
 Type=simple
 User=accumulate
 WorkingDirectory=/opt/accumulate-metrics
-ExecStart=/opt/accumulate-metrics/metrics-service
+EnvironmentFile=-/etc/default/accumulate-metrics
+ExecStart=/opt/accumulate-metrics/metrics-service -config /opt/accumulate-metrics/config.yaml
 Restart=always
 RestartSec=10
 StandardOutput=journal
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// fakeAccumulate is an in-process Accumulate API serving scripted v3
+// JSON-RPC query responses on /v3 and v2 responses on /timestamp/
+type fakeAccumulate struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	accounts     map[string]*AccountRecord
+	chains       map[string][]string // scope + "#" + chain name -> entry hashes
+	transactions map[string]*TransactionRecord
+	timestamps   map[string][]ChainEntry
+	majorBlocks  map[uint64]*MajorBlockRecord
+	minorBlocks  map[string]*MinorBlockRecord // scope + "#" + index
+	calls        map[string]int
+	scopes       map[string]int
+	failures     map[string]int // scope -> remaining queries that fail
+	noBatch      bool
+	batchErrors  int // Remaining batch requests that fail with a single error
+	gate         chan struct{}
+}
+
+func newFakeAccumulate(t *testing.T) *fakeAccumulate {
+	t.Helper()
+
+	f := &fakeAccumulate{
+		accounts:     map[string]*AccountRecord{},
+		chains:       map[string][]string{},
+		transactions: map[string]*TransactionRecord{},
+		timestamps:   map[string][]ChainEntry{},
+		majorBlocks:  map[uint64]*MajorBlockRecord{},
+		minorBlocks:  map[string]*MinorBlockRecord{},
+		calls:        map[string]int{},
+		scopes:       map[string]int{},
+		failures:     map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3", f.serveV3)
+	mux.HandleFunc("/timestamp/", f.serveTimestamp)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// Client returns an HTTP client pointed at the fake
+func (f *fakeAccumulate) Client() AccumulateClient {
+	return NewHTTPClient(f.server.URL+"/v3", f.server.URL)
+}
+
+// SetAccount adds or replaces an account record
+func (f *fakeAccumulate) SetAccount(account AccountRecord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accounts[account.URL] = &account
+}
+
+// SetTransaction adds or replaces the transaction record returned for scope
+func (f *fakeAccumulate) SetTransaction(scope string, tx TransactionRecord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.transactions[scope] = &tx
+}
+
+// SetTimestamp sets the v2 /timestamp chain entries for txid
+func (f *fakeAccumulate) SetTimestamp(txid string, chains []ChainEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timestamps[txid] = chains
+}
+
+// AddRegistration appends a writeData entry holding the JSON encoding of
+// entry to the staking registry's main chain and returns its hash
+func (f *fakeAccumulate) AddRegistration(t *testing.T, entry interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := stakingRegistryURL + "#main"
+	sum := sha256.Sum256(append(data, byte(len(f.chains[key]))))
+	hash := hex.EncodeToString(sum[:])
+	f.chains[key] = append(f.chains[key], hash)
+
+	tx := &TransactionRecord{Status: "delivered"}
+	tx.Message.Transaction.Body.Type = "writeData"
+	tx.Message.Transaction.Body.Entry.Data = []string{hex.EncodeToString(data)}
+	f.transactions["acc://"+hash+"@staking.acme/registered"] = tx
+	return hash
+}
+
+// SetMajorBlock serves a Directory Network major block starting at start
+// with its first minor block
+func (f *fakeAccumulate) SetMajorBlock(index uint64, start time.Time, firstMinor uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	block := &MajorBlockRecord{Index: index, Time: start}
+	block.MinorBlocks.Records = []MinorBlockRecord{{Index: firstMinor, Time: &start}}
+	block.MinorBlocks.Total = 1
+	f.majorBlocks[index] = block
+}
+
+// SetMinorBlock serves a minor block of the partition at scope
+func (f *fakeAccumulate) SetMinorBlock(scope string, index uint64, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.minorBlocks[fmt.Sprintf("%s#%d", scope, index)] = &MinorBlockRecord{Index: index, Time: &t}
+}
+
+// FailScope makes the next n queries for scope fail with an internal error
+func (f *fakeAccumulate) FailScope(scope string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[scope] = n
+}
+
+// DisableBatch makes the fake reject JSON-RPC batch requests
+func (f *fakeAccumulate) DisableBatch() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.noBatch = true
+}
+
+// FailBatch makes the next n batch requests fail with a single rate limit
+// error
+func (f *fakeAccumulate) FailBatch(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batchErrors = n
+}
+
+// Calls returns the number of requests served for kind, which is one of
+// "account", "chain", "range", "transaction", "block", "timestamp" or "batch"
+func (f *fakeAccumulate) Calls(kind string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[kind]
+}
+
+// ScopeCalls returns the number of v3 queries served for scope
+func (f *fakeAccumulate) ScopeCalls(scope string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.scopes[scope]
+}
+
+// Hold blocks v3 requests until the returned function is called
+func (f *fakeAccumulate) Hold() (release func()) {
+	gate := make(chan struct{})
+	f.mu.Lock()
+	f.gate = gate
+	f.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			f.gate = nil
+			f.mu.Unlock()
+			close(gate)
+		})
+	}
+}
+
+func (f *fakeAccumulate) serveV3(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	gate := f.gate
+	f.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) == 0 || body[0] != '[' {
+		var req fakeRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(f.serveRequest(&req))
+		return
+	}
+
+	f.mu.Lock()
+	f.calls["batch"]++
+	noBatch, failed := f.noBatch, f.batchErrors > 0
+	if failed {
+		f.batchErrors--
+	}
+	f.mu.Unlock()
+	switch {
+	case noBatch:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      nil,
+			"error":   &RPCError{Code: rpcInvalidRequest, Message: "batch requests are not supported"},
+		})
+		return
+	case failed:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      nil,
+			"error":   &RPCError{Code: -32000, Message: "rate limit exceeded"},
+		})
+		return
+	}
+
+	var batch []fakeRequest
+	if err := json.Unmarshal(body, &batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	responses := make([]interface{}, len(batch))
+	for i := range batch {
+		responses[i] = f.serveRequest(&batch[i])
+	}
+	json.NewEncoder(w).Encode(responses)
+}
+
+// fakeRequest is a v3 JSON-RPC query request
+type fakeRequest struct {
+	ID     interface{} `json:"id"`
+	Method string      `json:"method"`
+	Params struct {
+		Scope string `json:"scope"`
+		Query struct {
+			QueryType string  `json:"queryType"`
+			Name      string  `json:"name"`
+			Major     *uint64 `json:"major"`
+			Minor     *uint64 `json:"minor"`
+			Range     *struct {
+				Start int64 `json:"start"`
+				Count int64 `json:"count"`
+			} `json:"range"`
+		} `json:"query"`
+	} `json:"params"`
+}
+
+func (f *fakeAccumulate) serveRequest(req *fakeRequest) map[string]interface{} {
+	var result interface{}
+	var rpcErr *RPCError
+	if q := req.Params.Query; q.QueryType == "block" {
+		result, rpcErr = f.queryBlock(req.Params.Scope, q.Major, q.Minor)
+	} else {
+		result, rpcErr = f.query(req.Params.Scope, q.QueryType, q.Name, q.Range)
+	}
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+func (f *fakeAccumulate) query(scope, queryType, name string, rng *struct {
+	Start int64 `json:"start"`
+	Count int64 `json:"count"`
+}) (interface{}, *RPCError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scopes[scope]++
+	if f.failures[scope] > 0 {
+		f.failures[scope]--
+		return nil, &RPCError{Code: -32603, Message: "internal error querying " + scope}
+	}
+	notFound := &RPCError{Code: -33404, Message: scope + " not found"}
+
+	if queryType == "chain" {
+		if rng == nil {
+			f.calls["chain"]++
+			var records []map[string]interface{}
+			prefix := scope + "#"
+			for key, entries := range f.chains {
+				if strings.HasPrefix(key, prefix) {
+					records = append(records, map[string]interface{}{
+						"name":  strings.TrimPrefix(key, prefix),
+						"count": len(entries),
+					})
+				}
+			}
+			if records == nil {
+				return nil, notFound
+			}
+			return map[string]interface{}{"records": records}, nil
+		}
+
+		f.calls["range"]++
+		entries, ok := f.chains[scope+"#"+name]
+		if !ok {
+			return nil, notFound
+		}
+		records := []ChainRecord{}
+		for i := rng.Start; i < rng.Start+rng.Count && i < int64(len(entries)); i++ {
+			records = append(records, ChainRecord{Index: uint64(i), Entry: entries[i]})
+		}
+		return map[string]interface{}{"records": records}, nil
+	}
+
+	if tx, ok := f.transactions[scope]; ok {
+		f.calls["transaction"]++
+		return tx, nil
+	}
+	if strings.Contains(scope, "@") {
+		f.calls["transaction"]++
+		return nil, notFound
+	}
+
+	f.calls["account"]++
+	if account, ok := f.accounts[scope]; ok {
+		return map[string]interface{}{"account": account}, nil
+	}
+	return nil, notFound
+}
+
+func (f *fakeAccumulate) queryBlock(scope string, major, minor *uint64) (interface{}, *RPCError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls["block"]++
+	f.scopes[scope]++
+	if f.failures[scope] > 0 {
+		f.failures[scope]--
+		return nil, &RPCError{Code: -32603, Message: "internal error querying " + scope}
+	}
+	switch {
+	case major != nil:
+		if block, ok := f.majorBlocks[*major]; ok && scope == directoryURL {
+			return block, nil
+		}
+	case minor != nil:
+		if block, ok := f.minorBlocks[fmt.Sprintf("%s#%d", scope, *minor)]; ok {
+			return block, nil
+		}
+	}
+	return nil, &RPCError{Code: -33404, Message: "block not found"}
+}
+
+func (f *fakeAccumulate) serveTimestamp(w http.ResponseWriter, r *http.Request) {
+	txid := strings.TrimPrefix(r.URL.Path, "/timestamp/")
+	if idx := strings.Index(txid, "@"); idx >= 0 {
+		txid = txid[:idx]
+	}
+
+	f.mu.Lock()
+	f.calls["timestamp"]++
+	chains := f.timestamps[txid]
+	f.mu.Unlock()
+
+	if chains == nil {
+		chains = []ChainEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"chains": chains})
+}
+
+// newTestServer returns a mainnet server backed by a fresh database and the fake
+func newTestServer(t *testing.T, fake *fakeAccumulate) *Server {
+	t.Helper()
+	return newMultiNetworkServer(t, DefaultConfig(), map[string]*fakeAccumulate{"mainnet": fake})
+}
+
+// newMultiNetworkServer returns a server for config backed by a fresh
+// database, with each network talking to the fake of the same name
+func newMultiNetworkServer(t *testing.T, config *Config, fakes map[string]*fakeAccumulate) *Server {
+	t.Helper()
+
+	db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "metrics.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return newServerWithDB(t, config, db, fakes)
+}
+
+// newServerWithDB returns a server for config backed by db, migrated as on
+// startup, with each network talking to the fake of the same name
+func newServerWithDB(t *testing.T, config *Config, db *leveldb.DB, fakes map[string]*fakeAccumulate) *Server {
+	t.Helper()
+
+	server := NewServer(config, db, func(n *NetworkConfig) AccumulateClient {
+		fake, ok := fakes[n.Name]
+		if !ok {
+			t.Fatalf("no fake for network %s", n.Name)
+		}
+		return fake.Client()
+	})
+	if err := server.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// syncRegistry ingests the staking registry of every network of server, as
+// the background updater does
+func syncRegistry(t *testing.T, server *Server) {
+	t.Helper()
+	for _, name := range server.Names() {
+		service, _ := server.Network(name)
+		if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// historyPrefix is the key prefix of registration revisions:
+// history:{identity URL}:{chain index} -> Revision
+const historyPrefix = "history:"
+
+// Revision is one registration entry of an identity. Revisions are written
+// once, when the entry is ingested, and never modified.
+type Revision struct {
+	Index        int64                 `json:"index"`                // Chain index of the registry entry
+	Hash         string                `json:"hash"`                 // Entry (transaction) hash
+	Time         string                `json:"time,omitempty"`       // Transaction time (RFC 3339)
+	MinorBlock   int64                 `json:"minorBlock,omitempty"` // Minor block of the transaction, if known
+	Registration *RegistrationIdentity `json:"registration"`         // Normalized registration
+}
+
+// FieldChange is a change of one registration field between revisions.
+// Account fields are named accounts[{url}].{field}.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// RevisionDiff is a revision with its changes from the previous revision
+type RevisionDiff struct {
+	*Revision
+	Changes []FieldChange `json:"changes"`
+}
+
+// IdentityHistory is the registration timeline of an identity
+type IdentityHistory struct {
+	Identity  string         `json:"identity"`
+	Revisions []RevisionDiff `json:"revisions"`
+}
+
+func (s *Service) historyKey(identityURL string, index int64) []byte {
+	return s.key(fmt.Sprintf("%s%s:%020d", historyPrefix, identityURL, index))
+}
+
+// putRevision adds a revision to batch
+func (s *Service) putRevision(batch *leveldb.Batch, identityURL string, revision *Revision) error {
+	data, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.historyKey(identityURL, revision.Index), data)
+	return nil
+}
+
+// getRevisions returns the revisions of an identity in chain order
+func (s *Service) getRevisions(identityURL string) ([]*Revision, error) {
+	var revisions []*Revision
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(historyPrefix+identityURL+":")), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var revision Revision
+		if err := json.Unmarshal(iter.Value(), &revision); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	return revisions, iter.Error()
+}
+
+// registryEntryTime returns the time and minor block of a registry
+// transaction: the block time if the v2 API knows it, otherwise the oldest
+// signature timestamp
+func (s *Service) registryEntryTime(ctx context.Context, hash string, tx *TransactionRecord) (string, int64) {
+	chains, err := s.client.QueryTimestamp(ctx, hash)
+	if err == nil && len(chains) > 0 && chains[0].Block > 0 {
+		return chains[0].Time, chains[0].Block
+	}
+
+	if ts := oldestSignatureTimestamp(tx.Signatures, 0); ts > 0 {
+		return time.UnixMilli(ts).UTC().Format(time.RFC3339), 0
+	}
+	return "", 0
+}
+
+// getIdentityHistory returns the timeline of an identity with per-revision
+// field diffs
+func (s *Service) getIdentityHistory(identityURL string) (*IdentityHistory, error) {
+	revisions, err := s.getRevisions(identityURL)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &IdentityHistory{Identity: identityURL, Revisions: []RevisionDiff{}}
+	previous := map[string]interface{}{}
+	for _, revision := range revisions {
+		fields := registrationFields(revision.Registration)
+		history.Revisions = append(history.Revisions, RevisionDiff{
+			Revision: revision,
+			Changes:  diffFields(previous, fields),
+		})
+		previous = fields
+	}
+	return history, nil
+}
+
+// registrationFields flattens the non-zero fields of a normalized
+// registration. Accounts are keyed by URL so reordering them is not a change.
+func registrationFields(r *RegistrationIdentity) map[string]interface{} {
+	fields := map[string]interface{}{}
+	set := func(name string, value interface{}) {
+		if !reflect.ValueOf(value).IsZero() {
+			fields[name] = value
+		}
+	}
+
+	set("status", r.Status)
+	set("delegatorPayout", r.DelegatorPayout)
+	set("rejectDelegates", r.RejectDelegates)
+	set("acceptingDelegates", r.AcceptingDelegates)
+	for _, a := range r.Accounts {
+		prefix := "accounts[" + a.Url + "]."
+		fields[prefix+"url"] = a.Url
+		set(prefix+"type", a.Type)
+		set(prefix+"payout", a.Payout)
+		set(prefix+"delegate", a.Delegate)
+		set(prefix+"lockup", a.Lockup)
+		set(prefix+"hardLock", a.HardLock)
+	}
+	return fields
+}
+
+// diffFields returns the fields that differ between old and new, sorted by name
+func diffFields(old, new map[string]interface{}) []FieldChange {
+	changes := []FieldChange{}
+	for name, value := range new {
+		if previous, ok := old[name]; !ok || previous != value {
+			changes = append(changes, FieldChange{Field: name, Old: old[name], New: value})
+		}
+	}
+	for name, value := range old {
+		if _, ok := new[name]; !ok {
+			changes = append(changes, FieldChange{Field: name, Old: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// normalizeAccURL converts a URL from a route variable to acc://... form.
+// HTTP clients may collapse acc:// to acc:/ or drop the scheme entirely.
+func normalizeAccURL(u string) string {
+	switch {
+	case strings.HasPrefix(u, "acc://"):
+		return u
+	case strings.HasPrefix(u, "acc:/"):
+		return "acc://" + u[5:]
+	default:
+		return "acc://" + u
+	}
+}
+
+// Get identity registration history handler
+func (s *Service) getIdentityHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	identityURL := strings.TrimSuffix(normalizeAccURL(mux.Vars(r)["adi"]), "/")
+
+	history, err := s.getIdentityHistory(identityURL)
+	if err != nil {
+		log.Printf("Error reading history of %s: %v", identityURL, err)
+		http.Error(w, "Failed to read registration history", http.StatusInternalServerError)
+		return
+	}
+	if len(history.Revisions) == 0 {
+		http.Error(w, "Identity not found in staking registry", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestIdentityHistory(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	joined := fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://bob.acme/staking", Payout: "acc://bob.acme/rewards", Delegate: "acc://alice.acme"}},
+	})
+	fake.SetTimestamp(joined, []ChainEntry{{Chain: "main", Block: 42, Time: "2026-02-21T19:22:52Z"}})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered"})
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://bob.acme/staking", Payout: "acc://bob.acme/rewards", Delegate: "acc://carol.acme"}},
+	})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "deleted"})
+	server := newTestServer(t, fake)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	rec := get(t, router, "/staking/identities/bob.acme/history")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var history IdentityHistory
+	decode(t, rec, &history)
+	if history.Identity != "acc://bob.acme" || len(history.Revisions) != 3 {
+		t.Fatalf("history = %+v", history)
+	}
+
+	first := history.Revisions[0]
+	if first.Index != 0 || first.Hash != joined || first.Time != "2026-02-21T19:22:52Z" || first.MinorBlock != 42 {
+		t.Errorf("first revision = %+v", first.Revision)
+	}
+	if len(first.Changes) != 5 {
+		t.Errorf("first revision changes = %+v, want every set field", first.Changes)
+	}
+
+	changed := history.Revisions[1]
+	if changed.Index != 2 || len(changed.Changes) != 1 {
+		t.Fatalf("second revision = %+v", changed)
+	}
+	want := FieldChange{Field: "accounts[acc://bob.acme/staking].delegate", Old: "acc://alice.acme", New: "acc://carol.acme"}
+	if changed.Changes[0] != want {
+		t.Errorf("change = %+v, want %+v", changed.Changes[0], want)
+	}
+
+	// The deletion removes the identity but not its history
+	deleted := history.Revisions[2]
+	if deleted.Registration.Status != "deleted" {
+		t.Errorf("last revision status = %q", deleted.Registration.Status)
+	}
+	var statusChanged bool
+	for _, c := range deleted.Changes {
+		if c.Field == "status" && c.Old == "registered" && c.New == "deleted" {
+			statusChanged = true
+		}
+	}
+	if !statusChanged {
+		t.Errorf("deletion changes = %+v", deleted.Changes)
+	}
+
+	if rec := get(t, router, "/staking/identities/nobody.acme/history"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown identity: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestIdentityHistoryBackfill(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered"})
+	server := newTestServer(t, fake)
+	service, _ := server.Network("mainnet")
+
+	// A database written before the ledger: checkpoint set, no ledger or history
+	if err := service.setLastQueriedIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.setTotalEntries(1); err != nil {
+		t.Fatal(err)
+	}
+
+	syncRegistry(t, server)
+	var history IdentityHistory
+	rec := get(t, server.Router(), "/staking/identities/acc:/alice.acme/history")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	decode(t, rec, &history)
+	if len(history.Revisions) != 1 {
+		t.Errorf("revisions = %+v", history.Revisions)
+	}
+}
+
+func TestIdentityHistoryBackfillKeepsLiveIdentities(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered"})
+	current := RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered", Accounts: []Account{{Type: "pure", Url: "acc://alice.acme/staking"}}}
+	updated := fake.AddRegistration(t, current)
+	server := newTestServer(t, fake)
+	service, _ := server.Network("mainnet")
+
+	// A database written before the ledger holds the current registration
+	data, err := json.Marshal(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.db.Put(service.key(identityPrefix+current.Identity), data, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.setLastQueriedIndex(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// An incomplete rebuild leaves it in place, not rolled back to entry 0
+	fake.FailScope(registrationScope(updated), 100)
+	if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err == nil {
+		t.Error("expected an error for the failed entry")
+	}
+	if stored, err := service.getIdentityFromDB(current.Identity); err != nil || len(stored.Accounts) != 1 {
+		t.Errorf("identity during rebuild = %+v, %v", stored, err)
+	}
+
+	fake.FailScope(registrationScope(updated), 0)
+	syncRegistry(t, server)
+	if stored, err := service.getIdentityFromDB(current.Identity); err != nil || len(stored.Accounts) != 1 {
+		t.Errorf("rebuilt identity = %+v, %v", stored, err)
+	}
+	if entry, err := service.getRegistryEntry(1); err != nil || entry == nil || entry.Outcome != entryApplied {
+		t.Errorf("ledger entry 1 = %+v, %v", entry, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Secondary index key prefixes. Multi-valued indexes append the value to the
+// key after a NUL separator, which cannot occur in a URL.
+const (
+	accountIndexPrefix  = "index:account:"  // account URL -> identity URL
+	payoutIndexPrefix   = "index:payout:"   // payout URL \x00 identity URL -> ""
+	delegateIndexPrefix = "index:delegate:" // delegate URL \x00 account URL -> identity URL
+	lockupIndexPrefix   = "index:lockup:"   // account URL -> Lockup
+	indexVersionKey     = "metadata:indexVersion"
+)
+
+// indexVersion is bumped when the indexes change so they are rebuilt
+const indexVersion = "2"
+
+// indexSeparator separates the parts of a multi-valued index key
+const indexSeparator = "\x00"
+
+// indexIdentity adds the index entries of an identity to batch
+func (s *Service) indexIdentity(batch *leveldb.Batch, identityURL string, identity *RegistrationIdentity) {
+	if identity.DelegatorPayout != "" {
+		batch.Put(s.key(payoutIndexPrefix+identity.DelegatorPayout+indexSeparator+identityURL), nil)
+	}
+	for _, account := range identity.Accounts {
+		batch.Put(s.key(accountIndexPrefix+account.Url), []byte(identityURL))
+		if account.Payout != "" {
+			batch.Put(s.key(payoutIndexPrefix+account.Payout+indexSeparator+identityURL), nil)
+		}
+		if account.Delegate != "" {
+			batch.Put(s.key(delegateIndexPrefix+account.Delegate+indexSeparator+account.Url), []byte(identityURL))
+		}
+	}
+}
+
+// unindexIdentity adds the removal of the index entries of an identity to
+// batch. Account entries that now point to another identity are kept.
+func (s *Service) unindexIdentity(batch *leveldb.Batch, identityURL string, identity *RegistrationIdentity) {
+	if identity.DelegatorPayout != "" {
+		batch.Delete(s.key(payoutIndexPrefix + identity.DelegatorPayout + indexSeparator + identityURL))
+	}
+	for _, account := range identity.Accounts {
+		if owner, err := s.db.Get(s.key(accountIndexPrefix+account.Url), nil); err == nil && string(owner) == identityURL {
+			batch.Delete(s.key(accountIndexPrefix + account.Url))
+			batch.Delete(s.key(lockupIndexPrefix + account.Url))
+		}
+		if account.Payout != "" {
+			batch.Delete(s.key(payoutIndexPrefix + account.Payout + indexSeparator + identityURL))
+		}
+		if account.Delegate != "" {
+			batch.Delete(s.key(delegateIndexPrefix + account.Delegate + indexSeparator + account.Url))
+		}
+	}
+}
+
+// putIdentity adds the replacement of an identity and its index entries to
+// batch. A nil identity deletes it.
+func (s *Service) putIdentity(batch *leveldb.Batch, identityURL string, identity *RegistrationIdentity) error {
+	previous, err := s.getIdentityFromDB(identityURL)
+	switch {
+	case err == nil:
+		s.unindexIdentity(batch, identityURL, previous)
+	case !errors.Is(err, leveldb.ErrNotFound):
+		return err
+	}
+
+	if identity == nil {
+		batch.Delete(s.key(identityPrefix + identityURL))
+		return nil
+	}
+
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.key(identityPrefix+identityURL), data)
+	s.indexIdentity(batch, identityURL, identity)
+	return nil
+}
+
+// ensureIndexes rebuilds the secondary indexes if they were built by an
+// older version or not at all
+func (s *Service) ensureIndexes() error {
+	version, err := s.db.Get(s.key(indexVersionKey), nil)
+	if err == nil && string(version) == indexVersion {
+		return nil
+	}
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	for _, prefix := range []string{accountIndexPrefix, payoutIndexPrefix, delegateIndexPrefix, lockupIndexPrefix} {
+		iter := s.db.NewIterator(util.BytesPrefix(s.key(prefix)), nil)
+		for iter.Next() {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+
+	identities, err := s.getAllIdentitiesFromDB()
+	if err != nil {
+		return err
+	}
+	for identityURL, identity := range identities {
+		s.indexIdentity(batch, identityURL, identity)
+		revisions, err := s.getRevisions(identityURL)
+		if err != nil {
+			return err
+		}
+		if err := s.indexLockups(batch, identityURL, identity, revisions); err != nil {
+			return err
+		}
+	}
+	batch.Put(s.key(indexVersionKey), []byte(indexVersion))
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to write indexes: %w", err)
+	}
+	log.Printf("[%s] Rebuilt staking indexes for %d identities", s.network.Name, len(identities))
+	return nil
+}
+
+// getIdentityOfAccount returns the identity that registered a staking account
+func (s *Service) getIdentityOfAccount(accountURL string) (string, *RegistrationIdentity, error) {
+	identityURL, err := s.db.Get(s.key(accountIndexPrefix+accountURL), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	identity, err := s.getIdentityFromDB(string(identityURL))
+	if err != nil {
+		return "", nil, err
+	}
+	return string(identityURL), identity, nil
+}
+
+// getIdentitiesByPayout returns the identities that pay rewards to a URL,
+// either from one of their accounts or as their delegator payout
+func (s *Service) getIdentitiesByPayout(payoutURL string) ([]string, error) {
+	prefix := s.key(payoutIndexPrefix + payoutURL + indexSeparator)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	var identities []string
+	for iter.Next() {
+		identities = append(identities, string(iter.Key()[len(prefix):]))
+	}
+	return identities, iter.Error()
+}
+
+// DelegatedAccount is a staking account delegated to an identity
+type DelegatedAccount struct {
+	Account  string
+	Identity string
+}
+
+// getDelegatedAccounts returns the accounts that delegate to an identity
+func (s *Service) getDelegatedAccounts(delegateURL string) ([]DelegatedAccount, error) {
+	prefix := s.key(delegateIndexPrefix + delegateURL + indexSeparator)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	var accounts []DelegatedAccount
+	for iter.Next() {
+		accounts = append(accounts, DelegatedAccount{
+			Account:  string(iter.Key()[len(prefix):]),
+			Identity: string(iter.Value()),
+		})
+	}
+	return accounts, iter.Error()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestStakingIndexes(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	server := newTestServer(t, fake)
+	service, _ := server.Network("mainnet")
+	router := server.Router()
+	syncRegistry(t, server)
+
+	delegated, err := service.getDelegatedAccounts("acc://alice.acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DelegatedAccount{{Account: "acc://bob.acme/staking", Identity: "acc://bob.acme"}}
+	if !reflect.DeepEqual(delegated, want) {
+		t.Errorf("delegated to alice = %+v, want %+v", delegated, want)
+	}
+
+	// Changing the delegate moves the index entry
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://bob.acme/staking", Payout: "acc://alice.acme/rewards", Delegate: "acc://carol.acme"}},
+	})
+	syncRegistry(t, server)
+
+	if delegated, _ := service.getDelegatedAccounts("acc://alice.acme"); len(delegated) != 0 {
+		t.Errorf("delegated to alice after change = %+v", delegated)
+	}
+	if delegated, _ := service.getDelegatedAccounts("acc://carol.acme"); len(delegated) != 1 {
+		t.Errorf("delegated to carol = %+v", delegated)
+	}
+
+	rec := get(t, router, "/staking/payouts/alice.acme/rewards")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var payout PayoutInfo
+	decode(t, rec, &payout)
+	if !reflect.DeepEqual(payout.Identities, []string{"acc://alice.acme", "acc://bob.acme"}) {
+		t.Errorf("identities paying alice's rewards = %v", payout.Identities)
+	}
+	if rec := get(t, router, "/staking/payouts/bob.acme/rewards"); rec.Code != http.StatusNotFound {
+		t.Errorf("old payout: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// Lookups are point reads and work while the network is unreachable
+	fake.server.Close()
+	if rec := get(t, router, "/staking/stakers/bob.acme/staking"); rec.Code != http.StatusOK {
+		t.Errorf("lookup while offline: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStakingIndexesRebuild(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	server := newTestServer(t, fake)
+	service, _ := server.Network("mainnet")
+	syncRegistry(t, server)
+
+	// A database written before the indexes existed
+	if err := service.db.Delete(service.key(accountIndexPrefix+"acc://bob.acme/staking"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.db.Delete(service.key(indexVersionKey), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	syncRegistry(t, server)
+	if _, err := service.queryStakingAccount("acc://bob.acme/staking"); err != nil {
+		t.Errorf("index not rebuilt: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// monthsPerQuarter converts lockup quarters to calendar months
+const monthsPerQuarter = 3
+
+// maxUnlockPeriods bounds the number of periods of an unlock calendar
+const maxUnlockPeriods = 1000
+
+var errTooManyPeriods = fmt.Errorf("more than %d periods", maxUnlockPeriods)
+
+// Lockup is the lockup of a staking account. It started with the registry
+// entry that first set the account's current lockup; re-registering the same
+// lockup does not restart it.
+type Lockup struct {
+	Account    string `json:"account"`
+	Identity   string `json:"identity"`
+	Quarters   uint64 `json:"quarters"`
+	HardLock   bool   `json:"hardLock"`
+	Start      string `json:"start,omitempty"` // Time of the starting entry (RFC 3339), if known
+	StartIndex int64  `json:"startIndex"`      // Chain index of the starting entry
+
+	// Computed when served
+	Unlock           string  `json:"unlock,omitempty"`           // Start + 3 months per quarter (RFC 3339)
+	UnlockMajorBlock int64   `json:"unlockMajorBlock,omitempty"` // Major block of the unlock
+	Balance          *Amount `json:"balance,omitempty"`          // As of the latest supply refresh, if known
+}
+
+// lockupStarts returns, for each account of the last revision, the revision
+// its current lockup and hard lock were set by
+func lockupStarts(revisions []*Revision) map[string]*Revision {
+	type state struct {
+		lockup   uint64
+		hardLock bool
+		start    *Revision
+	}
+	states := map[string]state{}
+	for _, revision := range revisions {
+		current := map[string]state{}
+		for _, account := range revision.Registration.Accounts {
+			previous, ok := states[account.Url]
+			if ok && previous.lockup == account.Lockup && previous.hardLock == account.HardLock {
+				current[account.Url] = previous
+			} else {
+				current[account.Url] = state{account.Lockup, account.HardLock, revision}
+			}
+		}
+		states = current
+	}
+
+	starts := map[string]*Revision{}
+	for url, state := range states {
+		starts[url] = state.start
+	}
+	return starts
+}
+
+// indexLockups adds the lockup entries of the locked accounts of an identity
+// to batch, given its revisions in chain order
+func (s *Service) indexLockups(batch *leveldb.Batch, identityURL string, identity *RegistrationIdentity, revisions []*Revision) error {
+	starts := lockupStarts(revisions)
+	for _, account := range identity.Accounts {
+		if account.Lockup == 0 {
+			continue
+		}
+		lockup := Lockup{
+			Account:  account.Url,
+			Identity: identityURL,
+			Quarters: account.Lockup,
+			HardLock: account.HardLock,
+		}
+		if start, ok := starts[account.Url]; ok {
+			lockup.Start = start.Time
+			lockup.StartIndex = start.Index
+		}
+		data, err := json.Marshal(lockup)
+		if err != nil {
+			return err
+		}
+		batch.Put(s.key(lockupIndexPrefix+account.Url), data)
+	}
+	return nil
+}
+
+// reindexLockups adds the lockup entries of an identity to batch after
+// revision is ingested. revision is not yet in the database.
+func (s *Service) reindexLockups(batch *leveldb.Batch, identityURL string, identity *RegistrationIdentity, revision *Revision) error {
+	revisions, err := s.getRevisions(identityURL)
+	if err != nil {
+		return err
+	}
+	revisions = append(revisions, revision)
+	sort.SliceStable(revisions, func(i, j int) bool { return revisions[i].Index < revisions[j].Index })
+	return s.indexLockups(batch, identityURL, identity, revisions)
+}
+
+// getLockups returns the lockups of all locked staking accounts
+func (s *Service) getLockups() ([]*Lockup, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(lockupIndexPrefix)), nil)
+	defer iter.Release()
+
+	var lockups []*Lockup
+	for iter.Next() {
+		var lockup Lockup
+		if err := json.Unmarshal(iter.Value(), &lockup); err != nil {
+			return nil, err
+		}
+		lockups = append(lockups, &lockup)
+	}
+	return lockups, iter.Error()
+}
+
+// unlockTime returns the unlock time of a lockup, or false if its start is
+// unknown
+func (l *Lockup) unlockTime() (time.Time, bool) {
+	start, err := time.Parse(time.RFC3339, l.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start.AddDate(0, int(l.Quarters)*monthsPerQuarter, 0), true
+}
+
+// UnlockCalendar is the ACME scheduled to unlock in each period of a range
+type UnlockCalendar struct {
+	From        string         `json:"from"`
+	To          string         `json:"to"`
+	Period      string         `json:"period"`
+	Total       *Amount        `json:"total,omitempty"` // Sum of the unlocking balances, if known
+	Periods     []UnlockPeriod `json:"periods"`
+	Unscheduled []*Lockup      `json:"unscheduled,omitempty"` // Lockups whose start is unknown
+}
+
+// UnlockPeriod is one period of an unlock calendar
+type UnlockPeriod struct {
+	Start   string    `json:"start"`
+	End     string    `json:"end"`
+	Amount  *Amount   `json:"amount,omitempty"`
+	Unlocks []*Lockup `json:"unlocks"`
+}
+
+// unlockPeriods are the period lengths of an unlock calendar
+var unlockPeriods = map[string]func(time.Time) time.Time{
+	"day":     func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"week":    func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"month":   func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	"quarter": func(t time.Time) time.Time { return t.AddDate(0, monthsPerQuarter, 0) },
+}
+
+// getUnlockCalendar returns the lockups that unlock in [from, to), grouped in
+// periods starting at from, with their balances from supply, which may be nil
+func (s *Service) getUnlockCalendar(from, to time.Time, period string, supply *Supply) (*UnlockCalendar, error) {
+	next := unlockPeriods[period]
+	calendar := &UnlockCalendar{
+		From:    from.UTC().Format(time.RFC3339),
+		To:      to.UTC().Format(time.RFC3339),
+		Period:  period,
+		Periods: []UnlockPeriod{},
+	}
+	var totals []*big.Int
+	for start := from; start.Before(to); start = next(start) {
+		if len(calendar.Periods) == maxUnlockPeriods {
+			return nil, errTooManyPeriods
+		}
+		end := next(start)
+		if end.After(to) {
+			end = to
+		}
+		calendar.Periods = append(calendar.Periods, UnlockPeriod{
+			Start:   start.UTC().Format(time.RFC3339),
+			End:     end.UTC().Format(time.RFC3339),
+			Unlocks: []*Lockup{},
+		})
+		totals = append(totals, new(big.Int))
+	}
+
+	lockups, err := s.getLockups()
+	if err != nil {
+		return nil, err
+	}
+	total := new(big.Int)
+	for _, lockup := range lockups {
+		unlock, ok := lockup.unlockTime()
+		if !ok {
+			calendar.Unscheduled = append(calendar.Unscheduled, lockup)
+			continue
+		}
+		if unlock.Before(from) || !unlock.Before(to) {
+			continue
+		}
+		lockup.Unlock = unlock.UTC().Format(time.RFC3339)
+		lockup.UnlockMajorBlock, _ = s.majorBlockAt(unlock)
+
+		// Periods are in order; find the last one starting at or before the unlock
+		i := sort.Search(len(calendar.Periods), func(i int) bool {
+			start, _ := time.Parse(time.RFC3339, calendar.Periods[i].Start)
+			return start.After(unlock)
+		}) - 1
+		if supply != nil && supply.Balances != nil {
+			balance, ok := supply.Balances[lockup.Account]
+			if !ok {
+				balance = new(big.Int)
+			}
+			amount := newAmount(balance, supply.Precision)
+			lockup.Balance = &amount
+			totals[i].Add(totals[i], balance)
+			total.Add(total, balance)
+		}
+		calendar.Periods[i].Unlocks = append(calendar.Periods[i].Unlocks, lockup)
+	}
+
+	for i := range calendar.Periods {
+		sort.Slice(calendar.Periods[i].Unlocks, func(a, b int) bool {
+			x, y := calendar.Periods[i].Unlocks[a], calendar.Periods[i].Unlocks[b]
+			if x.Unlock != y.Unlock {
+				return x.Unlock < y.Unlock
+			}
+			return x.Account < y.Account
+		})
+		if supply != nil && supply.Balances != nil {
+			amount := newAmount(totals[i], supply.Precision)
+			calendar.Periods[i].Amount = &amount
+		}
+	}
+	if supply != nil && supply.Balances != nil {
+		amount := newAmount(total, supply.Precision)
+		calendar.Total = &amount
+	}
+	return calendar, nil
+}
+
+// parseCalendarTime parses an RFC 3339 time or a YYYY-MM-DD date
+func parseCalendarTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or YYYY-MM-DD)", v)
+	}
+	return t, nil
+}
+
+// Get unlock calendar handler
+func (s *Service) getUnlocksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from := s.now().UTC().Truncate(24 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		t, err := parseCalendarTime(v)
+		if err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	to := from.AddDate(1, 0, 0)
+	if v := query.Get("to"); v != "" {
+		t, err := parseCalendarTime(v)
+		if err != nil {
+			http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+	period := query.Get("period")
+	if period == "" {
+		period = "month"
+	}
+	if _, ok := unlockPeriods[period]; !ok {
+		http.Error(w, fmt.Sprintf("invalid period %q (want day, week, month or quarter)", period), http.StatusBadRequest)
+		return
+	}
+
+	calendar, err := s.getUnlockCalendar(from, to, period, s.latestBalances(r))
+	switch {
+	case errors.Is(err, errTooManyPeriods):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error building unlock calendar: %v", err)
+		http.Error(w, "Failed to build unlock calendar", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendar)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUnlockCalendar(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://carol.acme/locked", TokenURL: acmeTokenURL, Balance: "10000000000000"})
+	carol := RegistrationIdentity{
+		Identity:        "acc://carol.acme",
+		Status:          "registered",
+		RejectDelegates: true,
+		Accounts:        []Account{{Type: "pure", Url: "acc://carol.acme/locked", Lockup: 4, HardLock: true}},
+	}
+	fake.SetTimestamp(fake.AddRegistration(t, carol), []ChainEntry{{Chain: "main", Block: 10, Time: "2026-01-01T00:00:00Z"}})
+
+	// Re-registering the same lockup does not restart it
+	carol.DelegatorPayout = "acc://carol.acme/rewards"
+	fake.SetTimestamp(fake.AddRegistration(t, carol), []ChainEntry{{Chain: "main", Block: 20, Time: "2026-03-01T00:00:00Z"}})
+
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://dave.acme/staking", TokenURL: acmeTokenURL, Balance: "20000000000000"})
+	dave := fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://dave.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "pure", Url: "acc://dave.acme/staking", Lockup: 1}},
+	})
+	fake.SetTimestamp(dave, []ChainEntry{{Chain: "main", Block: 30, Time: "2026-02-10T00:00:00Z"}})
+
+	// An entry without block or signature time has an unknown start
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://erin.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "pure", Url: "acc://erin.acme/staking", Lockup: 2}},
+	})
+
+	server := newTestServer(t, fake)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	rec := get(t, router, "/staking/unlocks?from=2026-01-01&to=2027-06-01&period=quarter")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var calendar UnlockCalendar
+	decode(t, rec, &calendar)
+	if len(calendar.Periods) != 6 || calendar.Periods[5].End != "2027-06-01T00:00:00Z" {
+		t.Fatalf("periods = %+v", calendar.Periods)
+	}
+	if calendar.Total == nil || calendar.Total.Raw != "30000000000000" {
+		t.Errorf("total = %+v", calendar.Total)
+	}
+
+	second := calendar.Periods[1]
+	if len(second.Unlocks) != 1 || second.Amount.Raw != "20000000000000" {
+		t.Fatalf("second quarter = %+v", second)
+	}
+	if u := second.Unlocks[0]; u.Account != "acc://dave.acme/staking" || u.Start != "2026-02-10T00:00:00Z" || u.Unlock != "2026-05-10T00:00:00Z" {
+		t.Errorf("dave = %+v", u)
+	}
+
+	fifth := calendar.Periods[4]
+	if len(fifth.Unlocks) != 1 {
+		t.Fatalf("fifth quarter = %+v", fifth)
+	}
+	if u := fifth.Unlocks[0]; u.Account != "acc://carol.acme/locked" || u.Start != "2026-01-01T00:00:00Z" || u.Unlock != "2027-01-01T00:00:00Z" || !u.HardLock {
+		t.Errorf("carol = %+v", u)
+	} else if want := server.primary.network.calculateMajorBlock(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)); u.UnlockMajorBlock != want {
+		t.Errorf("unlock major block = %d, want %d", u.UnlockMajorBlock, want)
+	}
+
+	if len(calendar.Unscheduled) != 1 || calendar.Unscheduled[0].Account != "acc://erin.acme/staking" {
+		t.Errorf("unscheduled = %+v", calendar.Unscheduled)
+	}
+
+	for _, query := range []string{"from=yesterday", "from=2026-02-01&to=2026-01-01", "period=year", "from=2026-01-01&to=2036-01-01&period=day"} {
+		if rec := get(t, router, "/staking/unlocks?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
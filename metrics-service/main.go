@@ -1,47 +1,40 @@
 package main
 
 import (
-	"bytes"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"accumulate-metrics/registry"
+
 	"github.com/gorilla/mux"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
-var (
-	// Genesis reset on July 14, 2025 - post-genesis block 1 started at this time
-	// Major blocks occur every 12 hours (cron: "0 */12 * * *")
-	genesisResetTime = time.Date(2025, 7, 14, 0, 0, 0, 0, time.UTC)
-	majorBlockInterval = 12 * time.Hour
-	// Pre-genesis offset: the old chain had 1,864 major blocks before the reset
-	// Absolute block number = post-genesis block + 1864
-	preGenesisBlockOffset int64 = 1864
-)
-
-// SupplyMetrics represents the supply data for ACME token
-type SupplyMetrics struct {
-	Max              int64 `json:"max"`
-	Total            int64 `json:"total"`
-	Circulating      int64 `json:"circulating"`
-	CirculatingTokens int64 `json:"circulatingTokens"` // Alias for compatibility with Explorer
-	Staked           int64 `json:"staked"`
-}
-
 // TimestampData represents cached timestamp information
 type TimestampData struct {
-	Chains          []ChainEntry `json:"chains"`
-	Status          string       `json:"status,omitempty"`   // Transaction status: pending, delivered, etc.
-	MinorBlock      int64        `json:"minorBlock,omitempty"` // Minor block index (0 if pending)
-	MajorBlock      int64        `json:"majorBlock,omitempty"` // Major block index (0 if pending)
+	Chains     []ChainEntry `json:"chains"`
+	Status     string       `json:"status,omitempty"`     // Transaction status: pending, delivered, etc.
+	MinorBlock int64        `json:"minorBlock,omitempty"` // Minor block index (0 if pending)
+	MajorBlock int64        `json:"majorBlock,omitempty"` // Major block index (0 if pending)
 	// Internal cache fields (prefixed with underscore to hide from API consumers)
-	HasBlockTime    bool         `json:"_hasBlockTime,omitempty"` // If true, from block (never re-query). If false, from signature (keep checking for block)
-	SignatureTime   int64        `json:"_signatureTime,omitempty"` // Oldest signature timestamp (cached permanently)
+	HasBlockTime  bool  `json:"_hasBlockTime,omitempty"`  // If true, from block (never re-query). If false, from signature (keep checking for block)
+	SignatureTime int64 `json:"_signatureTime,omitempty"` // Oldest signature timestamp (cached permanently)
+	MajorIndexed  bool  `json:"_majorIndexed,omitempty"`  // If true, MajorBlock is from the major block index. If false, it is re-resolved when served
+	FirstSeen     int64 `json:"_firstSeen,omitempty"`     // When the transaction was first cached without block data (Unix ms)
+	Checks        int   `json:"_checks,omitempty"`        // Upstream checks without block data
+	NextCheck     int64 `json:"_nextCheck,omitempty"`     // When the pending transaction is rechecked (Unix ms), 0 once it is resolved
 }
 
 type ChainEntry struct {
@@ -82,71 +75,28 @@ type V3Signature struct {
 	Timestamp int64           `json:"timestamp,omitempty"`
 }
 
-var (
-	// Cache for supply metrics (in-memory, short-lived)
-	cachedMetrics        *SupplyMetrics
-	lastUpdate           time.Time
-	cacheDuration        = 5 * time.Minute
-
-	// Persistent database for timestamps and identity map
-	timestampDB *leveldb.DB
-
-	// Accumulate API endpoints
-	accumulateAPI   = "https://mainnet.accumulatenetwork.io/v3"
-	accumulateAPIv2 = "https://mainnet.accumulatenetwork.io"
-
-	// REMOVED: Hard-coded accounts optimization - must query all entries to handle account removal
-	// Previously hard-coded 15 accounts (up to index 418), but accounts can be deleted (Status == "deleted")
-	// Must dynamically build complete account set by querying all chain entries every time
-	// knownStakingAccounts = []string{...}
-	// lastKnownChainIndex = int64(418)
-)
-
 // RegistrationIdentity represents a complete registration entry
-// Supports both modern (multi-account) and legacy (single-account) formats
-type RegistrationIdentity struct {
-	// Modern format (multi-account)
-	Identity        string    `json:"identity"`
-	Accounts        []Account `json:"accounts"`
-	DelegatorPayout string    `json:"delegatorPayout"`
-	RejectDelegates bool      `json:"rejectDelegates"`
-	Status          string    `json:"status"`
-
-	// Legacy format (single account) - backward compatibility
-	Type     string `json:"type"`
-	Stake    string `json:"stake"`
-	Rewards  string `json:"rewards"`
-	Delegate string `json:"delegate"`
-	Lockup   uint64 `json:"lockup"`
-	HardLock bool   `json:"hardLock"`
-
-	// Additional fields
-	AcceptingDelegates string `json:"acceptingDelegates"`
-}
+type RegistrationIdentity = registry.Registration
 
 // Account represents a staking account in the modern format
-type Account struct {
-	Type     string `json:"type"`     // "pure", "delegated", "coreValidator", etc.
-	Url      string `json:"url"`      // Primary staking account URL
-	Payout   string `json:"payout"`   // Reward payout account
-	Delegate string `json:"delegate"` // Delegation target
-	Lockup   uint64 `json:"lockup"`   // Lockup quarters
-	HardLock bool   `json:"hardLock"` // Hard lock flag
-}
+type Account = registry.Account
+
+// stakingRegistryURL is the data account holding staking registrations
+const stakingRegistryURL = registry.URL
 
 // Database key prefixes
 const (
-	identityPrefix        = "identity:"
-	metadataPrefix        = "metadata:"
-	lastQueriedIndexKey   = "metadata:lastQueriedIndex"
-	totalEntriesKey       = "metadata:totalEntries"
+	identityPrefix      = registry.IdentityPrefix
+	metadataPrefix      = "metadata:"
+	lastQueriedIndexKey = "metadata:lastQueriedIndex"
+	totalEntriesKey     = "metadata:totalEntries"
 )
 
 // Database helper functions for identity map storage
 
 // getIdentityFromDB retrieves an identity from the database
-func getIdentityFromDB(identityURL string) (*RegistrationIdentity, error) {
-	data, err := timestampDB.Get([]byte(identityPrefix+identityURL), nil)
+func (s *Service) getIdentityFromDB(identityURL string) (*RegistrationIdentity, error) {
+	data, err := s.db.Get(s.key(identityPrefix+identityURL), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -159,52 +109,14 @@ func getIdentityFromDB(identityURL string) (*RegistrationIdentity, error) {
 	return &identity, nil
 }
 
-// saveIdentityToDB saves or updates an identity in the database
-func saveIdentityToDB(identityURL string, identity *RegistrationIdentity) error {
-	data, err := json.Marshal(identity)
-	if err != nil {
-		return err
-	}
-
-	return timestampDB.Put([]byte(identityPrefix+identityURL), data, nil)
-}
-
-// deleteIdentityFromDB removes an identity from the database
-func deleteIdentityFromDB(identityURL string) error {
-	return timestampDB.Delete([]byte(identityPrefix+identityURL), nil)
-}
-
 // getAllIdentitiesFromDB retrieves all identities from the database
-func getAllIdentitiesFromDB() (map[string]*RegistrationIdentity, error) {
-	identities := make(map[string]*RegistrationIdentity)
-
-	iter := timestampDB.NewIterator(nil, nil)
-	defer iter.Release()
-
-	prefix := []byte(identityPrefix)
-	for iter.Seek(prefix); iter.Valid(); iter.Next() {
-		key := iter.Key()
-		if !bytes.HasPrefix(key, prefix) {
-			break
-		}
-
-		identityURL := string(key[len(prefix):])
-
-		var identity RegistrationIdentity
-		if err := json.Unmarshal(iter.Value(), &identity); err != nil {
-			log.Printf("Warning: Failed to unmarshal identity %s: %v", identityURL, err)
-			continue
-		}
-
-		identities[identityURL] = &identity
-	}
-
-	return identities, iter.Error()
+func (s *Service) getAllIdentitiesFromDB() (map[string]*RegistrationIdentity, error) {
+	return registry.LoadIdentities(s.db, s.prefix)
 }
 
 // getLastQueriedIndex retrieves the last processed chain index
-func getLastQueriedIndex() int64 {
-	data, err := timestampDB.Get([]byte(lastQueriedIndexKey), nil)
+func (s *Service) getLastQueriedIndex() int64 {
+	data, err := s.db.Get(s.key(lastQueriedIndexKey), nil)
 	if err != nil {
 		return -1 // Not found, start from beginning
 	}
@@ -218,18 +130,18 @@ func getLastQueriedIndex() int64 {
 }
 
 // setLastQueriedIndex updates the last processed chain index
-func setLastQueriedIndex(index int64) error {
+func (s *Service) setLastQueriedIndex(index int64) error {
 	data, err := json.Marshal(index)
 	if err != nil {
 		return err
 	}
 
-	return timestampDB.Put([]byte(lastQueriedIndexKey), data, nil)
+	return s.db.Put(s.key(lastQueriedIndexKey), data, nil)
 }
 
 // getTotalEntries retrieves the cached total entry count
-func getTotalEntries() int64 {
-	data, err := timestampDB.Get([]byte(totalEntriesKey), nil)
+func (s *Service) getTotalEntries() int64 {
+	data, err := s.db.Get(s.key(totalEntriesKey), nil)
 	if err != nil {
 		return 0
 	}
@@ -243,115 +155,149 @@ func getTotalEntries() int64 {
 }
 
 // setTotalEntries updates the cached total entry count
-func setTotalEntries(total int64) error {
+func (s *Service) setTotalEntries(total int64) error {
 	data, err := json.Marshal(total)
 	if err != nil {
 		return err
 	}
 
-	return timestampDB.Put([]byte(totalEntriesKey), data, nil)
+	return s.db.Put(s.key(totalEntriesKey), data, nil)
 }
 
-// normalizeIdentity converts legacy format to modern format
-// Matches staking/pkg/types/account.go Normalize() behavior
-func normalizeIdentity(id *RegistrationIdentity) {
-	// If Stake is empty, nothing to normalize
-	if id.Stake == "" {
-		return
-	}
+// Service holds the state of one network shared by the HTTP handlers and the
+// background updater
+type Service struct {
+	config  *Config
+	network *NetworkConfig
+	client  AccumulateClient
 
-	// Check if account already exists matching Stake URL
-	for _, a := range id.Accounts {
-		if a.Url == id.Stake {
-			// Already normalized
-			id.clearLegacyFields()
-			return
-		}
-	}
+	// Client of the network before its genesis reset, nil without archiveApi
+	archive AccumulateClient
 
-	// Convert legacy fields to Account entry
-	account := Account{
-		Type:     id.Type,
-		Url:      id.Stake,
-		Payout:   id.Rewards,
-		Delegate: id.Delegate,
-		Lockup:   id.Lockup,
-		HardLock: id.HardLock,
-	}
-	id.Accounts = append(id.Accounts, account)
+	// Persistent database for timestamps and identity map, shared by all
+	// networks. Keys are prefixed with prefix.
+	db     *leveldb.DB
+	prefix string
 
-	// Set DelegatorPayout if not explicitly configured
-	if !id.RejectDelegates && id.DelegatorPayout == "" {
-		if id.Rewards != "" {
-			id.DelegatorPayout = id.Rewards
-		} else {
-			id.DelegatorPayout = id.Stake
-		}
+	// Cache for supply metrics (in-memory, short-lived)
+	supply *supplyCache
+
+	// Fetches the balances of the staking accounts
+	balances *balanceFetcher
+
+	// Serializes staking registry ingestion
+	ingestMu sync.Mutex
+
+	// Serializes writes of webhooks and their queues, and wakes the webhook
+	// dispatcher when events are queued
+	webhookMu   sync.Mutex
+	webhookWake chan struct{}
+
+	// Guards the /v1/stream event log and its subscribers
+	streamMu   sync.Mutex
+	streamSubs map[chan *StreamEvent]bool
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+// NewService returns a service for network that queries the network through
+// client and persists its caches in db under prefix
+func NewService(config *Config, network *NetworkConfig, client AccumulateClient, db *leveldb.DB, prefix string) *Service {
+	s := &Service{
+		config:  config,
+		network: network,
+		client:  client,
+		db:      db,
+		prefix:  prefix,
+		now:     time.Now,
+
+		webhookWake: make(chan struct{}, 1),
 	}
+	s.supply = newSupplyCache(s.fetchSupply, config.CacheDuration)
+	s.supply.onRefresh = s.publishSupply
+	s.balances = newBalanceFetcher(client, config)
+	return s
+}
 
-	id.clearLegacyFields()
+// key returns the database key for k in the service's namespace
+func (s *Service) key(k string) []byte {
+	return []byte(s.prefix + k)
 }
 
-func (id *RegistrationIdentity) clearLegacyFields() {
-	id.Stake = ""
-	id.Rewards = ""
-	id.Type = ""
-	id.Delegate = ""
-	id.Lockup = 0
-	id.HardLock = false
+// runUpdater refreshes the identity database immediately and then every
+// interval until ctx is cancelled
+func (s *Service) runUpdater(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.updateIdentityDatabaseFromBlockchain(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[%s] Background update error: %v", s.network.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
-// AccumulateResponse represents the JSON-RPC response from Accumulate v3 API
-type AccumulateResponse struct {
-	Result struct {
-		Account struct {
-			Type        string `json:"type"`
-			URL         string `json:"url"`
-			Symbol      string `json:"symbol"`
-			Precision   int    `json:"precision"`
-			Issued      string `json:"issued"`
-			SupplyLimit string `json:"supplyLimit"`
-		} `json:"account"`
-	} `json:"result"`
-	Error *struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-	} `json:"error"`
+// commands are the subcommands of the service. Each runs against a server
+// whose database is open; serve is the default.
+var commands = map[string]func(context.Context, *Server, io.Writer) error{
+	"serve":   runServe,
+	"reindex": runReindex,
+	"verify":  runVerify,
 }
 
 func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q (want serve, reindex or verify)\n", name)
+		os.Exit(2)
+	}
+
+	config, err := LoadConfig(args, os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Open LevelDB for timestamp cache
-	var err error
-	timestampDB, err = leveldb.OpenFile("./data/timestamps.db", nil)
+	db, err := leveldb.OpenFile(config.DBPath, nil)
 	if err != nil {
 		log.Fatalf("Failed to open timestamp database: %v", err)
 	}
-	defer timestampDB.Close()
+	defer db.Close()
 
-	// Start background identity map updater
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			if err := updateIdentityDatabaseFromBlockchain(); err != nil {
-				log.Printf("Background update error: %v", err)
-			}
-		}
-	}()
+	server := NewServer(config, db, func(n *NetworkConfig) AccumulateClient {
+		return NewHTTPClient(n.API, n.APIv2)
+	})
+	if err := server.Migrate(); err != nil {
+		db.Close()
+		log.Fatalf("Failed to migrate timestamp database: %v", err)
+	}
 
-	router := mux.NewRouter()
+	if err := command(context.Background(), server, os.Stdout); err != nil {
+		db.Close()
+		log.Fatalf("%s: %v", name, err)
+	}
+}
 
-	// API routes
-	router.HandleFunc("/v1/supply", getSupplyHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/v1/timestamp/{txid}", getTimestampHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/staking/stakers/{url:.*}", getStakingAccountHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/health", healthHandler).Methods("GET")
+// runServe starts the background identity map updaters and serves the API
+func runServe(ctx context.Context, server *Server, _ io.Writer) error {
+	server.Start(ctx)
 
-	// Start server
-	port := ":8080"
-	log.Printf("Starting Accumulate Metrics API on %s", port)
-	log.Fatal(http.ListenAndServe(port, router))
+	log.Printf("Starting Accumulate Metrics API on %s (networks: %s)", server.config.Listen, strings.Join(server.Names(), ", "))
+	return http.ListenAndServe(server.config.Listen, server.Router())
 }
 
 // Health check endpoint
@@ -363,6 +309,9 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// errAccountNotFound is returned for accounts that are not registered staking accounts
+var errAccountNotFound = errors.New("account not found in staking registry")
+
 // StakingAccountInfo represents staking metadata for a specific account
 type StakingAccountInfo struct {
 	URL      string `json:"url"`
@@ -373,23 +322,18 @@ type StakingAccountInfo struct {
 }
 
 // Get staking account info handler
-func getStakingAccountHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	accountURL := vars["url"]
-
-	// Normalize URL: handle both acc:/ (from HTTP redirect) and missing prefix
-	// HTTP clients may convert acc:// to acc:/ (single slash)
-	if strings.HasPrefix(accountURL, "acc:/") && !strings.HasPrefix(accountURL, "acc://") {
-		accountURL = "acc://" + accountURL[5:] // Convert acc:/domain to acc://domain
-	} else if !strings.HasPrefix(accountURL, "acc://") {
-		accountURL = "acc://" + accountURL
-	}
+func (s *Service) getStakingAccountHandler(w http.ResponseWriter, r *http.Request) {
+	accountURL := normalizeAccURL(mux.Vars(r)["url"])
 
 	// Query registration data to find this account
-	stakingInfo, err := queryStakingAccount(accountURL)
+	stakingInfo, err := s.queryStakingAccount(accountURL)
+	if errors.Is(err, errAccountNotFound) {
+		http.Error(w, "Account not found in staking registry", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error querying staking account %s: %v", accountURL, err)
-		http.Error(w, fmt.Sprintf("Account not found in staking registry: %v", err), http.StatusNotFound)
+		http.Error(w, "Failed to query staking registry", http.StatusInternalServerError)
 		return
 	}
 
@@ -397,274 +341,79 @@ func getStakingAccountHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stakingInfo)
 }
 
-// Get supply metrics handler
-func getSupplyHandler(w http.ResponseWriter, r *http.Request) {
-	// Check cache
-	if cachedMetrics != nil && time.Since(lastUpdate) < cacheDuration {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Cache", "HIT")
-		json.NewEncoder(w).Encode(cachedMetrics)
-		return
-	}
+// PayoutInfo lists the identities that pay staking rewards to an account
+type PayoutInfo struct {
+	URL        string   `json:"url"`
+	Identities []string `json:"identities"`
+}
 
-	// Fetch fresh metrics
-	metrics, err := fetchSupplyMetrics()
-	if err != nil {
-		// If fetch fails but we have cached data, return cached
-		if cachedMetrics != nil {
-			log.Printf("Error fetching metrics, using cached data: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", "STALE")
-			json.NewEncoder(w).Encode(cachedMetrics)
-			return
-		}
+// Get payout account info handler
+func (s *Service) getPayoutHandler(w http.ResponseWriter, r *http.Request) {
+	payoutURL := normalizeAccURL(mux.Vars(r)["url"])
 
-		log.Printf("Error fetching metrics: %v", err)
-		http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
+	identities, err := s.getIdentitiesByPayout(payoutURL)
+	if err != nil {
+		log.Printf("Error querying payout account %s: %v", payoutURL, err)
+		http.Error(w, "Failed to query staking registry", http.StatusInternalServerError)
+		return
+	}
+	if len(identities) == 0 {
+		http.Error(w, "No identity pays rewards to this account", http.StatusNotFound)
 		return
 	}
-
-	// Update cache
-	cachedMetrics = metrics
-	lastUpdate = time.Now()
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Cache", "MISS")
-	json.NewEncoder(w).Encode(metrics)
+	json.NewEncoder(w).Encode(&PayoutInfo{URL: payoutURL, Identities: identities})
 }
 
 // getOrRefreshIdentityMap returns the cached identity map or refreshes it if stale
-func getOrRefreshIdentityMap() (map[string]*RegistrationIdentity, error) {
+func (s *Service) getOrRefreshIdentityMap(ctx context.Context) (map[string]*RegistrationIdentity, error) {
 	// Check for new entries and update database incrementally
-	if err := updateIdentityDatabaseFromBlockchain(); err != nil {
+	if err := s.updateIdentityDatabaseFromBlockchain(ctx); err != nil {
 		log.Printf("Warning: Failed to update identity database: %v", err)
 	}
 
 	// Return all identities from database
-	return getAllIdentitiesFromDB()
-}
-
-// updateIdentityDatabaseFromBlockchain queries new blockchain entries and updates the database
-func updateIdentityDatabaseFromBlockchain() error {
-	// Get current chain length
-	chainReq := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      0,
-		"method":  "query",
-		"params": map[string]interface{}{
-			"scope": "acc://staking.acme/registered",
-			"query": map[string]interface{}{
-				"queryType": "chain",
-			},
-		},
-	}
-
-	jsonData, err := json.Marshal(chainReq)
-	if err != nil {
-		return fmt.Errorf("failed to marshal chain request: %w", err)
-	}
-
-	resp, err := http.Post(accumulateAPI, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to query chain: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var chainResp struct {
-		Result struct {
-			Records []struct {
-				Name  string `json:"name"`
-				Count int64  `json:"count"`
-			} `json:"records"`
-		} `json:"result"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&chainResp); err != nil {
-		return fmt.Errorf("failed to decode chain response: %w", err)
-	}
-
-	var totalEntries int64
-	for _, chain := range chainResp.Result.Records {
-		if chain.Name == "main" {
-			totalEntries = chain.Count
-			break
-		}
-	}
-
-	if totalEntries == 0 {
-		return fmt.Errorf("no entries found in main chain")
-	}
-
-	// Get last queried index
-	lastIndex := getLastQueriedIndex()
-	cachedTotal := getTotalEntries()
-
-	// Check if there are new entries
-	if lastIndex >= 0 && totalEntries == cachedTotal {
-		// No new entries, database is up to date
-		return nil
-	}
-
-	if lastIndex < 0 {
-		log.Printf("Initial database load: processing all %d entries", totalEntries)
-	}
-
-	// Determine start index
-	startIndex := lastIndex + 1
-	if startIndex < 0 {
-		startIndex = 0
-	}
-
-	log.Printf("Updating identity database: processing entries %d to %d (total: %d)", startIndex, totalEntries-1, totalEntries-startIndex)
-
-	// Process new entries in batches
-	batchSize := int64(100)
-	newIdentities := 0
-	updatedIdentities := 0
-
-	for start := startIndex; start < totalEntries; start += batchSize {
-		count := batchSize
-		if start+count > totalEntries {
-			count = totalEntries - start
-		}
-
-		rangeReq := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"id":      int(start),
-			"method":  "query",
-			"params": map[string]interface{}{
-				"scope": "acc://staking.acme/registered",
-				"query": map[string]interface{}{
-					"queryType":      "chain",
-					"name":           "main",
-					"range":          map[string]interface{}{"start": start, "count": count},
-					"includeReceipt": false,
-				},
-			},
-		}
-
-		jsonData, _ := json.Marshal(rangeReq)
-		resp, err := http.Post(accumulateAPI, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Warning: Failed to fetch entries %d-%d: %v", start, start+count-1, err)
-			continue
-		}
-
-		var rangeResp struct {
-			Result struct {
-				Records []struct {
-					Entry string `json:"entry"`
-				} `json:"records"`
-			} `json:"result"`
-		}
-
-		json.NewDecoder(resp.Body).Decode(&rangeResp)
-		resp.Body.Close()
-
-		for _, record := range rangeResp.Result.Records {
-			txReq := map[string]interface{}{
-				"jsonrpc": "2.0",
-				"id":      0,
-				"method":  "query",
-				"params": map[string]interface{}{
-					"scope": fmt.Sprintf("acc://%s@staking.acme/registered", record.Entry),
-					"query": map[string]interface{}{},
-				},
-			}
-
-			txData, _ := json.Marshal(txReq)
-			txResp, err := http.Post(accumulateAPI, "application/json", bytes.NewBuffer(txData))
-			if err != nil {
-				continue
-			}
-
-			var txResult struct {
-				Result struct {
-					Message struct {
-						Transaction struct {
-							Body struct {
-								Type  string `json:"type"`
-								Entry struct {
-									Data []string `json:"data"`
-								} `json:"entry"`
-							} `json:"body"`
-						} `json:"transaction"`
-					} `json:"message"`
-				} `json:"result"`
-			}
-
-			json.NewDecoder(txResp.Body).Decode(&txResult)
-			txResp.Body.Close()
-
-			if txResult.Result.Message.Transaction.Body.Type == "writeData" {
-				dataArray := txResult.Result.Message.Transaction.Body.Entry.Data
-				if len(dataArray) > 0 {
-					dataBytes, _ := hex.DecodeString(dataArray[0])
-					var entryData RegistrationIdentity
-					if json.Unmarshal(dataBytes, &entryData) == nil {
-						normalizeIdentity(&entryData)
-
-						identity := entryData.Identity
-						if identity == "" && entryData.Stake != "" {
-							parts := strings.Split(entryData.Stake, "/")
-							if len(parts) >= 3 {
-								identity = strings.Join(parts[:3], "/")
-							}
-						}
-
-						if identity != "" {
-							// Check if this is a deletion
-							if entryData.Status == "deleted" {
-								deleteIdentityFromDB(identity)
-							} else {
-								// Check if identity already exists
-								_, err := getIdentityFromDB(identity)
-								if err != nil {
-									newIdentities++
-								} else {
-									updatedIdentities++
-								}
-								saveIdentityToDB(identity, &entryData)
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// Update metadata
-	setLastQueriedIndex(totalEntries - 1)
-	setTotalEntries(totalEntries)
-
-	if newIdentities > 0 || updatedIdentities > 0 {
-		log.Printf("Identity database updated: %d new, %d updated", newIdentities, updatedIdentities)
-	}
-
-	return nil
+	return s.getAllIdentitiesFromDB()
 }
 
 // queryStakedAmount queries the actual staked ACME from registered staking accounts
 // Matches staking tool's LoadAllRegistered logic:
 // 1. Build identity map from all chain entries (latest status per identity)
 // 2. Skip deleted identities
-// 3. Extract accounts from registered identities only
-// 4. Query balances and sum
-func queryStakedAmount() (int64, error) {
+// 3. Extract accounts from registered identities only, excluding accounts
+// outside the registering identity
+// 4. Query balances and sum those of ACME token accounts, by account type
+// Returns the total in atomic units (ACME × 10⁸) along with the accounts
+// whose balance could not be fetched.
+func (s *Service) queryStakedAmount(ctx context.Context) (*StakedBalances, error) {
 	// Get cached identity map
-	identityMap, err := getOrRefreshIdentityMap()
+	identityMap, err := s.getOrRefreshIdentityMap(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get identity map: %w", err)
+		return nil, fmt.Errorf("failed to get identity map: %w", err)
+	}
+
+	// Visit identities in order so the type and identity reported for an
+	// account listed by several identities do not depend on map order
+	identityURLs := make([]string, 0, len(identityMap))
+	for identity := range identityMap {
+		identityURLs = append(identityURLs, identity)
 	}
+	sort.Strings(identityURLs)
 
 	// Extract accounts from registered identities only
-	var stakingAccounts []string
+	type owner struct{ identity, accountType string }
+	owners := map[string]owner{} // Account URL -> registering identity and type
+	var urls []string
+	var foreign []ExcludedAccount
 	registeredCount := 0
 	deletedCount := 0
 	missingStatusCount := 0
 	identitiesWithoutAccounts := 0
+	listed := 0
 
-	for identity, entryData := range identityMap {
+	for _, identity := range identityURLs {
+		entryData := identityMap[identity]
 		// Skip deleted entries
 		if entryData.Status == "deleted" {
 			deletedCount++
@@ -678,14 +427,29 @@ func queryStakedAmount() (int64, error) {
 				missingStatusCount++
 			}
 			registeredCount++
-			accountCountBefore := len(stakingAccounts)
+			accountCountBefore := listed
 			for _, account := range entryData.Accounts {
-				if account.Url != "" {
-					stakingAccounts = append(stakingAccounts, account.Url)
+				if account.Url == "" {
+					continue
+				}
+				listed++
+				if !underIdentity(account.Url, identity) {
+					foreign = append(foreign, ExcludedAccount{
+						URL:      account.Url,
+						Identity: identity,
+						Reason:   excludedOutsideIdentity,
+						Detail:   fmt.Sprintf("account is not under %s", identity),
+					})
+					continue
+				}
+				// Deduplicate account URLs (accounts can appear in multiple identities)
+				if _, ok := owners[account.Url]; !ok {
+					owners[account.Url] = owner{identity, account.Type}
+					urls = append(urls, account.Url)
 				}
 			}
 			// Check if this identity has no accounts
-			if len(stakingAccounts) == accountCountBefore {
+			if listed == accountCountBefore {
 				identitiesWithoutAccounts++
 				log.Printf("Warning: Identity has no accounts: %s (status: %q)", identity, entryData.Status)
 			}
@@ -695,197 +459,111 @@ func queryStakedAmount() (int64, error) {
 	}
 
 	log.Printf("Found %d identities: %d registered (%d legacy without status, %d without accounts), %d deleted, extracted %d staking accounts",
-		len(identityMap), registeredCount, missingStatusCount, identitiesWithoutAccounts, deletedCount, len(stakingAccounts))
-
-	// Deduplicate account URLs (accounts can appear in multiple identities)
-	uniqueAccounts := make(map[string]bool)
-	for _, url := range stakingAccounts {
-		uniqueAccounts[url] = true
-	}
+		len(identityMap), registeredCount, missingStatusCount, identitiesWithoutAccounts, deletedCount, listed)
 
-	log.Printf("Unique staking accounts after deduplication: %d", len(uniqueAccounts))
+	log.Printf("Unique staking accounts after deduplication: %d", len(urls))
 
 	// Step 4: Query balance of each unique staking account and sum them up
-	var totalStakedRaw int64
-	for accountURL := range uniqueAccounts {
-		requestBody := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"id":      0,
-			"method":  "query",
-			"params": map[string]interface{}{
-				"scope": accountURL,
-				"query": map[string]interface{}{},
-			},
-		}
-
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			continue
-		}
-
-		resp, err := http.Post(accumulateAPI, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
-
-		var accResp struct {
-			Result struct {
-				Account struct {
-					Type    string `json:"type"`
-					Balance string `json:"balance"`
-				} `json:"account"`
-			} `json:"result"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&accResp); err != nil {
-			continue
-		}
-
-		// Parse balance
-		if accResp.Result.Account.Balance != "" {
-			var balance int64
-			if _, err := fmt.Sscanf(accResp.Result.Account.Balance, "%d", &balance); err == nil {
-				totalStakedRaw += balance
-			}
-		}
+	balances := s.balances.Fetch(ctx, urls)
+	if len(balances.Missing) > 0 {
+		log.Printf("Warning: %d staking accounts do not exist: %s", len(balances.Missing), strings.Join(balances.Missing, ", "))
 	}
-
-	// Convert from smallest units to ACME tokens
-	const acmePrecision = 100000000 // 10^8
-	totalStaked := totalStakedRaw / acmePrecision
-
-	log.Printf("Total staked: %d ACME (from %d unique accounts)", totalStaked, len(uniqueAccounts))
-
-	return totalStaked, nil
-}
-
-// queryStakingAccount finds staking information for a specific account URL
-func queryStakingAccount(accountURL string) (*StakingAccountInfo, error) {
-	// Get cached identity map
-	identityMap, err := getOrRefreshIdentityMap()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get identity map: %w", err)
+	for _, failure := range balances.Failed {
+		log.Printf("Warning: Failed to fetch balance of %s: %s", failure.URL, failure.Error)
 	}
 
-	// Search for the account in the identity map
-	for identityURL, entryData := range identityMap {
-		if entryData.Status == "deleted" {
-			continue
+	// Accounts listed by an identity they are not under are only excluded if
+	// their own identity does not list them
+	for _, account := range foreign {
+		if _, ok := owners[account.URL]; !ok {
+			balances.Excluded = append(balances.Excluded, account)
 		}
-
-		if entryData.Status == "registered" || entryData.Status == "" {
-			for _, account := range entryData.Accounts {
-				if account.Url == accountURL {
-					// Found it!
-					return &StakingAccountInfo{
-						URL:      account.Url,
-						Type:     account.Type,
-						Delegate: account.Delegate,
-						Rewards:  account.Payout,
-						Identity: identityURL,
-					}, nil
-				}
-			}
+	}
+	for i, account := range balances.Excluded {
+		if owner, ok := owners[account.URL]; ok {
+			balances.Excluded[i].Identity = owner.identity
 		}
 	}
-
-	return nil, fmt.Errorf("account not found in staking registry")
-}
-
-// Fetch supply metrics from Accumulate mainnet
-func fetchSupplyMetrics() (*SupplyMetrics, error) {
-	// Query ACME token issuer from Accumulate network using v3 API
-	requestBody := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      0,
-		"method":  "query",
-		"params": map[string]interface{}{
-			"scope": "acc://ACME",
-			"query": map[string]interface{}{},
-		},
+	sort.Slice(balances.Excluded, func(i, j int) bool {
+		if balances.Excluded[i].URL != balances.Excluded[j].URL {
+			return balances.Excluded[i].URL < balances.Excluded[j].URL
+		}
+		return balances.Excluded[i].Identity < balances.Excluded[j].Identity
+	})
+	for _, account := range balances.Excluded {
+		log.Printf("Warning: Excluding staking account %s of %s: %s", account.URL, account.Identity, account.Detail)
 	}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	// Break the total down by the registered account type. Accounts that do
+	// not exist count as zero.
+	balances.ByType = map[string]*big.Int{}
+	addToType := func(url string, balance *big.Int) {
+		accountType := owners[url].accountType
+		if total, ok := balances.ByType[accountType]; ok {
+			total.Add(total, balance)
+		} else {
+			balances.ByType[accountType] = new(big.Int).Set(balance)
+		}
 	}
-
-	resp, err := http.Post(accumulateAPI, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to query Accumulate API: %w", err)
+	for _, url := range balances.Missing {
+		addToType(url, new(big.Int))
 	}
-	defer resp.Body.Close()
-
-	var accResp AccumulateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&accResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	for url, balance := range balances.Balances {
+		addToType(url, balance)
 	}
 
-	if accResp.Error != nil {
-		return nil, fmt.Errorf("Accumulate API error: %s", accResp.Error.Message)
-	}
+	log.Printf("Total staked: %s ACME (from %d of %d unique accounts, %d excluded)", formatAmount(balances.Total, acmePrecision),
+		len(balances.Balances), len(urls), len(balances.Excluded))
 
-	// Parse the issued tokens value (as string from API) - this is in smallest units
-	var issuedRaw int64
-	if _, err := fmt.Sscanf(accResp.Result.Account.Issued, "%d", &issuedRaw); err != nil {
-		return nil, fmt.Errorf("failed to parse issued amount: %w", err)
-	}
+	return balances, nil
+}
 
-	// Parse the supply limit - this is in smallest units
-	var supplyLimitRaw int64
-	if _, err := fmt.Sscanf(accResp.Result.Account.SupplyLimit, "%d", &supplyLimitRaw); err != nil {
-		return nil, fmt.Errorf("failed to parse supply limit: %w", err)
+// queryStakingAccount finds staking information for a specific account URL.
+// It is a point read of the account index and never queries the network.
+func (s *Service) queryStakingAccount(accountURL string) (*StakingAccountInfo, error) {
+	identityURL, identity, err := s.getIdentityOfAccount(accountURL)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, errAccountNotFound
 	}
-
-	// Convert from smallest units to ACME tokens
-	// ACME has precision=8, meaning 1 ACME = 10^8 smallest units
-	const acmePrecision = 100000000 // 10^8
-	issued := issuedRaw / acmePrecision
-	supplyLimit := supplyLimitRaw / acmePrecision
-
-	// Query actual staked amount from registered staking accounts
-	staked, err := queryStakedAmount()
 	if err != nil {
-		log.Printf("Error querying staked amount, using estimate: %v", err)
-		// Fall back to estimate if query fails
-		staked = issued / 5 // ~20% estimate
+		return nil, err
 	}
 
-	// Circulating = issued - staked
-	circulating := issued - staked
-
-	metrics := &SupplyMetrics{
-		Max:              supplyLimit,
-		Total:            issued,
-		Circulating:      circulating,
-		CirculatingTokens: circulating, // Same as Circulating for compatibility
-		Staked:           staked,
+	if identityStatus(identity) != "registered" {
+		return nil, errAccountNotFound
 	}
-
-	log.Printf("Fetched metrics: Max=%d, Total=%d, Circulating=%d, Staked=%d",
-		metrics.Max, metrics.Total, metrics.Circulating, metrics.Staked)
-
-	return metrics, nil
+	for _, account := range identity.Accounts {
+		if account.Url == accountURL {
+			return &StakingAccountInfo{
+				URL:      account.Url,
+				Type:     account.Type,
+				Delegate: account.Delegate,
+				Rewards:  account.Payout,
+				Identity: identityURL,
+			}, nil
+		}
+	}
+	return nil, errAccountNotFound
 }
 
-// calculateMajorBlock calculates the absolute major block index from a timestamp
+// calculateMajorBlock estimates the absolute major block index of a timestamp from
+// the schedule, for timestamps outside the major block index (see majorBlockAt).
 // Major blocks occur every 12 hours. The network underwent a genesis reset on July 14, 2025:
 // - Pre-genesis: Oct 31, 2022 - Jul 13, 2025 (blocks 1-1864)
 // - Post-genesis: Jul 14, 2025+ (blocks 1, 2, 3... which map to absolute blocks 1865, 1866, 1867...)
 // Returns the absolute block number (continuous sequence across the genesis reset)
-func calculateMajorBlock(t time.Time) int64 {
-	if t.Before(genesisResetTime) {
+func (n *NetworkConfig) calculateMajorBlock(t time.Time) int64 {
+	if t.Before(n.GenesisResetTime) {
 		// Pre-genesis blocks - would need original genesis time to calculate
 		// For now, return 0 for timestamps before the reset
 		return 0
 	}
 
 	// Post-genesis: calculate block since reset, then add offset for absolute number
-	duration := t.Sub(genesisResetTime)
-	periods := int64(duration / majorBlockInterval)
+	duration := t.Sub(n.GenesisResetTime)
+	periods := int64(duration / n.MajorBlockInterval)
 	postGenesisBlock := 1 + periods
-	absoluteBlock := preGenesisBlockOffset + postGenesisBlock
+	absoluteBlock := n.PreGenesisBlockOffset + postGenesisBlock
 	return absoluteBlock
 }
 
@@ -920,231 +598,27 @@ func extractTimestampFromSignature(sig map[string]interface{}) int64 {
 	return 0
 }
 
-// Get timestamp handler
-func getTimestampHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	txid := vars["txid"]
-
-	// Clean the txid - remove acc:// prefix and @suffix if present
-	txid = strings.TrimPrefix(txid, "acc://")
-	if idx := strings.Index(txid, "@"); idx >= 0 {
-		txid = txid[:idx]
-	}
-
-	// Check LevelDB cache first
-	var cachedData *TimestampData
-	var cacheStatus string
-	cachedBytes, err := timestampDB.Get([]byte(txid), nil)
-	if err == nil {
-		cachedData = &TimestampData{}
-		if err := json.Unmarshal(cachedBytes, cachedData); err != nil {
-			log.Printf("Error deserializing cached timestamp for %s: %v", txid, err)
-			cachedData = nil
-		} else if cachedData.HasBlockTime {
-			// Have block timestamp - return immediately, never re-query
-			response := struct {
-				Chains     []ChainEntry `json:"chains"`
-				Status     string       `json:"status,omitempty"`
-				MinorBlock int64        `json:"minorBlock,omitempty"`
-				MajorBlock int64        `json:"majorBlock,omitempty"`
-			}{
-				Chains:     cachedData.Chains,
-				Status:     cachedData.Status,
-				MinorBlock: cachedData.MinorBlock,
-				MajorBlock: cachedData.MajorBlock,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", "HIT-BLOCK")
-			json.NewEncoder(w).Encode(response)
-			return
-		} else {
-			cacheStatus = "HIT-SIG"
-		}
-	}
-
-	// Either no cache, or have signature timestamp but need to check for block timestamp
-	// Query v3 API for transaction status and signatures
-	requestBody := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      0,
-		"method":  "query",
-		"params": map[string]interface{}{
-			"scope": fmt.Sprintf("acc://%s@unknown", txid),
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Printf("Error marshaling v3 request for %s: %v", txid, err)
-		http.Error(w, "Failed to query transaction", http.StatusInternalServerError)
-		return
-	}
-
-	resp, err := http.Post(accumulateAPI, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Error querying v3 API for %s: %v", txid, err)
-		// If we have cached signature timestamp, return it
-		if cachedData != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", cacheStatus)
-			json.NewEncoder(w).Encode(cachedData)
-			return
-		}
-		http.Error(w, "Failed to query transaction", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Decode as generic map to handle varying signature structures
-	var v3Resp map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&v3Resp); err != nil {
-		log.Printf("Error decoding v3 response for %s: %v", txid, err)
-		if cachedData != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", cacheStatus)
-			json.NewEncoder(w).Encode(cachedData)
-			return
-		}
-		http.Error(w, "Failed to decode response", http.StatusInternalServerError)
-		return
-	}
-
-	// Check for errors
-	if errObj, ok := v3Resp["error"].(map[string]interface{}); ok {
-		errMsg := "Unknown error"
-		if msg, ok := errObj["message"].(string); ok {
-			errMsg = msg
-		}
-		log.Printf("V3 API error for %s: %s", txid, errMsg)
-		if cachedData != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", cacheStatus)
-			json.NewEncoder(w).Encode(cachedData)
-			return
-		}
-		http.Error(w, "Transaction not found", http.StatusNotFound)
-		return
-	}
-
-	// Extract transaction status
-	txStatus := ""
-	if result, ok := v3Resp["result"].(map[string]interface{}); ok {
-		if status, ok := result["status"].(string); ok {
-			txStatus = status
-		}
-	}
-
-	// Try to get block timestamp from v2 timestamp endpoint
-	// This endpoint returns chain entries with minor block numbers if the transaction has been executed
-	// Note: Major block information is not currently available from this endpoint
-	v2Url := fmt.Sprintf("%s/timestamp/%s@unknown", accumulateAPIv2, txid)
-	v2Resp, err := http.Get(v2Url)
-	hasBlockData := false
-	tsData := &TimestampData{
-		Status: txStatus,
-	}
-
-	if err == nil {
-		defer v2Resp.Body.Close()
-		var v2Data struct {
-			Chains []ChainEntry `json:"chains"`
-		}
-		if err := json.NewDecoder(v2Resp.Body).Decode(&v2Data); err == nil && len(v2Data.Chains) > 0 {
-			// Found chain entries with block data - use this and cache permanently
-			tsData.Chains = v2Data.Chains
-			// Get the block number from the first chain entry (all should have the same block)
-			if len(v2Data.Chains) > 0 && v2Data.Chains[0].Block > 0 {
-				tsData.MinorBlock = int64(v2Data.Chains[0].Block)
-
-				// Calculate major block from timestamp
-				if blockTime, err := time.Parse(time.RFC3339, v2Data.Chains[0].Time); err == nil {
-					tsData.MajorBlock = calculateMajorBlock(blockTime)
-				}
-
-				tsData.HasBlockTime = true
-				hasBlockData = true
-				log.Printf("Found block timestamp for %s: minor=%d, major=%d", txid, tsData.MinorBlock, tsData.MajorBlock)
-			}
-		}
+// oldestSignatureTimestamp returns the oldest signature timestamp in a
+// transaction's signature sets, or oldest if none is older
+func oldestSignatureTimestamp(signatures map[string]interface{}, oldest int64) int64 {
+	records, ok := signatures["records"].([]interface{})
+	if !ok {
+		return oldest
 	}
 
-	// If no block data found, fall back to signature timestamps
-	if !hasBlockData {
-		oldestTimestamp := int64(0)
-		if cachedData != nil {
-			oldestTimestamp = cachedData.SignatureTime
-		}
-
-		// Extract timestamps from all signatures (recursively for delegated)
-		if result, ok := v3Resp["result"].(map[string]interface{}); ok {
-			if signatures, ok := result["signatures"].(map[string]interface{}); ok {
-				if records, ok := signatures["records"].([]interface{}); ok {
-					for _, rec := range records {
-						if sigSet, ok := rec.(map[string]interface{}); ok {
-							if sigs, ok := sigSet["signatures"].(map[string]interface{}); ok {
-								if sigRecs, ok := sigs["records"].([]interface{}); ok {
-									for _, sigRec := range sigRecs {
-										ts := extractTimestampFromMap(sigRec)
-										if ts > 0 && (oldestTimestamp == 0 || ts < oldestTimestamp) {
-											oldestTimestamp = ts
-										}
-									}
-								}
-							}
+	for _, rec := range records {
+		if sigSet, ok := rec.(map[string]interface{}); ok {
+			if sigs, ok := sigSet["signatures"].(map[string]interface{}); ok {
+				if sigRecs, ok := sigs["records"].([]interface{}); ok {
+					for _, sigRec := range sigRecs {
+						ts := extractTimestampFromMap(sigRec)
+						if ts > 0 && (oldest == 0 || ts < oldest) {
+							oldest = ts
 						}
 					}
 				}
 			}
 		}
-
-		tsData.Chains = []ChainEntry{}
-		tsData.MinorBlock = 0
-		tsData.MajorBlock = 0
-		tsData.HasBlockTime = false
-		tsData.SignatureTime = oldestTimestamp
-
-		// If we found a signature timestamp, add it as a chain entry
-		if oldestTimestamp > 0 {
-			tsData.Chains = []ChainEntry{{
-				Chain: "signature",
-				Block: 0,
-				Time:  time.Unix(0, oldestTimestamp*1000000).Format(time.RFC3339),
-			}}
-		}
-	}
-
-	// Cache the result
-	jsonData, err = json.Marshal(tsData)
-	if err == nil {
-		if err := timestampDB.Put([]byte(txid), jsonData, nil); err != nil {
-			log.Printf("Error caching timestamp for %s: %v", txid, err)
-		} else {
-			if hasBlockData {
-				log.Printf("Cached block timestamp for %s: block=%d", txid, tsData.MinorBlock)
-			} else {
-				log.Printf("Cached signature timestamp for %s: %d", txid, tsData.SignatureTime)
-			}
-		}
-	}
-
-	// Create response without internal fields
-	response := struct {
-		Chains     []ChainEntry `json:"chains"`
-		Status     string       `json:"status,omitempty"`
-		MinorBlock int64        `json:"minorBlock,omitempty"`
-		MajorBlock int64        `json:"majorBlock,omitempty"`
-	}{
-		Chains:     tsData.Chains,
-		Status:     tsData.Status,
-		MinorBlock: tsData.MinorBlock,
-		MajorBlock: tsData.MajorBlock,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if cachedData != nil {
-		w.Header().Set("X-Cache", "UPDATE")
-	} else {
-		w.Header().Set("X-Cache", "MISS")
 	}
-	json.NewEncoder(w).Encode(response)
+	return oldest
 }
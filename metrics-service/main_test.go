@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// get performs a GET request against handler and returns the recorded response
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode decodes a JSON response body into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+}
+
+// scriptSupply sets up the ACME issuer and two registered staking identities
+func scriptSupply(t *testing.T, fake *fakeAccumulate) {
+	fake.SetAccount(AccountRecord{
+		Type:        "tokenIssuer",
+		URL:         "acc://ACME",
+		Symbol:      "ACME",
+		Precision:   8,
+		Issued:      "30000000000000000",
+		SupplyLimit: "50000000000000000",
+	})
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://alice.acme/staking", TokenURL: acmeTokenURL, Balance: "100000000000000"})
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://bob.acme/staking", TokenURL: acmeTokenURL, Balance: "50000000000000"})
+
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://alice.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "coreValidator", Url: "acc://alice.acme/staking", Payout: "acc://alice.acme/rewards"}},
+	})
+
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://bob.acme/staking", Payout: "acc://bob.acme/rewards", Delegate: "acc://alice.acme"}},
+	})
+}
+
+func TestSupplyEndToEnd(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	router := newTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v1/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("X-Cache = %q, want MISS", got)
+	}
+
+	var metrics SupplyMetrics
+	decode(t, rec, &metrics)
+	if metrics.AsOf == "" {
+		t.Error("missing asOf")
+	}
+	metrics.AsOf = ""
+	want := SupplyMetrics{
+		Max:               500000000,
+		Total:             300000000,
+		Circulating:       298500000,
+		CirculatingTokens: 298500000,
+		Staked:            1500000,
+		StakedByType:      map[string]int64{"coreValidator": 1000000, "delegated": 500000},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("metrics = %+v, want %+v", metrics, want)
+	}
+
+	accountCalls := fake.Calls("account")
+	rec = get(t, router, "/v1/supply")
+	if got := rec.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("X-Cache = %q, want HIT", got)
+	}
+	if fake.Calls("account") != accountCalls {
+		t.Errorf("cache hit queried upstream")
+	}
+}
+
+func TestTimestampDelivered(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	txid := "a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"
+	fake.SetTransaction("acc://"+txid+"@unknown", TransactionRecord{Status: "delivered"})
+	fake.SetTimestamp(txid, []ChainEntry{{Chain: "main", Block: 18745449, Time: "2026-02-21T19:22:52Z"}})
+	router := newTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v1/timestamp/"+txid+"@alice.acme")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("X-Cache = %q, want MISS", got)
+	}
+
+	var data TimestampData
+	decode(t, rec, &data)
+	if data.Status != "delivered" || data.MinorBlock != 18745449 || data.MajorBlock != 2310 {
+		t.Errorf("unexpected response %+v", data)
+	}
+
+	calls := fake.Calls("transaction")
+	rec = get(t, router, "/v1/timestamp/"+txid)
+	if got := rec.Header().Get("X-Cache"); got != "HIT-BLOCK" {
+		t.Errorf("X-Cache = %q, want HIT-BLOCK", got)
+	}
+	if fake.Calls("transaction") != calls {
+		t.Errorf("block cache hit queried upstream")
+	}
+}
+
+func TestTimestampPending(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	txid := "cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b"
+	sigTime := time.Date(2026, 2, 21, 19, 22, 37, 0, time.UTC)
+
+	// A delegated signature nests the timestamp one level down
+	fake.SetTransaction("acc://"+txid+"@unknown", TransactionRecord{
+		Status: "pending",
+		Signatures: map[string]interface{}{
+			"records": []interface{}{
+				map[string]interface{}{
+					"signatures": map[string]interface{}{
+						"records": []interface{}{
+							map[string]interface{}{"message": map[string]interface{}{"signature": map[string]interface{}{
+								"type":      "delegated",
+								"signature": map[string]interface{}{"type": "ed25519", "timestamp": sigTime.UnixMilli()},
+							}}},
+							map[string]interface{}{"message": map[string]interface{}{"signature": map[string]interface{}{
+								"type": "ed25519", "timestamp": sigTime.Add(time.Hour).UnixMilli(),
+							}}},
+						},
+					},
+				},
+			},
+		},
+	})
+	server := newTestServer(t, fake)
+	server.primary.now = func() time.Time { return sigTime.Add(2 * time.Hour) }
+	router := server.Router()
+
+	rec := get(t, router, "/v1/timestamp/"+txid)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var data TimestampData
+	decode(t, rec, &data)
+	if data.Status != "pending" || data.MinorBlock != 0 || len(data.Chains) != 1 {
+		t.Fatalf("unexpected response %+v", data)
+	}
+	if got, _ := time.Parse(time.RFC3339, data.Chains[0].Time); !got.Equal(sigTime) {
+		t.Errorf("signature time = %v, want %v", got, sigTime)
+	}
+
+	// Pending entries are served from the cache until the resolver's next check
+	calls := fake.Calls("transaction")
+	rec = get(t, router, "/v1/timestamp/"+txid)
+	if got := rec.Header().Get("X-Cache"); got != "HIT-PENDING" {
+		t.Errorf("X-Cache = %q, want HIT-PENDING", got)
+	}
+	if fake.Calls("transaction") != calls {
+		t.Errorf("pending cache hit queried upstream")
+	}
+}
+
+func TestTimestampNotFound(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	router := newTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v1/timestamp/deadbeef")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestTimestampInvalidTxid(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	router := newTestServer(t, fake).Router()
+
+	for _, txid := range []string{"not-a-hash", "deadbeef:x@unknown", "@unknown"} {
+		if rec := get(t, router, "/v1/timestamp/"+txid); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", txid, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if fake.Calls("transaction") != 0 {
+		t.Error("invalid transaction ID queried upstream")
+	}
+}
+
+func TestStakingAccountLookup(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	server := newTestServer(t, fake)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	rec := get(t, router, "/staking/stakers/acc:/bob.acme/staking")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var info StakingAccountInfo
+	decode(t, rec, &info)
+	want := StakingAccountInfo{
+		URL:      "acc://bob.acme/staking",
+		Type:     "delegated",
+		Delegate: "acc://alice.acme",
+		Rewards:  "acc://bob.acme/rewards",
+		Identity: "acc://bob.acme",
+	}
+	if info != want {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+
+	// A deletion removes the identity on the next update
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "deleted"})
+	syncRegistry(t, server)
+	rec = get(t, router, "/staking/stakers/bob.acme/staking")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
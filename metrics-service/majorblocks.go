@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Major block index key prefixes
+const (
+	majorBlockPrefix      = "major:block:"      // absolute index -> MajorBlock
+	majorTimePrefix       = "major:time:"       // start (Unix nanoseconds) -> absolute index
+	majorMinorPrefix      = "major:minor:"      // era:first minor block -> absolute index
+	majorCheckpointPrefix = "major:checkpoint:" // era -> chain index of the last indexed block
+)
+
+// Major block eras. The major block numbering restarted at the genesis
+// reset; absolute indexes continue the pre-genesis ones.
+const (
+	eraPreGenesis = "pre-genesis" // Indexed from the network's archiveApi
+	eraCurrent    = "current"
+)
+
+// MajorBlock is an indexed major block of the Directory Network
+type MajorBlock struct {
+	Index           int64     `json:"index"` // Absolute index, continuous across the genesis reset
+	Era             string    `json:"era"`
+	ChainIndex      int64     `json:"chainIndex"` // Index on the network of its era
+	Start           time.Time `json:"start"`
+	FirstMinorBlock int64     `json:"firstMinorBlock,omitempty"` // First Directory Network minor block, if known
+	LastMinorBlock  int64     `json:"lastMinorBlock,omitempty"`  // Set once the next block of the era is indexed
+}
+
+func (s *Service) majorBlockKey(index int64) []byte {
+	return s.key(fmt.Sprintf("%s%020d", majorBlockPrefix, index))
+}
+
+func (s *Service) majorTimeKey(t time.Time) []byte {
+	return s.key(fmt.Sprintf("%s%020d", majorTimePrefix, t.UnixNano()))
+}
+
+func (s *Service) majorMinorKey(era string, minor int64) []byte {
+	return s.key(fmt.Sprintf("%s%s:%020d", majorMinorPrefix, era, minor))
+}
+
+// absoluteMajorBlock returns the absolute index of a major block of an era
+func (s *Service) absoluteMajorBlock(era string, chainIndex int64) int64 {
+	if era == eraCurrent {
+		return chainIndex + s.network.PreGenesisBlockOffset
+	}
+	return chainIndex
+}
+
+// getMajorBlock returns an indexed major block, or nil
+func (s *Service) getMajorBlock(index int64) (*MajorBlock, error) {
+	data, err := s.db.Get(s.majorBlockKey(index), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var block MajorBlock
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// getMajorCheckpoint returns the chain index of the last indexed block of an
+// era, or 0
+func (s *Service) getMajorCheckpoint(era string) int64 {
+	data, err := s.db.Get(s.key(majorCheckpointPrefix+era), nil)
+	if err != nil {
+		return 0
+	}
+
+	var index int64
+	if err := json.Unmarshal(data, &index); err != nil {
+		return 0
+	}
+	return index
+}
+
+// putMajorBlock adds a block and its time and minor block index entries to
+// batch
+func (s *Service) putMajorBlock(batch *leveldb.Batch, block *MajorBlock) error {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(block.Index)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.majorBlockKey(block.Index), data)
+	batch.Put(s.majorTimeKey(block.Start), index)
+	if block.FirstMinorBlock > 0 {
+		batch.Put(s.majorMinorKey(block.Era, block.FirstMinorBlock), index)
+	}
+	return nil
+}
+
+// runMajorBlockIndexer indexes new major blocks immediately and then every
+// interval until ctx is cancelled
+func (s *Service) runMajorBlockIndexer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.updateMajorBlocks(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[%s] Major block index error: %v", s.network.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateMajorBlocks indexes the major blocks produced since the last update,
+// the pre-genesis ones first if the network has an archive
+func (s *Service) updateMajorBlocks(ctx context.Context) error {
+	if s.archive != nil {
+		if err := s.indexMajorBlocks(ctx, eraPreGenesis, s.archive); err != nil {
+			return err
+		}
+	}
+	return s.indexMajorBlocks(ctx, eraCurrent, s.client)
+}
+
+// indexMajorBlocks indexes the blocks of an era after its checkpoint until
+// the network reports a block that does not exist yet. Each block is written
+// with the checkpoint, the minor block range of the previous block and, for
+// the current era, its first minor block in the minor block store.
+func (s *Service) indexMajorBlocks(ctx context.Context, era string, client AccumulateClient) error {
+	checkpoint := s.getMajorCheckpoint(era)
+	previous, err := s.getMajorBlock(s.absoluteMajorBlock(era, checkpoint))
+	if err != nil {
+		return err
+	}
+
+	indexed := 0
+	for chainIndex := checkpoint + 1; ctx.Err() == nil; chainIndex++ {
+		absolute := s.absoluteMajorBlock(era, chainIndex)
+		if era == eraPreGenesis && absolute > s.network.PreGenesisBlockOffset {
+			break
+		}
+
+		record, err := client.QueryMajorBlock(ctx, uint64(chainIndex))
+		if isNotFound(err) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query %s major block %d: %w", era, chainIndex, err)
+		}
+
+		block := &MajorBlock{Index: absolute, Era: era, ChainIndex: chainIndex, Start: record.Time.UTC()}
+		if len(record.MinorBlocks.Records) > 0 {
+			block.FirstMinorBlock = int64(record.MinorBlocks.Records[0].Index)
+		}
+
+		batch := new(leveldb.Batch)
+		if previous != nil && previous.Era == era && block.FirstMinorBlock > 0 {
+			previous.LastMinorBlock = block.FirstMinorBlock - 1
+			if err := s.putMajorBlock(batch, previous); err != nil {
+				return err
+			}
+		}
+		if err := s.putMajorBlock(batch, block); err != nil {
+			return err
+		}
+		// The first minor block of a current major block is a Directory
+		// Network minor block; pre-genesis minor blocks were renumbered
+		if first := record.MinorBlocks.Records; era == eraCurrent && len(first) > 0 && first[0].Time != nil {
+			minor := &MinorBlock{Partition: directoryPartition, Index: int64(first[0].Index), Time: first[0].Time.UTC()}
+			if err := s.putMinorBlock(batch, minor); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(chainIndex)
+		if err != nil {
+			return err
+		}
+		batch.Put(s.key(majorCheckpointPrefix+era), data)
+		if err := s.db.Write(batch, nil); err != nil {
+			return err
+		}
+		previous = block
+		indexed++
+	}
+
+	if indexed > 0 {
+		log.Printf("[%s] Indexed %d %s major blocks (up to %d)", s.network.Name, indexed, era, previous.Index)
+	}
+	return nil
+}
+
+// seekMajorBlock returns the block referenced by the last key of prefix at
+// or before key, or nil
+func (s *Service) seekMajorBlock(prefix, key []byte) (*MajorBlock, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	var found bool
+	switch {
+	case !iter.Seek(key):
+		found = iter.Last()
+	case bytes.Equal(iter.Key(), key):
+		found = true
+	default:
+		found = iter.Prev()
+	}
+	if !found {
+		return nil, iter.Error()
+	}
+
+	var index int64
+	if err := json.Unmarshal(iter.Value(), &index); err != nil {
+		return nil, err
+	}
+	return s.getMajorBlock(index)
+}
+
+// majorBlockAt returns the absolute major block in progress at t. indexed
+// is false if the block was estimated from the schedule because t is after
+// the last indexed block (extrapolated from it) or before the first
+// (calculateMajorBlock).
+func (s *Service) majorBlockAt(t time.Time) (index int64, indexed bool) {
+	block, err := s.seekMajorBlock(s.key(majorTimePrefix), s.majorTimeKey(t))
+	if err != nil {
+		log.Printf("[%s] Error reading major block index: %v", s.network.Name, err)
+	}
+	if block == nil {
+		return s.network.calculateMajorBlock(t), false
+	}
+
+	next, err := s.getMajorBlock(block.Index + 1)
+	if err != nil {
+		log.Printf("[%s] Error reading major block index: %v", s.network.Name, err)
+	}
+	switch {
+	case next != nil:
+		return block.Index, true
+	case block.Era == eraPreGenesis && !t.Before(s.network.GenesisResetTime):
+		// The post-genesis blocks are not indexed yet
+		return s.network.calculateMajorBlock(t), false
+	}
+	// The next block may be late or not indexed yet
+	return block.Index + int64(t.Sub(block.Start)/s.network.MajorBlockInterval), false
+}
+
+// majorBlockOfMinor returns the absolute major block of a current Directory
+// Network minor block. indexed is false if the minor block is after the
+// first minor block of the last indexed block, which it may or may not
+// belong to, or if no block is indexed.
+func (s *Service) majorBlockOfMinor(minor int64) (index int64, indexed bool) {
+	block, err := s.seekMajorBlock(s.key(majorMinorPrefix+eraCurrent+":"), s.majorMinorKey(eraCurrent, minor))
+	if err != nil {
+		log.Printf("[%s] Error reading major block index: %v", s.network.Name, err)
+	}
+	if block == nil {
+		return 0, false
+	}
+	return block.Index, block.LastMinorBlock >= minor
+}
+
+// majorBlockStart returns the start of an absolute major block, from the
+// index or else from the schedule
+func (s *Service) majorBlockStart(index int64) time.Time {
+	if block, err := s.getMajorBlock(index); err == nil && block != nil {
+		return block.Start
+	}
+	return s.network.majorBlockTime(index)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMajorBlockIndex(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	archive := newFakeAccumulate(t)
+	at := func(day, hour int) time.Time { return time.Date(2025, 7, day, hour, 0, 0, 0, time.UTC) }
+
+	// Two pre-genesis blocks; the archive's third is past the offset
+	archive.SetMajorBlock(1, at(12, 12), 10)
+	archive.SetMajorBlock(2, at(13, 0), 20)
+	archive.SetMajorBlock(3, at(13, 12), 30)
+	// The second post-genesis block is three hours late
+	fake.SetMajorBlock(1, at(14, 0), 100)
+	fake.SetMajorBlock(2, at(14, 15), 200)
+	fake.SetMajorBlock(3, at(15, 0), 300)
+
+	config := DefaultConfig()
+	config.PreGenesisBlockOffset = 2
+	config.ArchiveAPI = "http://archive.invalid/v3"
+	server := newMultiNetworkServer(t, config, map[string]*fakeAccumulate{"mainnet": fake, "mainnet-archive": archive})
+	s := server.primary
+	if err := s.updateMajorBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if archive.Calls("block") != 2 || fake.Calls("block") != 4 {
+		t.Errorf("block queries = %d archive, %d current", archive.Calls("block"), fake.Calls("block"))
+	}
+	if b, _ := s.getMajorBlock(3); b == nil || b.Era != eraCurrent || b.ChainIndex != 1 || b.LastMinorBlock != 199 {
+		t.Errorf("block 3 = %+v", b)
+	}
+
+	for _, c := range []struct {
+		at      time.Time
+		want    int64
+		indexed bool
+	}{
+		{at(1, 0), 0, false},   // Before the index
+		{at(12, 12), 1, true},  // First block
+		{at(13, 20), 2, true},  // Last pre-genesis block
+		{at(14, 0), 3, true},   // Genesis reset
+		{at(14, 13), 3, true},  // Scheduled for block 4, which was late
+		{at(14, 15), 4, true},  // Start of block 4
+		{at(15, 11), 5, false}, // The last block may end early
+		{at(16, 1), 7, false},  // Extrapolated from the last block
+	} {
+		if got, indexed := s.majorBlockAt(c.at); got != c.want || indexed != c.indexed {
+			t.Errorf("majorBlockAt(%v) = %d %v, want %d %v", c.at, got, indexed, c.want, c.indexed)
+		}
+	}
+
+	for minor, want := range map[int64]struct {
+		index   int64
+		indexed bool
+	}{50: {0, false}, 100: {3, true}, 150: {3, true}, 250: {4, true}, 299: {4, true}, 350: {5, false}} {
+		if got, indexed := s.majorBlockOfMinor(minor); got != want.index || indexed != want.indexed {
+			t.Errorf("majorBlockOfMinor(%d) = %d %v, want %+v", minor, got, indexed, want)
+		}
+	}
+
+	// Indexing resumes after the checkpoint of each era
+	fake.SetMajorBlock(4, at(15, 12), 400)
+	if err := s.updateMajorBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if archive.Calls("block") != 2 || fake.Calls("block") != 6 {
+		t.Errorf("block queries = %d archive, %d current", archive.Calls("block"), fake.Calls("block"))
+	}
+	if got, indexed := s.majorBlockAt(at(15, 11)); got != 5 || !indexed {
+		t.Errorf("majorBlockAt after update = %d %v", got, indexed)
+	}
+}
+
+func TestTimestampMajorBlockResolved(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	txid := "a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"
+	fake.SetTransaction("acc://"+txid+"@unknown", TransactionRecord{Status: "delivered"})
+	fake.SetTimestamp(txid, []ChainEntry{{Chain: "main", Block: 1000, Time: "2025-07-15T01:00:00Z"}})
+	fake.SetMajorBlock(1, time.Date(2025, 7, 14, 0, 0, 0, 0, time.UTC), 100)
+
+	server := newTestServer(t, fake)
+	s := server.primary
+	router := server.Router()
+	if err := s.updateMajorBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The block is after the last indexed major block: estimated
+	var ts TimestampResponse
+	decode(t, get(t, router, "/v1/timestamp/"+txid), &ts)
+	if ts.MajorBlock != 1867 {
+		t.Errorf("estimated major block = %d, want 1867", ts.MajorBlock)
+	}
+	if cached := s.getCachedTimestamp(txid); cached == nil || cached.MajorIndexed {
+		t.Fatalf("cached = %+v", cached)
+	}
+
+	// Block 2 was late, so the transaction belongs to it
+	fake.SetMajorBlock(2, time.Date(2025, 7, 14, 15, 0, 0, 0, time.UTC), 200)
+	fake.SetMajorBlock(3, time.Date(2025, 7, 15, 3, 0, 0, 0, time.UTC), 300)
+	if err := s.updateMajorBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec := get(t, router, "/v1/timestamp/"+txid)
+	decode(t, rec, &ts)
+	if ts.MajorBlock != 1866 || rec.Header().Get("X-Cache") != cacheHitBlock {
+		t.Errorf("indexed major block = %d (%s), want 1866", ts.MajorBlock, rec.Header().Get("X-Cache"))
+	}
+	if cached := s.getCachedTimestamp(txid); cached == nil || !cached.MajorIndexed || cached.MajorBlock != 1866 {
+		t.Errorf("cached = %+v", cached)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// schemaVersionKey is the number of migrations applied to a keyspace. A
+// keyspace without it was written before migrations existed (version 0).
+const schemaVersionKey = "metadata:schemaVersion"
+
+// migration upgrades a keyspace from the previous schema version. It adds
+// its changes to batch, which is written with the new version, and returns
+// the number of records changed.
+type migration struct {
+	description string
+	migrate     func(s *Service, batch *leveldb.Batch) (int, error)
+}
+
+// migrations are applied in order; a keyspace at version n has had the
+// first n applied. Append new migrations, never change or reorder existing
+// ones.
+var migrations = []migration{
+	{"move cached timestamps under tx:", migrateTimestampNamespace},
+	{"queue cached pending timestamps for the resolver", migratePendingQueue},
+	{"index stored minor blocks by time", migrateMinorBlockTimes},
+}
+
+// schemaVersion is the version of a keyspace migrated by this service
+var schemaVersion = len(migrations)
+
+// Migrate upgrades the keyspace of every network to the current schema
+// version. It must run before the networks are started.
+func (s *Server) Migrate() error {
+	for _, name := range s.names {
+		if err := s.networks[name].migrate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSchemaVersion returns the schema version of the keyspace
+func (s *Service) getSchemaVersion() (int, error) {
+	data, err := s.db.Get(s.key(schemaVersionKey), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+// migrate applies the migrations the keyspace has not had, each in a single
+// write with its version, so an interrupted upgrade resumes at the failed
+// migration
+func (s *Service) migrate() error {
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("[%s] invalid schema version: %w", s.network.Name, err)
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("[%s] database schema version %d is newer than this service supports (%d)", s.network.Name, version, schemaVersion)
+	}
+
+	for ; version < schemaVersion; version++ {
+		m := migrations[version]
+		batch := new(leveldb.Batch)
+		count, err := m.migrate(s, batch)
+		if err != nil {
+			return fmt.Errorf("[%s] migration %d (%s) failed: %w", s.network.Name, version+1, m.description, err)
+		}
+		batch.Put(s.key(schemaVersionKey), []byte(strconv.Itoa(version+1)))
+		if err := s.db.Write(batch, nil); err != nil {
+			return fmt.Errorf("[%s] migration %d (%s) failed: %w", s.network.Name, version+1, m.description, err)
+		}
+		log.Printf("[%s] Migrated database to schema version %d (%s): %d records", s.network.Name, version+1, m.description, count)
+	}
+	return nil
+}
+
+// migrateTimestampNamespace moves the timestamps cached under their bare
+// txid to tx:{txid}. Every other key has a namespace, so the keys of the
+// keyspace without a colon are the cached timestamps.
+func migrateTimestampNamespace(s *Service, batch *leveldb.Batch) (int, error) {
+	prefix := s.key("")
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	count := 0
+	for iter.Next() {
+		txid := string(iter.Key()[len(prefix):])
+		if txid == "" || strings.Contains(txid, ":") {
+			continue
+		}
+		batch.Put(s.key(txPrefix+txid), append([]byte(nil), iter.Value()...))
+		batch.Delete(append([]byte(nil), iter.Key()...))
+		count++
+	}
+	return count, iter.Error()
+}
+
+// migratePendingQueue schedules the pending timestamps cached before the
+// resolver existed for an immediate recheck
+func migratePendingQueue(s *Service, batch *leveldb.Batch) (int, error) {
+	prefix := s.key(txPrefix)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	count := 0
+	now := s.now().UnixMilli()
+	for iter.Next() {
+		txid := string(iter.Key()[len(prefix):])
+		var data TimestampData
+		if err := json.Unmarshal(iter.Value(), &data); err != nil {
+			log.Printf("[%s] Warning: Skipping unreadable cached timestamp %s: %v", s.network.Name, txid, err)
+			continue
+		}
+		if data.HasBlockTime || data.resolved() || data.NextCheck > 0 {
+			continue
+		}
+
+		data.NextCheck = now
+		value, err := json.Marshal(&data)
+		if err != nil {
+			return 0, err
+		}
+		batch.Put(append([]byte(nil), iter.Key()...), value)
+		batch.Put(s.key(pendingPrefix+txid), nil)
+		count++
+	}
+	return count, iter.Error()
+}
+
+// migrateMinorBlockTimes adds the time index entries of the minor blocks
+// stored before the index existed
+func migrateMinorBlockTimes(s *Service, batch *leveldb.Batch) (int, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(minorBlockPrefix)), nil)
+	defer iter.Release()
+
+	count := 0
+	for iter.Next() {
+		var block MinorBlock
+		if err := json.Unmarshal(iter.Value(), &block); err != nil {
+			log.Printf("[%s] Warning: Skipping unreadable minor block %s: %v", s.network.Name, iter.Key(), err)
+			continue
+		}
+		if err := s.putMinorBlock(batch, &block); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, iter.Error()
+}
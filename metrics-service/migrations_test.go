@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// openDatabase opens a database, closed at the end of the test
+func openDatabase(t *testing.T, path string) *leveldb.DB {
+	t.Helper()
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// applyMigration applies migration version (1-based) to the keyspace of s
+func applyMigration(t *testing.T, s *Service, version int) {
+	t.Helper()
+	batch := new(leveldb.Batch)
+	if _, err := migrations[version-1].migrate(s, batch); err != nil {
+		t.Fatal(err)
+	}
+	batch.Put(s.key(schemaVersionKey), []byte(strconv.Itoa(version)))
+	if err := s.db.Write(batch, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unmigratedServer returns a server for config backed by db without
+// migrating it
+func unmigratedServer(config *Config, db *leveldb.DB) *Server {
+	return NewServer(config, db, func(n *NetworkConfig) AccumulateClient { return nil })
+}
+
+func TestMigrateTimestampNamespace(t *testing.T) {
+	db := openDatabase(t, filepath.Join(t.TempDir(), "metrics.db"))
+	delivered := `{"chains":[],"status":"delivered","minorBlock":42,"_hasBlockTime":true}`
+	db.Put([]byte("a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"), []byte(delivered), nil)
+	db.Put([]byte("kermit:cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b"), []byte(delivered), nil)
+	db.Put([]byte("identity:acc://alice.acme"), []byte(`{"identity":"acc://alice.acme"}`), nil)
+	db.Put([]byte("metadata:lastQueriedIndex"), []byte("7"), nil)
+
+	config := DefaultConfig()
+	config.Networks = []NetworkConfig{{Name: "kermit"}}
+	config.applyNetworkDefaults()
+	server := unmigratedServer(config, db)
+	mainnet, _ := server.Network("mainnet")
+	kermit, _ := server.Network("kermit")
+	applyMigration(t, mainnet, 1)
+	applyMigration(t, kermit, 1)
+
+	for key, want := range map[string]bool{
+		"tx:a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6":        true,
+		"a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6":           false,
+		"kermit:tx:cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b": true,
+		"kermit:cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b":    false,
+		"identity:acc://alice.acme":     true,
+		"metadata:lastQueriedIndex":     true,
+		"kermit:metadata:schemaVersion": true,
+	} {
+		if ok, _ := db.Has([]byte(key), nil); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+	if cached := mainnet.getCachedTimestamp("a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"); cached == nil || cached.MinorBlock != 42 {
+		t.Errorf("moved timestamp = %+v", cached)
+	}
+}
+
+func TestMigratePendingQueue(t *testing.T) {
+	db := openDatabase(t, filepath.Join(t.TempDir(), "metrics.db"))
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := unmigratedServer(DefaultConfig(), db).primary
+	s.now = func() time.Time { return now }
+	applyMigration(t, s, 1)
+
+	db.Put([]byte("tx:aa"), []byte(`{"chains":[],"status":"pending","_signatureTime":1771701757504}`), nil)
+	db.Put([]byte("tx:bb"), []byte(`{"chains":[],"status":"delivered","minorBlock":42,"_hasBlockTime":true}`), nil)
+	db.Put([]byte("tx:cc"), []byte(`{"chains":[],"status":"expired"}`), nil)
+	applyMigration(t, s, 2)
+
+	if cached := s.getCachedTimestamp("aa"); cached == nil || cached.NextCheck != now.UnixMilli() || cached.SignatureTime != 1771701757504 {
+		t.Errorf("pending = %+v", cached)
+	}
+	for txid, want := range map[string]bool{"aa": true, "bb": false, "cc": false} {
+		if ok, _ := db.Has(s.key(pendingPrefix+txid), nil); ok != want {
+			t.Errorf("%s queued = %v, want %v", txid, ok, want)
+		}
+	}
+}
+
+func TestMigrateMinorBlockTimes(t *testing.T) {
+	db := openDatabase(t, filepath.Join(t.TempDir(), "metrics.db"))
+	s := unmigratedServer(DefaultConfig(), db).primary
+	applyMigration(t, s, 1)
+	applyMigration(t, s, 2)
+
+	at := time.Date(2025, 7, 14, 13, 0, 0, 0, time.UTC)
+	db.Put(s.minorBlockKey("apollo", 5000), []byte(`{"partition":"apollo","index":5000,"time":"2025-07-14T13:00:00Z"}`), nil)
+	applyMigration(t, s, 3)
+
+	blocks, err := s.minorBlocksAt(at.Add(time.Minute), at.Add(-time.Hour))
+	if err != nil || len(blocks) != 1 || blocks[0].Index != 5000 || !blocks[0].Time.Equal(at) {
+		t.Errorf("minor blocks = %+v, %v", blocks, err)
+	}
+}
+
+func TestMigrateVersion(t *testing.T) {
+	db := openDatabase(t, filepath.Join(t.TempDir(), "metrics.db"))
+	server := unmigratedServer(DefaultConfig(), db)
+	if err := server.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := server.primary.getSchemaVersion(); err != nil || version != schemaVersion {
+		t.Fatalf("schema version = %d, %v, want %d", version, err, schemaVersion)
+	}
+
+	// Migrations are not applied twice
+	db.Put([]byte("deadbeef"), []byte(`{"chains":[]}`), nil)
+	if err := server.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.Has([]byte("deadbeef"), nil); !ok {
+		t.Error("migration applied again")
+	}
+
+	// A database written by a newer service is refused
+	db.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(schemaVersion+1)), nil)
+	if err := server.Migrate(); err == nil {
+		t.Error("expected an error for a newer schema")
+	}
+}
+
+func TestMigrateCommittedDatabase(t *testing.T) {
+	// Upgrade a copy of the database committed in data/
+	src := filepath.Join("..", "data", "timestamps.db")
+	files, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), "timestamps.db")
+	if err := os.Mkdir(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(src, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, f.Name()), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := newFakeAccumulate(t)
+	server := newServerWithDB(t, DefaultConfig(), openDatabase(t, dst), map[string]*fakeAccumulate{"mainnet": fake})
+	router := server.Router()
+
+	rec := get(t, router, "/v1/timestamp/a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6")
+	var ts TimestampResponse
+	decode(t, rec, &ts)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != cacheHitBlock || ts.MinorBlock != 18745449 {
+		t.Errorf("delivered = %d %s %+v", rec.Code, rec.Header().Get("X-Cache"), ts)
+	}
+	if fake.Calls("transaction") != 0 {
+		t.Error("cached timestamp queried upstream")
+	}
+
+	pending := "cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b"
+	if ok, _ := server.primary.db.Has(server.primary.key(pendingPrefix+pending), nil); !ok {
+		t.Error("pending timestamp not queued for the resolver")
+	}
+	if cached := server.primary.getCachedTimestamp(pending); cached == nil || cached.SignatureTime == 0 {
+		t.Errorf("pending = %+v", cached)
+	}
+}
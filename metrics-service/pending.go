@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// pendingPrefix is the key prefix of the transactions the resolver
+// rechecks: timestamp:pending:{txid}
+const pendingPrefix = "timestamp:pending:"
+
+// maxPendingBackoff caps the delay between two rechecks of a pending
+// transaction
+const maxPendingBackoff = time.Hour
+
+// Timestamp statuses set by the service. Other statuses are the network's.
+const (
+	statusPending = "pending" // Not executed yet
+	statusExpired = "expired" // Still pending after the network's pending window
+	statusFailed  = "failed"  // Rejected by the network
+)
+
+// resolved reports whether a transaction without block data will not be
+// rechecked
+func (d *TimestampData) resolved() bool {
+	return d.Status == statusExpired || d.Status == statusFailed
+}
+
+// failureStatuses are the statuses of transactions the network rejected
+var failureStatuses = map[string]bool{
+	"bad-request":          true,
+	"unauthenticated":      true,
+	"insufficient-credits": true,
+	"insufficient-balance": true,
+	"unauthorized":         true,
+	"not-allowed":          true,
+	"rejected":             true,
+	"expired":              true,
+	"conflict":             true,
+	"bad-signer-version":   true,
+	"bad-timestamp":        true,
+	"bad-url-length":       true,
+	"wrong-type":           true,
+}
+
+// transactionFailed reports whether the network rejected a transaction: it
+// has an error or a failure status. Other statuses, including unfamiliar
+// ones, are rechecked until the pending window ends.
+func transactionFailed(tx *TransactionRecord) bool {
+	return tx.Error != nil || failureStatuses[tx.Status]
+}
+
+// pendingBackoff returns the delay before the next check of a pending
+// transaction checked checks times
+func (s *Service) pendingBackoff(checks int) time.Duration {
+	backoff := s.config.PendingBackoff
+	for i := 1; i < checks && backoff < maxPendingBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxPendingBackoff {
+		backoff = maxPendingBackoff
+	}
+	return backoff
+}
+
+// schedulePending counts a check of a transaction without block data and
+// sets its next check, or marks it expired if it has been pending for longer
+// than the pending window. The window starts at the oldest signature, or
+// when the service first saw the transaction.
+func (s *Service) schedulePending(data, previous *TimestampData) {
+	now := s.now()
+	data.FirstSeen, data.Checks = now.UnixMilli(), 1
+	if previous != nil {
+		if previous.FirstSeen > 0 {
+			data.FirstSeen = previous.FirstSeen
+		}
+		data.Checks = previous.Checks + 1
+	}
+
+	data.NextCheck = 0
+	if data.resolved() {
+		return
+	}
+	start := data.SignatureTime
+	if start == 0 {
+		start = data.FirstSeen
+	}
+	// Delivered transactions are waiting for their block data, not to execute
+	if data.Status != "delivered" && now.Sub(time.UnixMilli(start)) > s.network.PendingWindow {
+		data.Status = statusExpired
+		return
+	}
+	data.NextCheck = now.Add(s.pendingBackoff(data.Checks)).UnixMilli()
+}
+
+// cacheTimestamp stores the timestamp of a transaction
+func (s *Service) cacheTimestamp(txid string, data *TimestampData) error {
+	batch := new(leveldb.Batch)
+	if err := s.putTimestamp(batch, txid, data); err != nil {
+		return err
+	}
+	return s.db.Write(batch, nil)
+}
+
+// putTimestamp adds the timestamp of a transaction to batch, and adds it to
+// the resolver's queue if it has a next check or removes it otherwise
+func (s *Service) putTimestamp(batch *leveldb.Batch, txid string, data *TimestampData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	batch.Put(s.key(txPrefix+txid), jsonData)
+	if data.NextCheck > 0 {
+		batch.Put(s.key(pendingPrefix+txid), nil)
+	} else {
+		batch.Delete(s.key(pendingPrefix + txid))
+	}
+	return nil
+}
+
+// runTimestampResolver rechecks the pending transactions that are due
+// immediately and then every interval until ctx is cancelled
+func (s *Service) runTimestampResolver(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.resolvePending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolvePending rechecks the pending transactions whose next check is due,
+// one at a time, and returns the number rechecked. Each recheck promotes
+// the transaction to a block timestamp, reschedules it, or marks it expired
+// or failed.
+func (s *Service) resolvePending(ctx context.Context) int {
+	prefix := s.key(pendingPrefix)
+	var txids []string
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	for iter.Next() {
+		txids = append(txids, string(iter.Key()[len(prefix):]))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		log.Printf("[%s] Error reading pending transactions: %v", s.network.Name, err)
+	}
+
+	checked := 0
+	now := s.now().UnixMilli()
+	for _, txid := range txids {
+		if ctx.Err() != nil {
+			break
+		}
+		cached := s.getCachedTimestamp(txid)
+		if cached == nil || cached.NextCheck == 0 {
+			s.db.Delete(s.key(pendingPrefix+txid), nil)
+			continue
+		}
+		if cached.NextCheck > now {
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, s.config.RequestTimeout)
+		data, _, err := s.getTimestamp(reqCtx, txid)
+		cancel()
+		checked++
+		if err != nil {
+			continue
+		}
+		if data.HasBlockTime || data.resolved() {
+			log.Printf("[%s] Resolved pending transaction %s: %s", s.network.Name, txid, data.Status)
+		}
+	}
+	return checked
+}
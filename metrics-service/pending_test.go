@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPendingResolver(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	promoted := "a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"
+	stale := "cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b"
+	rejected := "5be1e5ee6a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff"
+	fake.SetTransaction("acc://"+promoted+"@unknown", TransactionRecord{Status: "pending"})
+	fake.SetTransaction("acc://"+stale+"@unknown", TransactionRecord{Status: "pending"})
+	fake.SetTransaction("acc://"+rejected+"@unknown", TransactionRecord{Status: "insufficient-balance", Error: &TransactionError{Message: "insufficient balance"}})
+
+	server := newTestServer(t, fake)
+	s := server.primary
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	s.now = clock.Now
+	router := server.Router()
+
+	var ts TimestampResponse
+	for _, txid := range []string{promoted, stale, rejected} {
+		if rec := get(t, router, "/v1/timestamp/"+txid); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != cacheMiss {
+			t.Fatalf("%s: status %d (%s)", txid, rec.Code, rec.Header().Get("X-Cache"))
+		}
+	}
+
+	// Rejected transactions are final immediately
+	rec := get(t, router, "/v1/timestamp/"+rejected)
+	decode(t, rec, &ts)
+	if ts.Status != statusFailed || rec.Header().Get("X-Cache") != cacheHitFinal {
+		t.Errorf("rejected = %s (%s)", ts.Status, rec.Header().Get("X-Cache"))
+	}
+
+	// Nothing is due until the first backoff has passed
+	if n := s.resolvePending(context.Background()); n != 0 {
+		t.Errorf("rechecked %d before the backoff", n)
+	}
+	clock.Advance(time.Minute)
+	if n := s.resolvePending(context.Background()); n != 2 {
+		t.Errorf("rechecked %d, want 2", n)
+	}
+	if cached := s.getCachedTimestamp(stale); cached.Checks != 2 || cached.NextCheck != clock.Now().Add(2*time.Minute).UnixMilli() {
+		t.Errorf("backoff not doubled: %+v", cached)
+	}
+
+	// Block data appears: the transaction is promoted and leaves the queue
+	fake.SetTransaction("acc://"+promoted+"@unknown", TransactionRecord{Status: "delivered"})
+	fake.SetTimestamp(promoted, []ChainEntry{{Chain: "main", Block: 18745449, Time: "2026-03-01T00:03:00Z"}})
+	clock.Advance(2 * time.Minute)
+	if n := s.resolvePending(context.Background()); n != 2 {
+		t.Errorf("rechecked %d, want 2", n)
+	}
+	rec = get(t, router, "/v1/timestamp/"+promoted)
+	decode(t, rec, &ts)
+	if ts.Status != "delivered" || ts.MinorBlock != 18745449 || rec.Header().Get("X-Cache") != cacheHitBlock {
+		t.Errorf("promoted = %+v (%s)", ts, rec.Header().Get("X-Cache"))
+	}
+
+	// Past the pending window, the remaining transaction expires
+	clock.Advance(s.network.PendingWindow)
+	if n := s.resolvePending(context.Background()); n != 1 {
+		t.Errorf("rechecked %d, want 1", n)
+	}
+	calls := fake.Calls("transaction")
+	rec = get(t, router, "/v1/timestamp/"+stale)
+	decode(t, rec, &ts)
+	if ts.Status != statusExpired || rec.Header().Get("X-Cache") != cacheHitFinal {
+		t.Errorf("stale = %s (%s)", ts.Status, rec.Header().Get("X-Cache"))
+	}
+	clock.Advance(time.Hour)
+	if n := s.resolvePending(context.Background()); n != 0 || fake.Calls("transaction") != calls {
+		t.Errorf("resolved transactions rechecked")
+	}
+}
+
+func TestPendingUnknownStatus(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	txid := "a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"
+	fake.SetTransaction("acc://"+txid+"@unknown", TransactionRecord{Status: "queued"})
+
+	server := newTestServer(t, fake)
+	s := server.primary
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	s.now = clock.Now
+	router := server.Router()
+
+	// An unfamiliar status is not a failure: it is rechecked with backoff
+	var ts TimestampResponse
+	get(t, router, "/v1/timestamp/"+txid)
+	rec := get(t, router, "/v1/timestamp/"+txid)
+	decode(t, rec, &ts)
+	if ts.Status != "queued" || rec.Header().Get("X-Cache") != cacheHitPending {
+		t.Errorf("queued = %s (%s)", ts.Status, rec.Header().Get("X-Cache"))
+	}
+	clock.Advance(time.Minute)
+	if n := s.resolvePending(context.Background()); n != 1 {
+		t.Errorf("rechecked %d, want 1", n)
+	}
+
+	// Until the pending window ends
+	clock.Advance(s.network.PendingWindow)
+	if n := s.resolvePending(context.Background()); n != 1 {
+		t.Errorf("rechecked %d, want 1", n)
+	}
+	if cached := s.getCachedTimestamp(txid); cached == nil || cached.Status != statusExpired || cached.NextCheck != 0 {
+		t.Errorf("cached = %+v", cached)
+	}
+}
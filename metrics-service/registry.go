@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"accumulate-metrics/registry"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Staking registry ledger key prefixes
+const (
+	registryEntryPrefix  = "registry:entry:"  // chain index -> RegistryEntry
+	registryLatestPrefix = "registry:latest:" // identity URL -> chain index of the entry it was last set from
+)
+
+// Outcomes of ingesting a staking registry entry
+const (
+	entryApplied    = "applied"    // Applied to the identity database
+	entrySuperseded = "superseded" // Ingested after a later entry of the same identity, so not applied
+	entryIgnored    = "ignored"    // Not a registration (not a writeData transaction)
+	entryInvalid    = "invalid"    // Malformed registration; retrying will not help
+	entryFailed     = "failed"     // Could not be fetched; retried on later updates
+)
+
+// RegistryEntry is the ledger record of one staking registry chain entry
+type RegistryEntry struct {
+	Index       int64     `json:"index"`
+	Hash        string    `json:"hash,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Identity    string    `json:"identity,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"lastAttempt"`
+}
+
+// done returns true if the entry has a final outcome
+func (e *RegistryEntry) done() bool {
+	return e != nil && e.Outcome != entryFailed
+}
+
+func (s *Service) registryEntryKey(index int64) []byte {
+	return s.key(fmt.Sprintf("%s%020d", registryEntryPrefix, index))
+}
+
+// getRegistryEntry returns the ledger record of a chain index, or nil if the
+// index has not been ingested
+func (s *Service) getRegistryEntry(index int64) (*RegistryEntry, error) {
+	data, err := s.db.Get(s.registryEntryKey(index), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry RegistryEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// getLatestIndex returns the chain index of the entry the identity was last
+// set from, or -1
+func (s *Service) getLatestIndex(identityURL string) int64 {
+	data, err := s.db.Get(s.key(registryLatestPrefix+identityURL), nil)
+	if err != nil {
+		return -1
+	}
+
+	var index int64
+	if err := json.Unmarshal(data, &index); err != nil {
+		return -1
+	}
+	return index
+}
+
+// updateIdentityDatabaseFromBlockchain ingests the staking registry entries
+// that have not been ingested yet. The outcome of every entry is recorded in
+// the ledger and the checkpoint (lastQueriedIndex) only advances over
+// contiguous entries with a final outcome, so entries that could not be
+// fetched are retried on later updates instead of being lost.
+func (s *Service) updateIdentityDatabaseFromBlockchain(ctx context.Context) error {
+	s.ingestMu.Lock()
+	defer s.ingestMu.Unlock()
+
+	if err := s.ensureIndexes(); err != nil {
+		return err
+	}
+	if err := s.ensureAnomalies(); err != nil {
+		return err
+	}
+
+	// Get current chain length
+	totalEntries, err := registry.Count(ctx, s.client)
+	if err != nil {
+		return fmt.Errorf("failed to query chain: %w", err)
+	}
+
+	if totalEntries == 0 {
+		return fmt.Errorf("no entries found in main chain")
+	}
+
+	// Get last queried index
+	lastIndex := s.getLastQueriedIndex()
+
+	// Databases written before the ledger existed have a checkpoint but no
+	// ledger or registration history. Rebuild them by a reindex, which
+	// replays the registry in a separate keyspace, so the live identities
+	// are never rolled back to older entries while it runs.
+	if lastIndex >= 0 {
+		first, err := s.getRegistryEntry(0)
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			log.Printf("Registry ledger missing, rebuilding it from all %d entries", totalEntries)
+			if err := s.reindex(ctx); err != nil {
+				return err
+			}
+			lastIndex = s.getLastQueriedIndex()
+		}
+	}
+
+	if lastIndex >= totalEntries-1 {
+		// No new entries or gaps, database is up to date
+		return s.setTotalEntries(totalEntries)
+	}
+
+	if lastIndex < 0 {
+		log.Printf("Initial database load: processing all %d entries", totalEntries)
+	}
+
+	startIndex := lastIndex + 1
+	log.Printf("Updating identity database: processing entries %d to %d (total: %d)", startIndex, totalEntries-1, totalEntries-startIndex)
+
+	stats := map[string]int{}
+	applied := map[string]bool{}
+	for start := startIndex; start < totalEntries && ctx.Err() == nil; start += registry.BatchSize {
+		count := int64(registry.BatchSize)
+		if start+count > totalEntries {
+			count = totalEntries - start
+		}
+		if err := s.ingestRange(ctx, start, count, stats, applied); err != nil {
+			return err
+		}
+	}
+
+	if err := s.updateRegistryAnomalies(); err != nil {
+		return err
+	}
+
+	// Advance the checkpoint over contiguous entries with a final outcome
+	checkpoint := lastIndex
+	for index := startIndex; index < totalEntries; index++ {
+		entry, err := s.getRegistryEntry(index)
+		if err != nil {
+			return err
+		}
+		if !entry.done() {
+			break
+		}
+		checkpoint = index
+	}
+
+	if err := s.setLastQueriedIndex(checkpoint); err != nil {
+		return err
+	}
+	if err := s.setTotalEntries(totalEntries); err != nil {
+		return err
+	}
+
+	log.Printf("Identity database updated: %d applied, %d superseded, %d ignored, %d invalid, %d failed (checkpoint %d of %d)",
+		stats[entryApplied], stats[entrySuperseded], stats[entryIgnored], stats[entryInvalid], stats[entryFailed], checkpoint, totalEntries-1)
+	s.publishRegistryUpdate(startIndex, totalEntries, checkpoint, stats, applied)
+
+	if checkpoint < totalEntries-1 {
+		return fmt.Errorf("staking registry entry %d could not be ingested, will retry", checkpoint+1)
+	}
+	return nil
+}
+
+// ingestRange ingests the entries of a chain range that do not have a final
+// outcome yet, in chain order. The identities of applied entries are added
+// to applied.
+func (s *Service) ingestRange(ctx context.Context, start, count int64, stats map[string]int, applied map[string]bool) error {
+	entries := make([]*RegistryEntry, count)
+	needHash := false
+	for i := range entries {
+		entry, err := s.getRegistryEntry(start + int64(i))
+		if err != nil {
+			return err
+		}
+		if entry.done() {
+			continue
+		}
+		if entry == nil {
+			entry = &RegistryEntry{Index: start + int64(i)}
+		}
+		entries[i] = entry
+		needHash = needHash || entry.Hash == ""
+	}
+
+	if needHash {
+		hashes, err := registry.Hashes(ctx, s.client, start, count)
+		if err != nil {
+			log.Printf("Warning: Failed to fetch entries %d-%d: %v", start, start+count-1, err)
+		}
+		for _, entry := range entries {
+			if entry != nil && entry.Hash == "" {
+				entry.Hash = hashes[entry.Index]
+			}
+		}
+		for _, entry := range entries {
+			if entry == nil || entry.Hash != "" {
+				continue
+			}
+			reason := "missing from chain range response"
+			if err != nil {
+				reason = err.Error()
+			}
+			entry.Attempts++
+			entry.LastAttempt = s.now().UTC()
+			entry.Outcome, entry.Error = entryFailed, reason
+			if err := s.putRegistryEntry(new(leveldb.Batch), entry); err != nil {
+				return err
+			}
+			stats[entryFailed]++
+		}
+	}
+
+	for _, entry := range entries {
+		if entry == nil || entry.Hash == "" || ctx.Err() != nil {
+			continue
+		}
+		if err := s.ingestEntry(ctx, entry); err != nil {
+			return err
+		}
+		stats[entry.Outcome]++
+		if entry.Outcome == entryApplied {
+			applied[entry.Identity] = true
+		}
+	}
+	return nil
+}
+
+// ingestEntry fetches and applies a registry entry and records its outcome.
+// The identity and ledger updates are written atomically. Only database
+// errors are returned; fetch and decode errors are recorded in the ledger.
+func (s *Service) ingestEntry(ctx context.Context, entry *RegistryEntry) error {
+	entry.Attempts++
+	entry.LastAttempt = s.now().UTC()
+	entry.Error = ""
+	batch := new(leveldb.Batch)
+
+	fetched := registry.Fetch(ctx, s.client, entry.Index, entry.Hash)
+	registration, identity, err := fetched.Registration, fetched.Identity, fetched.Err
+	switch {
+	case fetched.Tx == nil:
+		entry.Outcome, entry.Error = entryFailed, err.Error()
+		return s.putRegistryEntry(batch, entry)
+	case errors.Is(err, registry.ErrNotRegistration):
+		entry.Outcome = entryIgnored
+		return s.putRegistryEntry(batch, entry)
+	case err != nil:
+		log.Printf("Warning: Invalid staking registry entry %d (%s): %v", entry.Index, entry.Hash, err)
+		entry.Outcome, entry.Error = entryInvalid, err.Error()
+		anomalies := []Anomaly{{Index: entry.Index, Hash: entry.Hash, Kind: anomalyInvalidEntry, Detail: entry.Error}}
+		if err := s.putEntryAnomalies(batch, entry.Index, anomalies); err != nil {
+			return err
+		}
+		return s.putRegistryEntry(batch, entry)
+	}
+
+	entry.Identity = identity
+	anomalies := validateRegistration(entry, identity, registration)
+	anomalies = append(anomalies, s.findMissingPayouts(ctx, entry, identity, registration)...)
+	if err := s.putEntryAnomalies(batch, entry.Index, anomalies); err != nil {
+		return err
+	}
+	revision := &Revision{Index: entry.Index, Hash: entry.Hash, Registration: registration}
+	revision.Time, revision.MinorBlock = s.registryEntryTime(ctx, entry.Hash, fetched.Tx)
+	if err := s.putRevision(batch, identity, revision); err != nil {
+		return err
+	}
+
+	stored, ok := registry.Resolve(s.getLatestIndex(identity), entry.Index, registration)
+	if !ok {
+		// A retried entry must not overwrite a later one, but it may move
+		// the start of a lockup of the current registration
+		if current, err := s.getIdentityFromDB(identity); err == nil {
+			if err := s.reindexLockups(batch, identity, current, revision); err != nil {
+				return err
+			}
+		}
+		entry.Outcome = entrySuperseded
+		return s.putRegistryEntry(batch, entry)
+	}
+
+	previous, err := s.getIdentityFromDB(identity)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+	if err := s.putIdentity(batch, identity, stored); err != nil {
+		return err
+	}
+	if stored != nil {
+		if err := s.reindexLockups(batch, identity, stored, revision); err != nil {
+			return err
+		}
+	}
+	latest, err := json.Marshal(entry.Index)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.key(registryLatestPrefix+identity), latest)
+
+	// Webhook events are queued in the same write as the change
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	queued, err := s.queueIdentityEvent(batch, entry, identity, previous, stored)
+	if err != nil {
+		return err
+	}
+
+	entry.Outcome = entryApplied
+	if err := s.putRegistryEntry(batch, entry); err != nil {
+		return err
+	}
+	if queued {
+		s.notifyWebhooks()
+	}
+	return nil
+}
+
+// putRegistryEntry adds the ledger record to batch and writes the batch
+func (s *Service) putRegistryEntry(batch *leveldb.Batch, entry *RegistryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.registryEntryKey(entry.Index), data)
+	return s.db.Write(batch, nil)
+}
+
+// RegistryGaps is the ingestion state of a network's staking registry
+type RegistryGaps struct {
+	Network      string           `json:"network"`
+	TotalEntries int64            `json:"totalEntries"`
+	Checkpoint   int64            `json:"checkpoint"` // Every entry up to this index has been ingested
+	Pending      int64            `json:"pending"`    // Entries after the checkpoint without a final outcome
+	Gaps         []*RegistryEntry `json:"gaps"`       // Entries that failed and will be retried
+	Invalid      []*RegistryEntry `json:"invalid"`    // Malformed entries that will not be retried
+}
+
+// getRegistryGaps returns the entries that have not been ingested
+func (s *Service) getRegistryGaps() (*RegistryGaps, error) {
+	gaps := &RegistryGaps{
+		Network:      s.network.Name,
+		TotalEntries: s.getTotalEntries(),
+		Checkpoint:   s.getLastQueriedIndex(),
+		Gaps:         []*RegistryEntry{},
+		Invalid:      []*RegistryEntry{},
+	}
+
+	done := int64(0)
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(registryEntryPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var entry RegistryEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			return nil, err
+		}
+		switch {
+		case entry.Outcome == entryFailed:
+			gaps.Gaps = append(gaps.Gaps, &entry)
+		case entry.Outcome == entryInvalid:
+			gaps.Invalid = append(gaps.Invalid, &entry)
+		}
+		if entry.done() && entry.Index > gaps.Checkpoint {
+			done++
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	gaps.Pending = gaps.TotalEntries - 1 - gaps.Checkpoint - done
+	if gaps.Pending < 0 {
+		gaps.Pending = 0
+	}
+	return gaps, nil
+}
+
+// Get staking registry ingestion gaps handler
+func (s *Service) getRegistryGapsHandler(w http.ResponseWriter, r *http.Request) {
+	gaps, err := s.getRegistryGaps()
+	if err != nil {
+		log.Printf("Error reading registry ledger: %v", err)
+		http.Error(w, "Failed to read registry ledger", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gaps)
+}
@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client is the subset of the Accumulate v3 API used to read the registry
+type Client interface {
+	// QueryChainCount returns the number of entries in the named chain of an account
+	QueryChainCount(ctx context.Context, scope, chain string) (int64, error)
+
+	// QueryChainRange returns count entries of the named chain starting at start
+	QueryChainRange(ctx context.Context, scope, chain string, start, count int64) ([]ChainRecord, error)
+
+	// QueryTransaction returns the transaction (message) record for a scope
+	// such as acc://{hash}@unknown
+	QueryTransaction(ctx context.Context, scope string) (*TransactionRecord, error)
+}
+
+// ChainRecord is a single entry of a v3 chain range query
+type ChainRecord struct {
+	Index uint64 `json:"index"`
+	Entry string `json:"entry"`
+}
+
+// TransactionRecord is the subset of a v3 message query response used by the service
+type TransactionRecord struct {
+	Status  string `json:"status"`
+	Message struct {
+		Transaction struct {
+			Body TransactionBody `json:"body"`
+		} `json:"transaction"`
+	} `json:"message"`
+	// Signatures is decoded generically because nested (delegated)
+	// signatures vary in structure
+	Signatures map[string]interface{} `json:"signatures,omitempty"`
+	// Error is set if the network rejected the transaction
+	Error *TransactionError `json:"error,omitempty"`
+}
+
+// TransactionError is the error of a rejected transaction
+type TransactionError struct {
+	Message string `json:"message"`
+}
+
+// TransactionBody is the body of a transaction. Only writeData entries are decoded.
+type TransactionBody struct {
+	Type  string `json:"type"`
+	Entry struct {
+		Data []string `json:"data"`
+	} `json:"entry"`
+}
+
+// RPCError is a JSON-RPC error returned by the Accumulate API
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("Accumulate API error %d: %s", e.Code, e.Message)
+}
+
+// RPCRequest is a JSON-RPC request
+type RPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// RPCResponse is a JSON-RPC response
+type RPCResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// Decode returns the response error or decodes the result into result
+func (r *RPCResponse) Decode(result interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
+	if result == nil || len(r.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		return fmt.Errorf("failed to decode result: %w", err)
+	}
+	return nil
+}
+
+// HTTPClient implements Client over HTTP JSON-RPC. The metrics service
+// builds its other queries on Post and Call.
+type HTTPClient struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// NewHTTPClient returns a client for the given v3 JSON-RPC endpoint
+func NewHTTPClient(url string) *HTTPClient {
+	return &HTTPClient{URL: url, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Post sends a JSON-RPC request body to the endpoint and returns the response body
+func (c *HTTPClient) Post(ctx context.Context, body interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Accumulate API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return data, nil
+}
+
+// Call performs a JSON-RPC request and decodes the result into result
+func (c *HTTPClient) Call(ctx context.Context, method string, params, result interface{}) error {
+	data, err := c.Post(ctx, RPCRequest{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	var rpcResp RPCResponse
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return rpcResp.Decode(result)
+}
+
+func (c *HTTPClient) QueryChainCount(ctx context.Context, scope, chain string) (int64, error) {
+	var result struct {
+		Records []struct {
+			Name  string `json:"name"`
+			Count int64  `json:"count"`
+		} `json:"records"`
+	}
+	err := c.Call(ctx, "query", map[string]interface{}{
+		"scope": scope,
+		"query": map[string]interface{}{"queryType": "chain"},
+	}, &result)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, record := range result.Records {
+		if record.Name == chain {
+			return record.Count, nil
+		}
+	}
+	return 0, fmt.Errorf("chain %s not found on %s", chain, scope)
+}
+
+func (c *HTTPClient) QueryChainRange(ctx context.Context, scope, chain string, start, count int64) ([]ChainRecord, error) {
+	var result struct {
+		Records []ChainRecord `json:"records"`
+	}
+	err := c.Call(ctx, "query", map[string]interface{}{
+		"scope": scope,
+		"query": map[string]interface{}{
+			"queryType":      "chain",
+			"name":           chain,
+			"range":          map[string]interface{}{"start": start, "count": count},
+			"includeReceipt": false,
+		},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+func (c *HTTPClient) QueryTransaction(ctx context.Context, scope string) (*TransactionRecord, error) {
+	var result TransactionRecord
+	if err := c.Call(ctx, "query", map[string]interface{}{"scope": scope}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BatchSize is the number of chain entries fetched per range query
+const BatchSize = 100
+
+// Entry is a fetched registry chain entry
+type Entry struct {
+	Index        int64
+	Hash         string
+	Tx           *TransactionRecord // Unset if the entry could not be fetched
+	Registration *Registration      // Unset if Err is set
+	Identity     string
+	Err          error // Fetch or decode error; ErrNotRegistration if the entry is not a registration
+}
+
+// Count returns the number of registry entries
+func Count(ctx context.Context, c Client) (int64, error) {
+	return c.QueryChainCount(ctx, URL, Chain)
+}
+
+// Hashes returns the transaction hashes of count registry entries from
+// start by chain index. Entries missing from the response are omitted.
+func Hashes(ctx context.Context, c Client, start, count int64) (map[int64]string, error) {
+	records, err := c.QueryChainRange(ctx, URL, Chain, start, count)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[int64]string, len(records))
+	for _, record := range records {
+		index := int64(record.Index)
+		if index >= start && index < start+count {
+			hashes[index] = record.Entry
+		}
+	}
+	return hashes, nil
+}
+
+// Fetch fetches and decodes the registry entry with the given index and hash
+func Fetch(ctx context.Context, c Client, index int64, hash string) *Entry {
+	entry := &Entry{Index: index, Hash: hash}
+	entry.Tx, entry.Err = c.QueryTransaction(ctx, EntryScope(hash))
+	if entry.Err == nil {
+		entry.Registration, entry.Identity, entry.Err = Decode(entry.Tx)
+	}
+	return entry
+}
+
+// Crawl fetches the registry entries from start to total-1 in chain order
+// and calls fn for each, including those that could not be fetched or
+// decoded. Crawl stops at the first error returned by fn or by a range
+// query.
+func Crawl(ctx context.Context, c Client, start, total int64, fn func(*Entry) error) error {
+	for from := start; from < total; from += BatchSize {
+		count := int64(BatchSize)
+		if from+count > total {
+			count = total - from
+		}
+		hashes, err := Hashes(ctx, c, from, count)
+		if err != nil {
+			return fmt.Errorf("failed to fetch entries %d-%d: %w", from, from+count-1, err)
+		}
+
+		for index := from; index < from+count; index++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			entry := &Entry{Index: index, Err: errors.New("missing from chain range response")}
+			if hash, ok := hashes[index]; ok {
+				entry = Fetch(ctx, c, index, hash)
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Load crawls the whole registry and returns the current registration of
+// each identity. Entries that are not registrations are skipped; the others
+// that fail are passed to onError, which may be nil.
+func Load(ctx context.Context, c Client, onError func(*Entry)) (*Identities, error) {
+	total, err := Count(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chain: %w", err)
+	}
+
+	identities := NewIdentities()
+	err = Crawl(ctx, c, 0, total, func(entry *Entry) error {
+		switch {
+		case errors.Is(entry.Err, ErrNotRegistration):
+		case entry.Err != nil:
+			if onError != nil {
+				onError(entry)
+			}
+		default:
+			identities.Apply(entry.Index, entry.Identity, entry.Registration)
+		}
+		return nil
+	})
+	return identities, err
+}
@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// IdentityPrefix is the metrics database key prefix of current
+// registrations: identity:{url} -> Registration
+const IdentityPrefix = "identity:"
+
+// LoadIdentities reads the current registrations stored by the metrics
+// service in the keyspace of a network ("" for the primary network, and
+// "{network}:" for the others). Unreadable records are logged and skipped.
+func LoadIdentities(db *leveldb.DB, keyspace string) (map[string]*Registration, error) {
+	identities := make(map[string]*Registration)
+
+	prefix := []byte(keyspace + IdentityPrefix)
+	iter := db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		identityURL := string(key[len(prefix):])
+
+		var identity Registration
+		if err := json.Unmarshal(iter.Value(), &identity); err != nil {
+			log.Printf("Warning: Failed to unmarshal identity %s: %v", identityURL, err)
+			continue
+		}
+
+		identities[identityURL] = &identity
+	}
+
+	return identities, iter.Error()
+}
@@ -0,0 +1,212 @@
+// Package registry reads the Accumulate staking registry: the data account
+// entries that register, update and delete staking identities. It is shared
+// by the metrics service and the extract-all-accounts tool so they agree on
+// which registration of an identity is current.
+package registry
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// URL is the data account holding staking registrations
+const URL = "acc://staking.acme/registered"
+
+// Chain is the chain of URL holding the registry entries
+const Chain = "main"
+
+// Registration statuses. Legacy entries have no status and are registered.
+const (
+	StatusRegistered = "registered"
+	StatusDeleted    = "deleted"
+)
+
+// ErrNotRegistration is returned by Decode for registry entries that are not
+// registrations
+var ErrNotRegistration = errors.New("not a writeData transaction")
+
+// Registration represents a complete registration entry
+// Supports both modern (multi-account) and legacy (single-account) formats
+type Registration struct {
+	// Modern format (multi-account)
+	Identity        string    `json:"identity"`
+	Accounts        []Account `json:"accounts"`
+	DelegatorPayout string    `json:"delegatorPayout"`
+	RejectDelegates bool      `json:"rejectDelegates"`
+	Status          string    `json:"status"`
+
+	// Legacy format (single account) - backward compatibility
+	Type     string `json:"type"`
+	Stake    string `json:"stake"`
+	Rewards  string `json:"rewards"`
+	Delegate string `json:"delegate"`
+	Lockup   uint64 `json:"lockup"`
+	HardLock bool   `json:"hardLock"`
+
+	// Additional fields
+	AcceptingDelegates string `json:"acceptingDelegates"`
+}
+
+// Account represents a staking account in the modern format
+type Account struct {
+	Type     string `json:"type"`     // "pure", "delegated", "coreValidator", etc.
+	Url      string `json:"url"`      // Primary staking account URL
+	Payout   string `json:"payout"`   // Reward payout account
+	Delegate string `json:"delegate"` // Delegation target
+	Lockup   uint64 `json:"lockup"`   // Lockup quarters
+	HardLock bool   `json:"hardLock"` // Hard lock flag
+}
+
+// Registered returns true if the registration is registered, explicitly or
+// as a legacy entry without a status
+func (r *Registration) Registered() bool {
+	return r.Status == StatusRegistered || r.Status == ""
+}
+
+// Normalize converts legacy format to modern format
+// Matches staking/pkg/types/account.go Normalize() behavior
+func Normalize(r *Registration) {
+	// If Stake is empty, nothing to normalize
+	if r.Stake == "" {
+		return
+	}
+
+	// Check if account already exists matching Stake URL
+	for _, a := range r.Accounts {
+		if a.Url == r.Stake {
+			// Already normalized
+			r.clearLegacyFields()
+			return
+		}
+	}
+
+	// Convert legacy fields to Account entry
+	account := Account{
+		Type:     r.Type,
+		Url:      r.Stake,
+		Payout:   r.Rewards,
+		Delegate: r.Delegate,
+		Lockup:   r.Lockup,
+		HardLock: r.HardLock,
+	}
+	r.Accounts = append(r.Accounts, account)
+
+	// Set DelegatorPayout if not explicitly configured
+	if !r.RejectDelegates && r.DelegatorPayout == "" {
+		if r.Rewards != "" {
+			r.DelegatorPayout = r.Rewards
+		} else {
+			r.DelegatorPayout = r.Stake
+		}
+	}
+
+	r.clearLegacyFields()
+}
+
+func (r *Registration) clearLegacyFields() {
+	r.Stake = ""
+	r.Rewards = ""
+	r.Type = ""
+	r.Delegate = ""
+	r.Lockup = 0
+	r.HardLock = false
+}
+
+// EntryScope returns the query scope of the transaction of a registry entry
+func EntryScope(hash string) string {
+	return fmt.Sprintf("acc://%s@staking.acme/registered", hash)
+}
+
+// Decode decodes and normalizes the registration held by a registry
+// transaction and returns it with the URL of its identity
+func Decode(tx *TransactionRecord) (*Registration, string, error) {
+	body := tx.Message.Transaction.Body
+	if body.Type != "writeData" {
+		return nil, "", ErrNotRegistration
+	}
+	if len(body.Entry.Data) == 0 {
+		return nil, "", fmt.Errorf("empty data entry")
+	}
+
+	data, err := hex.DecodeString(body.Entry.Data[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid hex data: %w", err)
+	}
+
+	var registration Registration
+	if err := json.Unmarshal(data, &registration); err != nil {
+		return nil, "", fmt.Errorf("invalid registration JSON: %w", err)
+	}
+
+	// Legacy entries have no identity; it is the root of the staking
+	// account URL. This must be derived before normalization clears Stake.
+	identity := registration.Identity
+	if identity == "" && registration.Stake != "" {
+		parts := strings.Split(registration.Stake, "/")
+		if len(parts) >= 3 {
+			identity = strings.Join(parts[:3], "/")
+		}
+	}
+	if identity == "" {
+		return nil, "", fmt.Errorf("registration has no identity")
+	}
+
+	Normalize(&registration)
+	return &registration, identity, nil
+}
+
+// Resolve applies an entry to an identity: the entry with the highest chain
+// index wins and a deletion removes the identity. latest is the chain index
+// of the entry the identity was last set from, or -1. Resolve returns false
+// if the entry at index is superseded, otherwise the registration the
+// identity has after the entry, which is nil if it was deleted.
+func Resolve(latest, index int64, r *Registration) (*Registration, bool) {
+	if latest > index {
+		return nil, false
+	}
+	if r.Status == StatusDeleted {
+		return nil, true
+	}
+	return r, true
+}
+
+// Identities resolves the current registration of each identity from
+// registry entries applied in any order
+type Identities struct {
+	latest        map[string]int64
+	registrations map[string]*Registration
+}
+
+// NewIdentities returns an empty identity set
+func NewIdentities() *Identities {
+	return &Identities{latest: map[string]int64{}, registrations: map[string]*Registration{}}
+}
+
+// Apply applies the registration of the entry at index and returns false if
+// a later entry of the identity has been applied already
+func (s *Identities) Apply(index int64, identity string, r *Registration) bool {
+	latest, ok := s.latest[identity]
+	if !ok {
+		latest = -1
+	}
+	current, ok := Resolve(latest, index, r)
+	if !ok {
+		return false
+	}
+	s.latest[identity] = index
+	if current == nil {
+		delete(s.registrations, identity)
+	} else {
+		s.registrations[identity] = current
+	}
+	return true
+}
+
+// Registrations returns the current registrations by identity URL. Deleted
+// identities are omitted.
+func (s *Identities) Registrations() map[string]*Registration {
+	return s.registrations
+}
@@ -0,0 +1,158 @@
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// fakeClient serves a registry chain of transactions keyed by hash
+type fakeClient struct {
+	hashes []string
+	txs    map[string]*TransactionRecord
+	fail   map[string]bool // Scopes whose queries fail
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{txs: map[string]*TransactionRecord{}, fail: map[string]bool{}}
+}
+
+// add appends an entry writing data, or a transaction of another type if
+// data is nil, and returns its hash
+func (c *fakeClient) add(t *testing.T, data interface{}) string {
+	t.Helper()
+	hash := fmt.Sprintf("%064x", len(c.hashes))
+	tx := &TransactionRecord{}
+	tx.Message.Transaction.Body.Type = "sendTokens"
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Message.Transaction.Body.Type = "writeData"
+		tx.Message.Transaction.Body.Entry.Data = []string{hex.EncodeToString(b)}
+	}
+	c.hashes = append(c.hashes, hash)
+	c.txs[EntryScope(hash)] = tx
+	return hash
+}
+
+func (c *fakeClient) QueryChainCount(ctx context.Context, scope, chain string) (int64, error) {
+	return int64(len(c.hashes)), nil
+}
+
+func (c *fakeClient) QueryChainRange(ctx context.Context, scope, chain string, start, count int64) ([]ChainRecord, error) {
+	var records []ChainRecord
+	for i := start; i < start+count && i < int64(len(c.hashes)); i++ {
+		records = append(records, ChainRecord{Index: uint64(i), Entry: c.hashes[i]})
+	}
+	return records, nil
+}
+
+func (c *fakeClient) QueryTransaction(ctx context.Context, scope string) (*TransactionRecord, error) {
+	if c.fail[scope] {
+		return nil, &RPCError{Code: -32603, Message: "internal error"}
+	}
+	return c.txs[scope], nil
+}
+
+func TestDecode(t *testing.T) {
+	c := newFakeClient()
+	legacy := c.add(t, map[string]interface{}{"stake": "acc://alice.acme/staking", "rewards": "acc://alice.acme/rewards", "type": "pure", "lockup": 4})
+	other := c.add(t, nil)
+	anonymous := c.add(t, map[string]interface{}{"status": "registered"})
+
+	r, identity, err := Decode(c.txs[EntryScope(legacy)])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Registration{
+		Accounts:        []Account{{Type: "pure", Url: "acc://alice.acme/staking", Payout: "acc://alice.acme/rewards", Lockup: 4}},
+		DelegatorPayout: "acc://alice.acme/rewards",
+	}
+	if identity != "acc://alice.acme" || !reflect.DeepEqual(r, want) || !r.Registered() {
+		t.Errorf("legacy entry = %s %+v", identity, r)
+	}
+
+	if _, _, err := Decode(c.txs[EntryScope(other)]); !errors.Is(err, ErrNotRegistration) {
+		t.Errorf("non-registration error = %v", err)
+	}
+	if _, _, err := Decode(c.txs[EntryScope(anonymous)]); err == nil || errors.Is(err, ErrNotRegistration) {
+		t.Errorf("registration without identity error = %v", err)
+	}
+}
+
+func TestIdentitiesApply(t *testing.T) {
+	s := NewIdentities()
+	alice := &Registration{Identity: "acc://alice.acme", Status: StatusRegistered}
+	updated := &Registration{Identity: "acc://alice.acme", Status: StatusRegistered, DelegatorPayout: "acc://alice.acme/rewards"}
+	deleted := &Registration{Identity: "acc://bob.acme", Status: StatusDeleted}
+
+	if !s.Apply(3, "acc://alice.acme", updated) || s.Apply(1, "acc://alice.acme", alice) {
+		t.Error("an earlier entry must not override a later one")
+	}
+	s.Apply(2, "acc://bob.acme", &Registration{Identity: "acc://bob.acme"})
+	s.Apply(4, "acc://bob.acme", deleted)
+
+	if got := s.Registrations(); len(got) != 1 || got["acc://alice.acme"] != updated {
+		t.Errorf("registrations = %+v", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c := newFakeClient()
+	c.add(t, Registration{Identity: "acc://alice.acme", Status: StatusRegistered})
+	c.add(t, nil)
+	c.add(t, Registration{Identity: "acc://bob.acme", Status: StatusRegistered})
+	c.add(t, "not a registration")
+	failed := c.add(t, Registration{Identity: "acc://carol.acme", Status: StatusRegistered})
+	c.add(t, Registration{Identity: "acc://bob.acme", Status: StatusDeleted})
+	c.fail[EntryScope(failed)] = true
+	for i := 0; i < BatchSize; i++ {
+		c.add(t, Registration{Identity: "acc://dave.acme", Status: StatusRegistered, DelegatorPayout: fmt.Sprint(i)})
+	}
+
+	var skipped []int64
+	identities, err := Load(context.Background(), c, func(entry *Entry) { skipped = append(skipped, entry.Index) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := identities.Registrations()
+	if len(got) != 2 || got["acc://alice.acme"] == nil || got["acc://dave.acme"].DelegatorPayout != fmt.Sprint(BatchSize-1) {
+		t.Errorf("registrations = %+v", got)
+	}
+	if !reflect.DeepEqual(skipped, []int64{3, 4}) {
+		t.Errorf("skipped entries = %v", skipped)
+	}
+}
+
+func TestLoadIdentities(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("identity:acc://alice.acme"), []byte(`{"identity":"acc://alice.acme","status":"registered"}`), nil)
+	db.Put([]byte("identity:acc://broken.acme"), []byte(`{`), nil)
+	db.Put([]byte("kermit:identity:acc://bob.acme"), []byte(`{"identity":"acc://bob.acme"}`), nil)
+
+	primary, err := LoadIdentities(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kermit, err := LoadIdentities(db, "kermit:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(primary) != 1 || primary["acc://alice.acme"] == nil || len(kermit) != 1 || kermit["acc://bob.acme"] == nil {
+		t.Errorf("primary = %+v, kermit = %+v", primary, kermit)
+	}
+}
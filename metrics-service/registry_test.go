@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"accumulate-metrics/registry"
+)
+
+func registrationScope(hash string) string {
+	return "acc://" + hash + "@staking.acme/registered"
+}
+
+func TestRegistryRetriesFailedEntries(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered"})
+	bob := fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "registered"})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://carol.acme", Status: "registered"})
+	fake.FailScope(registrationScope(bob), 1)
+
+	server := newAdminTestServer(t, fake)
+	service, _ := server.Network("mainnet")
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return clock }
+	router := server.Router()
+
+	if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err == nil {
+		t.Error("expected an error for the failed entry")
+	}
+	if got := service.getLastQueriedIndex(); got != 0 {
+		t.Errorf("checkpoint = %d, want 0", got)
+	}
+	if _, err := service.getIdentityFromDB("acc://carol.acme"); err != nil {
+		t.Errorf("entries after the gap are not ingested: %v", err)
+	}
+
+	var gaps RegistryGaps
+	decode(t, admin(t, router, "GET", "/admin/registry/gaps", ""), &gaps)
+	if len(gaps.Gaps) != 1 || gaps.Gaps[0].Index != 1 || gaps.Gaps[0].Hash != bob {
+		t.Fatalf("gaps = %+v", gaps.Gaps)
+	}
+	if !gaps.Gaps[0].LastAttempt.Equal(clock) {
+		t.Errorf("last attempt = %v, want %v", gaps.Gaps[0].LastAttempt, clock)
+	}
+	if gaps.Pending != 1 || gaps.TotalEntries != 3 {
+		t.Errorf("pending = %d, total = %d", gaps.Pending, gaps.TotalEntries)
+	}
+
+	// The next update retries the gap
+	if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := service.getLastQueriedIndex(); got != 2 {
+		t.Errorf("checkpoint = %d, want 2", got)
+	}
+	if _, err := service.getIdentityFromDB("acc://bob.acme"); err != nil {
+		t.Errorf("retried entry not ingested: %v", err)
+	}
+	if got := fake.ScopeCalls(registrationScope(bob)); got != 2 {
+		t.Errorf("bob queries = %d, want 2", got)
+	}
+
+	decode(t, admin(t, router, "GET", "/admin/registry/gaps", ""), &gaps)
+	if len(gaps.Gaps) != 0 || gaps.Pending != 0 {
+		t.Errorf("gaps after retry = %+v", gaps)
+	}
+}
+
+func TestRegistryRetryDoesNotOverwriteLaterEntry(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	first := fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://bob.acme/staking", Delegate: "acc://alice.acme"}},
+	})
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "delegated", Url: "acc://bob.acme/staking", Delegate: "acc://carol.acme"}},
+	})
+	fake.FailScope(registrationScope(first), 1)
+
+	service, _ := newTestServer(t, fake).Network("mainnet")
+	service.updateIdentityDatabaseFromBlockchain(context.Background())
+	if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := service.getIdentityFromDB("acc://bob.acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := identity.Accounts[0].Delegate; got != "acc://carol.acme" {
+		t.Errorf("delegate = %s, want the later entry's", got)
+	}
+	entry, err := service.getRegistryEntry(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != entrySuperseded || entry.Attempts != 2 {
+		t.Errorf("entry 0 = %+v", entry)
+	}
+}
+
+func TestRegistryLegacyAndInvalidEntries(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	fake.AddRegistration(t, map[string]interface{}{
+		"type":   "pure",
+		"stake":  "acc://carol.acme/staking",
+		"status": "registered",
+	})
+	fake.AddRegistration(t, map[string]interface{}{"status": "registered"})
+
+	server := newAdminTestServer(t, fake)
+	service, _ := server.Network("mainnet")
+	router := server.Router()
+
+	// Malformed entries have a final outcome and do not block the checkpoint
+	if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := service.getLastQueriedIndex(); got != 1 {
+		t.Errorf("checkpoint = %d, want 1", got)
+	}
+
+	rec := get(t, router, "/staking/stakers/carol.acme/staking")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("legacy registration lookup: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var info StakingAccountInfo
+	decode(t, rec, &info)
+	if info.Identity != "acc://carol.acme" || info.Type != "pure" {
+		t.Errorf("info = %+v", info)
+	}
+
+	var gaps RegistryGaps
+	decode(t, admin(t, router, "GET", "/admin/registry/gaps", ""), &gaps)
+	if len(gaps.Invalid) != 1 || gaps.Invalid[0].Index != 1 || gaps.Invalid[0].Error == "" {
+		t.Errorf("invalid = %+v", gaps.Invalid)
+	}
+}
+
+func TestRegistryMatchesExtractTool(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	first := fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "registered", DelegatorPayout: "acc://bob.acme/old"})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "registered", DelegatorPayout: "acc://bob.acme/new"})
+	fake.AddRegistration(t, map[string]interface{}{"type": "pure", "stake": "acc://carol.acme/staking"})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://dave.acme", Status: "registered"})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://dave.acme", Status: "deleted"})
+	fake.FailScope(registrationScope(first), 1)
+
+	// The service ingests the first entry after the second
+	service, _ := newTestServer(t, fake).Network("mainnet")
+	service.updateIdentityDatabaseFromBlockchain(context.Background())
+	if err := service.updateIdentityDatabaseFromBlockchain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := service.getAllIdentitiesFromDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := registry.Load(context.Background(), registry.NewHTTPClient(fake.server.URL+"/v3"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Registrations(); !reflect.DeepEqual(got, stored) {
+		t.Errorf("registry.Load = %+v, service = %+v", got, stored)
+	}
+	if len(stored) != 2 || stored["acc://bob.acme"].DelegatorPayout != "acc://bob.acme/new" {
+		t.Errorf("service identities = %+v", stored)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// reindexPrefix is the key prefix of the keyspace a reindex is built in,
+// under the network's prefix. Network names cannot contain underscores, so
+// it cannot collide with another network.
+const reindexPrefix = "_reindex:"
+
+// registryKeyPrefixes are the keys derived from the staking registry, which
+// a reindex replaces. Timestamps and staking snapshots are kept.
+var registryKeyPrefixes = []string{
+	identityPrefix,
+	registryEntryPrefix,
+	registryLatestPrefix,
+	"index:",
+	historyPrefix,
+	"anomaly:",
+	lastQueriedIndexKey,
+	totalEntriesKey,
+	indexVersionKey,
+	anomalyVersionKey,
+}
+
+// isRegistryKey returns true if key, without the network prefix, is derived
+// from the staking registry
+func isRegistryKey(key string) bool {
+	for _, prefix := range registryKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// errVerifyFailed is returned by verify if the database differs from the
+// registry
+var errVerifyFailed = errors.New("identity database differs from the staking registry")
+
+// deletePrefix adds the deletion of every key starting with prefix to batch
+func deletePrefix(db *leveldb.DB, batch *leveldb.Batch, prefix []byte) error {
+	iter := db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	return iter.Error()
+}
+
+// reindex replays the staking registry from entry 0 into a fresh keyspace
+// and then replaces the registry keys with it in a single atomic write. If
+// the replay fails or is incomplete the database is left unchanged.
+func (s *Service) reindex(ctx context.Context) error {
+	shadow := NewService(s.config, s.network, s.client, s.db, s.prefix+reindexPrefix)
+
+	// Discard what an interrupted reindex left behind
+	cleanup := new(leveldb.Batch)
+	if err := deletePrefix(s.db, cleanup, shadow.key("")); err != nil {
+		return err
+	}
+	if err := s.db.Write(cleanup, nil); err != nil {
+		return err
+	}
+
+	if err := shadow.updateIdentityDatabaseFromBlockchain(ctx); err != nil {
+		cleanup.Reset()
+		if err := deletePrefix(s.db, cleanup, shadow.key("")); err == nil {
+			s.db.Write(cleanup, nil)
+		}
+		return fmt.Errorf("replay failed, database unchanged: %w", err)
+	}
+
+	batch := new(leveldb.Batch)
+	for _, prefix := range registryKeyPrefixes {
+		if err := deletePrefix(s.db, batch, s.key(prefix)); err != nil {
+			return err
+		}
+	}
+	// Puts after deletes of the same key win within a batch. Other keys the
+	// replay wrote, such as its event log, are discarded.
+	iter := s.db.NewIterator(util.BytesPrefix(shadow.key("")), nil)
+	count := 0
+	for iter.Next() {
+		key := string(iter.Key()[len(shadow.key("")):])
+		if isRegistryKey(key) {
+			batch.Put(s.key(key), append([]byte(nil), iter.Value()...))
+			count++
+		}
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to swap in the new index: %w", err)
+	}
+	log.Printf("[%s] Reindexed the staking registry: %d keys", s.network.Name, count)
+	return nil
+}
+
+// IdentityDifference is an identity whose stored registration differs from
+// the one rebuilt from the registry
+type IdentityDifference struct {
+	Identity string        `json:"identity"`
+	Problem  string        `json:"problem"` // missing, unexpected or changed
+	Changes  []FieldChange `json:"changes,omitempty"`
+}
+
+// verify rebuilds the identity database in memory from the staking registry
+// and returns the identities that differ from the stored ones
+func (s *Service) verify(ctx context.Context) ([]IdentityDifference, error) {
+	mem, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	rebuilt := NewService(s.config, s.network, s.client, mem, "")
+	if err := rebuilt.updateIdentityDatabaseFromBlockchain(ctx); err != nil {
+		return nil, fmt.Errorf("rebuild failed: %w", err)
+	}
+
+	want, err := rebuilt.getAllIdentitiesFromDB()
+	if err != nil {
+		return nil, err
+	}
+	have, err := s.getAllIdentitiesFromDB()
+	if err != nil {
+		return nil, err
+	}
+
+	differences := []IdentityDifference{}
+	for url, identity := range want {
+		stored, ok := have[url]
+		if !ok {
+			differences = append(differences, IdentityDifference{Identity: url, Problem: "missing"})
+			continue
+		}
+		if changes := diffFields(registrationFields(stored), registrationFields(identity)); len(changes) > 0 {
+			differences = append(differences, IdentityDifference{Identity: url, Problem: "changed", Changes: changes})
+		}
+	}
+	for url := range have {
+		if _, ok := want[url]; !ok {
+			differences = append(differences, IdentityDifference{Identity: url, Problem: "unexpected"})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i].Identity < differences[j].Identity })
+	return differences, nil
+}
+
+// runReindex reindexes the staking registry of every network
+func runReindex(ctx context.Context, server *Server, _ io.Writer) error {
+	for _, name := range server.Names() {
+		service, _ := server.Network(name)
+		if err := service.reindex(ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runVerify verifies the identity database of every network and writes the
+// differences as JSON
+func runVerify(ctx context.Context, server *Server, output io.Writer) error {
+	report := map[string][]IdentityDifference{}
+	failed := false
+	for _, name := range server.Names() {
+		service, _ := server.Network(name)
+		differences, err := service.verify(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		report[name] = differences
+		failed = failed || len(differences) > 0
+		log.Printf("[%s] Verified identity database: %d differences", name, len(differences))
+	}
+
+	enc := json.NewEncoder(output)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if failed {
+		return errVerifyFailed
+	}
+	return nil
+}
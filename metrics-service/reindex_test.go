@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestReindexAndVerify(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	bob := fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://bob.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "pure", Url: "acc://bob.acme/staking"}},
+	})
+	server := newTestServer(t, fake)
+	syncRegistry(t, server)
+	service := server.primary
+	ctx := context.Background()
+
+	// Damage the database: change alice, drop bob and add an identity that
+	// was never registered
+	put := func(identity *RegistrationIdentity) {
+		t.Helper()
+		data, err := json.Marshal(identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := service.db.Put(service.key(identityPrefix+identity.Identity), data, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice, err := service.getIdentityFromDB("acc://alice.acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.RejectDelegates = true
+	put(alice)
+	put(&RegistrationIdentity{Identity: "acc://mallory.acme", Status: "registered"})
+	if err := service.db.Delete(service.key(identityPrefix+"acc://bob.acme"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.db.Put([]byte("some-txid"), []byte(`{"chains":[]}`), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if err := runVerify(ctx, server, &output); !errors.Is(err, errVerifyFailed) {
+		t.Fatalf("verify error = %v, want %v", err, errVerifyFailed)
+	}
+	var report map[string][]IdentityDifference
+	if err := json.Unmarshal(output.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	want := []IdentityDifference{
+		{Identity: "acc://alice.acme", Problem: "changed", Changes: []FieldChange{{Field: "rejectDelegates", Old: true}}},
+		{Identity: "acc://bob.acme", Problem: "missing"},
+		{Identity: "acc://mallory.acme", Problem: "unexpected"},
+	}
+	if got := report["mainnet"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("differences = %+v, want %+v", got, want)
+	}
+
+	// A replay that cannot complete leaves the database as it was
+	fake.FailScope(registrationScope(bob), 1)
+	if err := service.reindex(ctx); err == nil {
+		t.Fatal("reindex succeeded with a failing entry")
+	}
+	if identity, _ := service.getIdentityFromDB("acc://mallory.acme"); identity == nil {
+		t.Error("failed reindex changed the database")
+	}
+
+	if err := runReindex(ctx, server, &output); err != nil {
+		t.Fatal(err)
+	}
+	differences, err := service.verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(differences) != 0 {
+		t.Errorf("differences after reindex = %+v", differences)
+	}
+	if identity, _ := service.getIdentityFromDB("acc://bob.acme"); identity == nil || identity.Accounts[0].Type != "pure" {
+		t.Errorf("bob = %+v", identity)
+	}
+
+	// Timestamps survive and the reindex keyspace is gone
+	if ok, _ := service.db.Has([]byte("some-txid"), nil); !ok {
+		t.Error("reindex dropped a cached timestamp")
+	}
+	iter := service.db.NewIterator(util.BytesPrefix([]byte(reindexPrefix)), nil)
+	defer iter.Release()
+	if iter.Next() {
+		t.Errorf("reindex left %s behind", iter.Key())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Server serves the metrics API for one or more networks from a single
+// process and database
+type Server struct {
+	config   *Config
+	primary  *Service
+	networks map[string]*Service
+	names    []string
+}
+
+// NewServer creates a service for every configured network. The primary
+// network uses the root keyspace of db so existing databases keep working;
+// additional networks use the "{network}:" prefix.
+func NewServer(config *Config, db *leveldb.DB, newClient func(*NetworkConfig) AccumulateClient) *Server {
+	s := &Server{
+		config:   config,
+		networks: map[string]*Service{},
+	}
+
+	for i, n := range config.AllNetworks() {
+		prefix := ""
+		if i > 0 {
+			prefix = n.Name + ":"
+		}
+		service := NewService(config, n, newClient(n), db, prefix)
+		if n.ArchiveAPI != "" {
+			service.archive = newClient(&NetworkConfig{Name: n.Name + "-archive", API: n.ArchiveAPI})
+		}
+		if i == 0 {
+			s.primary = service
+		}
+		s.networks[n.Name] = service
+		s.names = append(s.names, n.Name)
+	}
+	return s
+}
+
+// Names returns the names of the served networks, primary first
+func (s *Server) Names() []string {
+	return s.names
+}
+
+// Network returns the service for the named network
+func (s *Server) Network(name string) (*Service, bool) {
+	service, ok := s.networks[name]
+	return service, ok
+}
+
+// Start launches the background registry updater, supply refresh, major
+// block indexer, pending timestamp resolver and webhook dispatcher of every
+// network
+func (s *Server) Start(ctx context.Context) {
+	for _, name := range s.names {
+		service := s.networks[name]
+		go service.runUpdater(ctx, s.config.UpdateInterval)
+		go service.supply.run(ctx, s.config.CacheDuration, name)
+		go service.runMajorBlockIndexer(ctx, s.config.UpdateInterval)
+		go service.runTimestampResolver(ctx, s.config.UpdateInterval)
+		go service.runWebhooks(ctx)
+	}
+}
+
+// Router returns the HTTP routes for all networks. The unprefixed routes are
+// registered first so they always resolve to the primary network.
+func (s *Server) Router() *mux.Router {
+	router := mux.NewRouter()
+
+	// Primary network (unprefixed, for compatibility)
+	router.HandleFunc("/v1/supply", s.primary.getSupplyHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v2/supply", s.primary.getSupplyV2Handler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/timestamp/{txid}", s.primary.getTimestampHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/timestamps", s.primary.getTimestampsHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/v1/blocks/minor/{partition}/{index}", s.primary.getMinorBlockHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/blocks/major/{index}", s.primary.getMajorBlockHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/blocks/at", s.primary.getBlockAtHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/staking/apr", s.primary.getStakingAPRHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/stream", s.primary.streamHandler).Methods("GET")
+	router.HandleFunc("/staking/stakers/{url:.*}", s.primary.getStakingAccountHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/payouts/{url:.*}", s.primary.getPayoutHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/delegates/{url:.*}", s.primary.getDelegateHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/graph", s.primary.getDelegationGraphHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/snapshots", s.primary.listSnapshotsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/snapshots/diff", s.primary.diffSnapshotsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/snapshots/{majorBlock}", s.primary.getSnapshotHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/unlocks", s.primary.getUnlocksHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/identities", s.primary.listIdentitiesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/accounts", s.primary.listAccountsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/identities/{adi:.+}/history", s.primary.getIdentityHistoryHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/staking/anomalies", s.primary.getAnomaliesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/registry/gaps", s.requireAdmin(s.primary.getRegistryGapsHandler)).Methods("GET")
+	router.HandleFunc("/admin/webhooks", s.requireAdmin(s.primary.listWebhooksHandler)).Methods("GET")
+	router.HandleFunc("/admin/webhooks", s.requireAdmin(s.primary.createWebhookHandler)).Methods("POST")
+	router.HandleFunc("/admin/webhooks/{id}", s.requireAdmin(s.primary.getWebhookHandler)).Methods("GET")
+	router.HandleFunc("/admin/webhooks/{id}", s.requireAdmin(s.primary.deleteWebhookHandler)).Methods("DELETE")
+	router.HandleFunc("/admin/webhooks/{id}/ping", s.requireAdmin(s.primary.pingWebhookHandler)).Methods("POST")
+	router.HandleFunc("/admin/webhooks/{id}/deliveries", s.requireAdmin(s.primary.listDeliveriesHandler)).Methods("GET")
+	router.HandleFunc("/health", healthHandler).Methods("GET")
+
+	// Per-network routes. The network variable only matches configured
+	// names, so e.g. /v1/timestamp/supply is never taken for a network.
+	quoted := make([]string, len(s.names))
+	for i, name := range s.names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	network := "{network:" + strings.Join(quoted, "|") + "}"
+
+	router.HandleFunc("/v1/"+network+"/supply", s.withNetwork((*Service).getSupplyHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v2/"+network+"/supply", s.withNetwork((*Service).getSupplyV2Handler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/timestamp/{txid}", s.withNetwork((*Service).getTimestampHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/timestamps", s.withNetwork((*Service).getTimestampsHandler)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/blocks/minor/{partition}/{index}", s.withNetwork((*Service).getMinorBlockHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/blocks/major/{index}", s.withNetwork((*Service).getMajorBlockHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/blocks/at", s.withNetwork((*Service).getBlockAtHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/staking/apr", s.withNetwork((*Service).getStakingAPRHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/v1/"+network+"/stream", s.withNetwork((*Service).streamHandler)).Methods("GET")
+	router.HandleFunc("/"+network+"/staking/stakers/{url:.*}", s.withNetwork((*Service).getStakingAccountHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/payouts/{url:.*}", s.withNetwork((*Service).getPayoutHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/delegates/{url:.*}", s.withNetwork((*Service).getDelegateHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/graph", s.withNetwork((*Service).getDelegationGraphHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/snapshots", s.withNetwork((*Service).listSnapshotsHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/snapshots/diff", s.withNetwork((*Service).diffSnapshotsHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/snapshots/{majorBlock}", s.withNetwork((*Service).getSnapshotHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/unlocks", s.withNetwork((*Service).getUnlocksHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/identities", s.withNetwork((*Service).listIdentitiesHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/accounts", s.withNetwork((*Service).listAccountsHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/identities/{adi:.+}/history", s.withNetwork((*Service).getIdentityHistoryHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/staking/anomalies", s.withNetwork((*Service).getAnomaliesHandler)).Methods("GET", "OPTIONS")
+	router.HandleFunc("/"+network+"/admin/registry/gaps", s.requireAdmin(s.withNetwork((*Service).getRegistryGapsHandler))).Methods("GET")
+	router.HandleFunc("/"+network+"/admin/webhooks", s.requireAdmin(s.withNetwork((*Service).listWebhooksHandler))).Methods("GET")
+	router.HandleFunc("/"+network+"/admin/webhooks", s.requireAdmin(s.withNetwork((*Service).createWebhookHandler))).Methods("POST")
+	router.HandleFunc("/"+network+"/admin/webhooks/{id}", s.requireAdmin(s.withNetwork((*Service).getWebhookHandler))).Methods("GET")
+	router.HandleFunc("/"+network+"/admin/webhooks/{id}", s.requireAdmin(s.withNetwork((*Service).deleteWebhookHandler))).Methods("DELETE")
+	router.HandleFunc("/"+network+"/admin/webhooks/{id}/ping", s.requireAdmin(s.withNetwork((*Service).pingWebhookHandler))).Methods("POST")
+	router.HandleFunc("/"+network+"/admin/webhooks/{id}/deliveries", s.requireAdmin(s.withNetwork((*Service).listDeliveriesHandler))).Methods("GET")
+
+	return router
+}
+
+// withNetwork adapts a service handler to dispatch on the {network} route variable
+func (s *Server) withNetwork(handler func(*Service, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service, ok := s.networks[mux.Vars(r)["network"]]
+		if !ok {
+			http.Error(w, "Unknown network", http.StatusNotFound)
+			return
+		}
+		handler(service, w, r)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func newKermitServer(t *testing.T) (*Server, *fakeAccumulate, *fakeAccumulate) {
+	t.Helper()
+
+	mainnet := newFakeAccumulate(t)
+	scriptSupply(t, mainnet)
+
+	kermit := newFakeAccumulate(t)
+	kermit.SetAccount(AccountRecord{
+		Type:        "tokenIssuer",
+		URL:         "acc://ACME",
+		Issued:      "10000000000000000",
+		SupplyLimit: "50000000000000000",
+	})
+	kermit.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://carol.acme/staking", TokenURL: acmeTokenURL, Balance: "200000000000000"})
+	kermit.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://carol.acme",
+		Status:   "registered",
+		Accounts: []Account{{Type: "pure", Url: "acc://carol.acme/staking"}},
+	})
+
+	config := DefaultConfig()
+	config.Networks = []NetworkConfig{{Name: "kermit"}}
+	config.applyNetworkDefaults()
+
+	server := newMultiNetworkServer(t, config, map[string]*fakeAccumulate{
+		"mainnet": mainnet,
+		"kermit":  kermit,
+	})
+	return server, mainnet, kermit
+}
+
+func TestMultiNetworkSupply(t *testing.T) {
+	server, _, _ := newKermitServer(t)
+	router := server.Router()
+
+	for path, wantTotal := range map[string]int64{
+		"/v1/supply":         300000000,
+		"/v1/mainnet/supply": 300000000,
+		"/v1/kermit/supply":  100000000,
+	} {
+		rec := get(t, router, path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, rec.Code, rec.Body.String())
+		}
+		var metrics SupplyMetrics
+		decode(t, rec, &metrics)
+		if metrics.Total != wantTotal {
+			t.Errorf("%s: total = %d, want %d", path, metrics.Total, wantTotal)
+		}
+	}
+
+	// The mainnet alias and the prefixed route share one cache
+	if got := get(t, router, "/v1/mainnet/supply").Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("X-Cache = %q, want HIT", got)
+	}
+
+	if rec := get(t, router, "/v1/devnet/supply"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown network: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestMultiNetworkKeyspaces(t *testing.T) {
+	server, _, _ := newKermitServer(t)
+	router := server.Router()
+	syncRegistry(t, server)
+
+	// Each network has its own identity database
+	if rec := get(t, router, "/kermit/staking/stakers/carol.acme/staking"); rec.Code != http.StatusOK {
+		t.Errorf("kermit lookup: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get(t, router, "/staking/stakers/carol.acme/staking"); rec.Code != http.StatusNotFound {
+		t.Errorf("mainnet lookup of kermit account: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := get(t, router, "/kermit/staking/stakers/alice.acme/staking"); rec.Code != http.StatusNotFound {
+		t.Errorf("kermit lookup of mainnet account: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	mainnet, _ := server.Network("mainnet")
+	kermit, _ := server.Network("kermit")
+	if _, err := mainnet.getIdentityFromDB("acc://alice.acme"); err != nil {
+		t.Errorf("mainnet identity not in root keyspace: %v", err)
+	}
+	if _, err := kermit.db.Get([]byte("kermit:identity:acc://carol.acme"), nil); err != nil {
+		t.Errorf("kermit identity not under kermit: prefix: %v", err)
+	}
+}
+
+func TestMultiNetworkTimestamp(t *testing.T) {
+	server, mainnet, kermit := newKermitServer(t)
+	router := server.Router()
+
+	txid := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	kermit.SetTransaction("acc://"+txid+"@unknown", TransactionRecord{Status: "delivered"})
+	kermit.SetTimestamp(txid, []ChainEntry{{Chain: "main", Block: 42, Time: "2026-02-21T19:22:52Z"}})
+
+	rec := get(t, router, "/v1/kermit/timestamp/"+txid)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var data TimestampData
+	decode(t, rec, &data)
+	if data.MinorBlock != 42 {
+		t.Errorf("minorBlock = %d, want 42", data.MinorBlock)
+	}
+
+	// Not cached (or known) on mainnet
+	if rec := get(t, router, "/v1/timestamp/"+txid); rec.Code != http.StatusNotFound {
+		t.Errorf("mainnet: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if mainnet.Calls("transaction") != 1 {
+		t.Errorf("mainnet transaction queries = %d, want 1", mainnet.Calls("transaction"))
+	}
+}
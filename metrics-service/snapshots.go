@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// snapshotPrefix is the key prefix of staking snapshots:
+// snapshot:{major block} -> StakingSnapshot
+const snapshotPrefix = "snapshot:"
+
+// StakingSnapshot is the state of every registered staking account in a
+// major block. It is taken by the first complete supply refresh of the
+// block, so no snapshot exists for blocks the service was down for.
+type StakingSnapshot struct {
+	MajorBlock int64             `json:"majorBlock"`
+	BlockTime  string            `json:"blockTime"`  // Start of the major block (RFC 3339)
+	TakenAt    string            `json:"takenAt"`    // When the balances were fetched (RFC 3339)
+	Checkpoint int64             `json:"checkpoint"` // Registry chain index the registrations are current to
+	Precision  int               `json:"precision"`
+	Total      Amount            `json:"total"`
+	Accounts   []SnapshotAccount `json:"accounts"`
+}
+
+// SnapshotAccount is a staking account in a snapshot
+type SnapshotAccount struct {
+	Account  string `json:"account"`
+	Identity string `json:"identity"`
+	Type     string `json:"type,omitempty"`
+	Delegate string `json:"delegate,omitempty"`
+	Lockup   uint64 `json:"lockup,omitempty"`
+	Balance  Amount `json:"balance"`
+}
+
+// SnapshotSummary is a snapshot without its accounts
+type SnapshotSummary struct {
+	MajorBlock int64  `json:"majorBlock"`
+	BlockTime  string `json:"blockTime"`
+	TakenAt    string `json:"takenAt"`
+	Accounts   int    `json:"accounts"`
+	Total      Amount `json:"total"`
+}
+
+// SnapshotDiff is the change between two snapshots. Changed accounts list
+// their changed fields; balances are compared as raw amounts.
+type SnapshotDiff struct {
+	From       int64             `json:"from"`
+	To         int64             `json:"to"`
+	TotalDelta Amount            `json:"totalDelta"`
+	Added      []SnapshotAccount `json:"added"`
+	Removed    []SnapshotAccount `json:"removed"`
+	Changed    []AccountChange   `json:"changed"`
+}
+
+// AccountChange is an account present in both snapshots of a diff
+type AccountChange struct {
+	Account      string        `json:"account"`
+	BalanceDelta Amount        `json:"balanceDelta"`
+	Changes      []FieldChange `json:"changes"`
+}
+
+func (s *Service) snapshotKey(majorBlock int64) []byte {
+	return s.key(fmt.Sprintf("%s%020d", snapshotPrefix, majorBlock))
+}
+
+// majorBlockTime returns the scheduled start of an absolute post-genesis
+// major block, the inverse of calculateMajorBlock
+func (n *NetworkConfig) majorBlockTime(majorBlock int64) time.Time {
+	periods := majorBlock - n.PreGenesisBlockOffset - 1
+	return n.GenesisResetTime.Add(time.Duration(periods) * n.MajorBlockInterval)
+}
+
+// recordSnapshot stores the snapshot of the major block of at from a complete
+// supply refresh, unless the block already has one. Accounts registered after
+// the balances were fetched are left out.
+func (s *Service) recordSnapshot(supply *Supply, at time.Time) error {
+	majorBlock, _ := s.majorBlockAt(at)
+	if majorBlock == 0 {
+		return nil
+	}
+	if ok, err := s.db.Has(s.snapshotKey(majorBlock), nil); err != nil || ok {
+		return err
+	}
+
+	fetched := map[string]*big.Int{}
+	for url, balance := range supply.Balances {
+		fetched[url] = balance
+	}
+	for _, url := range supply.MissingAccounts {
+		fetched[url] = new(big.Int)
+	}
+
+	checkpoint := s.getLastQueriedIndex()
+	identities, err := s.getAllIdentitiesFromDB()
+	if err != nil {
+		return err
+	}
+
+	snapshot := &StakingSnapshot{
+		MajorBlock: majorBlock,
+		BlockTime:  s.majorBlockStart(majorBlock).UTC().Format(time.RFC3339),
+		TakenAt:    at.UTC().Format(time.RFC3339),
+		Checkpoint: checkpoint,
+		Precision:  supply.Precision,
+		Accounts:   []SnapshotAccount{},
+	}
+	total := new(big.Int)
+	seen := map[string]bool{}
+	for identityURL, identity := range identities {
+		if identityStatus(identity) != "registered" {
+			continue
+		}
+		for _, account := range identity.Accounts {
+			balance, ok := fetched[account.Url]
+			if !ok || seen[account.Url] {
+				continue
+			}
+			seen[account.Url] = true
+			total.Add(total, balance)
+			snapshot.Accounts = append(snapshot.Accounts, SnapshotAccount{
+				Account:  account.Url,
+				Identity: identityURL,
+				Type:     account.Type,
+				Delegate: account.Delegate,
+				Lockup:   account.Lockup,
+				Balance:  newAmount(balance, supply.Precision),
+			})
+		}
+	}
+	sort.Slice(snapshot.Accounts, func(i, j int) bool { return snapshot.Accounts[i].Account < snapshot.Accounts[j].Account })
+	snapshot.Total = newAmount(total, supply.Precision)
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Put(s.snapshotKey(majorBlock), data, nil); err != nil {
+		return err
+	}
+	log.Printf("[%s] Recorded staking snapshot of major block %d: %d accounts, %s staked",
+		s.network.Name, majorBlock, len(snapshot.Accounts), snapshot.Total.Decimal)
+	return nil
+}
+
+// getSnapshot returns the snapshot of a major block
+func (s *Service) getSnapshot(majorBlock int64) (*StakingSnapshot, error) {
+	data, err := s.db.Get(s.snapshotKey(majorBlock), nil)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot StakingSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// listSnapshots returns the summaries of all snapshots in block order
+func (s *Service) listSnapshots() ([]SnapshotSummary, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(snapshotPrefix)), nil)
+	defer iter.Release()
+
+	summaries := []SnapshotSummary{}
+	for iter.Next() {
+		var snapshot StakingSnapshot
+		if err := json.Unmarshal(iter.Value(), &snapshot); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, SnapshotSummary{
+			MajorBlock: snapshot.MajorBlock,
+			BlockTime:  snapshot.BlockTime,
+			TakenAt:    snapshot.TakenAt,
+			Accounts:   len(snapshot.Accounts),
+			Total:      snapshot.Total,
+		})
+	}
+	return summaries, iter.Error()
+}
+
+// diffSnapshots returns the changes from one snapshot to another
+func diffSnapshots(from, to *StakingSnapshot) *SnapshotDiff {
+	precision := to.Precision
+	diff := &SnapshotDiff{
+		From:       from.MajorBlock,
+		To:         to.MajorBlock,
+		TotalDelta: amountDelta(from.Total, to.Total, precision),
+		Added:      []SnapshotAccount{},
+		Removed:    []SnapshotAccount{},
+		Changed:    []AccountChange{},
+	}
+
+	old := map[string]SnapshotAccount{}
+	for _, account := range from.Accounts {
+		old[account.Account] = account
+	}
+	for _, account := range to.Accounts {
+		previous, ok := old[account.Account]
+		if !ok {
+			diff.Added = append(diff.Added, account)
+			continue
+		}
+		delete(old, account.Account)
+
+		changes := diffFields(snapshotFields(previous), snapshotFields(account))
+		if len(changes) > 0 {
+			diff.Changed = append(diff.Changed, AccountChange{
+				Account:      account.Account,
+				BalanceDelta: amountDelta(previous.Balance, account.Balance, precision),
+				Changes:      changes,
+			})
+		}
+	}
+	for _, account := range from.Accounts {
+		if _, ok := old[account.Account]; ok {
+			diff.Removed = append(diff.Removed, account)
+		}
+	}
+	return diff
+}
+
+// snapshotFields flattens the non-zero fields of a snapshot account
+func snapshotFields(a SnapshotAccount) map[string]interface{} {
+	fields := map[string]interface{}{"identity": a.Identity, "balance": a.Balance.Raw}
+	if a.Type != "" {
+		fields["type"] = a.Type
+	}
+	if a.Delegate != "" {
+		fields["delegate"] = a.Delegate
+	}
+	if a.Lockup != 0 {
+		fields["lockup"] = a.Lockup
+	}
+	return fields
+}
+
+// amountDelta returns to - from
+func amountDelta(from, to Amount, precision int) Amount {
+	a, _ := new(big.Int).SetString(from.Raw, 10)
+	b, _ := new(big.Int).SetString(to.Raw, 10)
+	if a == nil {
+		a = new(big.Int)
+	}
+	if b == nil {
+		b = new(big.Int)
+	}
+	return newAmount(b.Sub(b, a), precision)
+}
+
+// parseMajorBlock parses a major block route variable or query parameter
+func parseMajorBlock(v string) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid major block %q", v)
+	}
+	return n, nil
+}
+
+// List staking snapshots handler
+func (s *Service) listSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	summaries, err := s.listSnapshots()
+	if err != nil {
+		log.Printf("Error listing snapshots: %v", err)
+		http.Error(w, "Failed to list snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"snapshots": summaries})
+}
+
+// Get staking snapshot handler
+func (s *Service) getSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	majorBlock, err := parseMajorBlock(mux.Vars(r)["majorBlock"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snapshot, ok := s.loadSnapshot(w, majorBlock)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// Diff staking snapshots handler
+func (s *Service) diffSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := parseMajorBlock(query.Get("from"))
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseMajorBlock(query.Get("to"))
+	if err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fromSnapshot, ok := s.loadSnapshot(w, from)
+	if !ok {
+		return
+	}
+	toSnapshot, ok := s.loadSnapshot(w, to)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diffSnapshots(fromSnapshot, toSnapshot))
+}
+
+// loadSnapshot reads a snapshot for a handler, writing the error response if
+// it fails
+func (s *Service) loadSnapshot(w http.ResponseWriter, majorBlock int64) (*StakingSnapshot, bool) {
+	snapshot, err := s.getSnapshot(majorBlock)
+	switch {
+	case errors.Is(err, leveldb.ErrNotFound):
+		http.Error(w, fmt.Sprintf("No snapshot of major block %d", majorBlock), http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		log.Printf("Error reading snapshot %d: %v", majorBlock, err)
+		http.Error(w, "Failed to read snapshot", http.StatusInternalServerError)
+		return nil, false
+	}
+	return snapshot, true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStakingSnapshots(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	server := newTestServer(t, fake)
+	router := server.Router()
+	service := server.primary
+	syncRegistry(t, server)
+
+	// Major block 1866 starts 12 hours after the genesis reset
+	now := time.Date(2025, 7, 14, 13, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	if err := service.supply.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get(t, router, "/staking/snapshots/1866")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var snapshot StakingSnapshot
+	decode(t, rec, &snapshot)
+	if snapshot.BlockTime != "2025-07-14T12:00:00Z" || snapshot.TakenAt != "2025-07-14T13:00:00Z" || snapshot.Total.Raw != "150000000000000" || len(snapshot.Accounts) != 2 {
+		t.Fatalf("snapshot = %+v", snapshot)
+	}
+	if bob := snapshot.Accounts[1]; bob.Account != "acc://bob.acme/staking" || bob.Delegate != "acc://alice.acme" || bob.Balance.Raw != "50000000000000" {
+		t.Errorf("bob = %+v", bob)
+	}
+
+	// A later refresh in the same block keeps the first snapshot
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://alice.acme/staking", TokenURL: acmeTokenURL, Balance: "120000000000000"})
+	now = now.Add(time.Hour)
+	if err := service.supply.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	decode(t, get(t, router, "/staking/snapshots/1866"), &snapshot)
+	if snapshot.TakenAt != "2025-07-14T13:00:00Z" {
+		t.Errorf("snapshot was replaced at %s", snapshot.TakenAt)
+	}
+
+	// The next block sees alice's new balance and bob's deletion
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "deleted"})
+	syncRegistry(t, server)
+	now = now.Add(12 * time.Hour)
+	if err := service.supply.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var list struct{ Snapshots []SnapshotSummary }
+	decode(t, get(t, router, "/staking/snapshots"), &list)
+	if len(list.Snapshots) != 2 || list.Snapshots[0].MajorBlock != 1866 || list.Snapshots[1].MajorBlock != 1867 || list.Snapshots[1].Accounts != 1 {
+		t.Fatalf("snapshots = %+v", list.Snapshots)
+	}
+
+	rec = get(t, router, "/staking/snapshots/diff?from=1866&to=1867")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var diff SnapshotDiff
+	decode(t, rec, &diff)
+	if diff.TotalDelta.Raw != "-30000000000000" || len(diff.Added) != 0 {
+		t.Errorf("diff = %+v", diff)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Account != "acc://bob.acme/staking" {
+		t.Errorf("removed = %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].BalanceDelta.Raw != "20000000000000" || len(diff.Changed[0].Changes) != 1 || diff.Changed[0].Changes[0].Field != "balance" {
+		t.Errorf("changed = %+v", diff.Changed)
+	}
+
+	for path, want := range map[string]int{
+		"/staking/snapshots/1865":                   http.StatusNotFound,
+		"/staking/snapshots/latest":                 http.StatusBadRequest,
+		"/staking/snapshots/diff?from=1866":         http.StatusBadRequest,
+		"/staking/snapshots/diff?from=1866&to=1900": http.StatusNotFound,
+	} {
+		if rec := get(t, router, path); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
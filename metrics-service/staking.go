@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Listing page sizes
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// IdentitySummary is a registered identity in the /staking/identities listing
+type IdentitySummary struct {
+	Identity           string           `json:"identity"`
+	Status             string           `json:"status"`
+	DelegatorPayout    string           `json:"delegatorPayout,omitempty"`
+	RejectDelegates    bool             `json:"rejectDelegates"`
+	AcceptingDelegates string           `json:"acceptingDelegates,omitempty"`
+	Accounts           []AccountSummary `json:"accounts"`
+	Staked             *Amount          `json:"staked,omitempty"` // Sum of the account balances, if known
+}
+
+// AccountSummary is a staking account with its balance as of the latest
+// supply refresh
+type AccountSummary struct {
+	Account
+	Identity string  `json:"identity,omitempty"` // Set in the /staking/accounts listing
+	Balance  *Amount `json:"balance,omitempty"`
+}
+
+// IdentityList is a page of the /staking/identities listing
+type IdentityList struct {
+	Identities []IdentitySummary `json:"identities"`
+	NextCursor string            `json:"nextCursor,omitempty"` // Pass as cursor to get the next page
+}
+
+// AccountList is a page of the /staking/accounts listing
+type AccountList struct {
+	Accounts   []AccountSummary `json:"accounts"`
+	NextCursor string           `json:"nextCursor,omitempty"` // Pass as cursor to get the next page
+}
+
+// stakingQuery is a parsed listing request
+type stakingQuery struct {
+	// Identity filters
+	status          string
+	rejectDelegates *bool
+
+	// Account filters. An identity matches if any of its accounts does.
+	accountType string
+	delegate    string
+	lockup      *uint64
+
+	byBalance bool // Sort by staked balance, descending, instead of by URL
+	limit     int
+	cursor    *listCursor
+}
+
+// listCursor is the position after the last item of a page. Pages are
+// keyset-paginated, so in URL order concurrent writes never repeat or skip
+// other items. Balance-sorted pages are only consistent within one supply
+// snapshot: a refresh between pages can move items across the cursor.
+type listCursor struct {
+	Identity string `json:"i"`
+	Account  string `json:"a,omitempty"`
+	Balance  string `json:"b,omitempty"` // Set when sorting by balance
+}
+
+func (c *listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseStakingQuery(q url.Values) (*stakingQuery, error) {
+	query := &stakingQuery{
+		status:      q.Get("status"),
+		accountType: q.Get("type"),
+		limit:       defaultListLimit,
+	}
+	if v := q.Get("delegate"); v != "" {
+		query.delegate = normalizeAccURL(v)
+	}
+	if v := q.Get("rejectDelegates"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rejectDelegates %q", v)
+		}
+		query.rejectDelegates = &b
+	}
+	if v := q.Get("lockup"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lockup %q", v)
+		}
+		query.lockup = &n
+	}
+
+	switch q.Get("sort") {
+	case "", "url":
+	case "balance":
+		query.byBalance = true
+	default:
+		return nil, fmt.Errorf("invalid sort %q (want url or balance)", q.Get("sort"))
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return nil, fmt.Errorf("invalid limit %q (want 1-%d)", v, maxListLimit)
+		}
+		query.limit = n
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		data, err := base64.RawURLEncoding.DecodeString(v)
+		var cursor listCursor
+		if err == nil {
+			err = json.Unmarshal(data, &cursor)
+		}
+		if err != nil || cursor.Identity == "" || query.byBalance != (cursor.Balance != "") {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		query.cursor = &cursor
+	}
+	return query, nil
+}
+
+// identityStatus returns the status of a registration; legacy entries without
+// a status are registered
+func identityStatus(identity *RegistrationIdentity) string {
+	if identity.Status == "" {
+		return "registered"
+	}
+	return identity.Status
+}
+
+func (q *stakingQuery) matchIdentity(identity *RegistrationIdentity) bool {
+	if q.status != "" && identityStatus(identity) != q.status {
+		return false
+	}
+	if q.rejectDelegates != nil && identity.RejectDelegates != *q.rejectDelegates {
+		return false
+	}
+	if q.accountType == "" && q.delegate == "" && q.lockup == nil {
+		return true
+	}
+	for _, account := range identity.Accounts {
+		if q.matchAccount(&account) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *stakingQuery) matchAccount(account *Account) bool {
+	return (q.accountType == "" || account.Type == q.accountType) &&
+		(q.delegate == "" || account.Delegate == q.delegate) &&
+		(q.lockup == nil || account.Lockup == *q.lockup)
+}
+
+// listItem is a listing entry with its sort keys
+type listItem struct {
+	identity string
+	account  string
+	balance  *big.Int
+	value    interface{}
+}
+
+func (i *listItem) cursor(byBalance bool) *listCursor {
+	c := &listCursor{Identity: i.identity, Account: i.account}
+	if byBalance {
+		c.Balance = i.balance.String()
+	}
+	return c
+}
+
+// after returns true if the item sorts after the cursor
+func (i *listItem) after(c *listCursor, byBalance bool) bool {
+	if byBalance {
+		balance, ok := new(big.Int).SetString(c.Balance, 10)
+		if ok {
+			if cmp := i.balance.Cmp(balance); cmp != 0 {
+				return cmp < 0
+			}
+		}
+	}
+	if i.identity != c.Identity {
+		return i.identity > c.Identity
+	}
+	return i.account > c.Account
+}
+
+// forEachIdentity calls fn for every stored identity in URL order, starting
+// at from, until fn returns false
+func (s *Service) forEachIdentity(from string, fn func(identityURL string, identity *RegistrationIdentity) bool) error {
+	prefix := s.key(identityPrefix)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	ok := iter.First()
+	if from != "" {
+		ok = iter.Seek(s.key(identityPrefix + from))
+	}
+	for ; ok; ok = iter.Next() {
+		identityURL := string(iter.Key()[len(prefix):])
+		var identity RegistrationIdentity
+		if err := json.Unmarshal(iter.Value(), &identity); err != nil {
+			log.Printf("Warning: Failed to unmarshal identity %s: %v", identityURL, err)
+			continue
+		}
+		if !fn(identityURL, &identity) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// listItems collects a page of items. Without balance sorting, the scan
+// starts at the cursor and stops once the page is full; sorting by balance
+// requires a full scan.
+func (s *Service) listItems(q *stakingQuery, items func(identityURL string, identity *RegistrationIdentity) []*listItem) ([]*listItem, *listCursor, error) {
+	from := ""
+	if q.cursor != nil && !q.byBalance {
+		from = q.cursor.Identity
+	}
+
+	var matched []*listItem
+	err := s.forEachIdentity(from, func(identityURL string, identity *RegistrationIdentity) bool {
+		for _, item := range items(identityURL, identity) {
+			if q.cursor == nil || item.after(q.cursor, q.byBalance) {
+				matched = append(matched, item)
+			}
+		}
+		return q.byBalance || len(matched) <= q.limit
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if q.byBalance {
+		sort.SliceStable(matched, func(i, j int) bool {
+			if cmp := matched[i].balance.Cmp(matched[j].balance); cmp != 0 {
+				return cmp > 0
+			}
+			if matched[i].identity != matched[j].identity {
+				return matched[i].identity < matched[j].identity
+			}
+			return matched[i].account < matched[j].account
+		})
+	}
+
+	if len(matched) <= q.limit {
+		return matched, nil, nil
+	}
+	page := matched[:q.limit]
+	return page, page[len(page)-1].cursor(q.byBalance), nil
+}
+
+// accountSummary returns an account with its balance, if known
+func accountSummary(account Account, supply *Supply) (AccountSummary, *big.Int) {
+	summary := AccountSummary{Account: account}
+	if supply == nil || supply.Balances == nil {
+		return summary, new(big.Int)
+	}
+	balance, ok := supply.Balances[account.Url]
+	if !ok {
+		balance = new(big.Int)
+	}
+	amount := newAmount(balance, supply.Precision)
+	summary.Balance = &amount
+	return summary, balance
+}
+
+// sortedAccounts returns the accounts of an identity ordered by URL
+func sortedAccounts(identity *RegistrationIdentity) []Account {
+	accounts := append([]Account(nil), identity.Accounts...)
+	sort.SliceStable(accounts, func(i, j int) bool { return accounts[i].Url < accounts[j].Url })
+	return accounts
+}
+
+// stakingListing parses the listing request and returns the latest supply for
+// account balances. It writes an error response and returns false on failure.
+func (s *Service) stakingListing(w http.ResponseWriter, r *http.Request) (*stakingQuery, *Supply, bool) {
+	query, err := parseStakingQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	// Balances come from the supply cache; they are optional unless the
+	// listing is sorted by them
+	var supply *Supply
+	snapshot, err := s.supply.Get(r.Context())
+	if err == nil && snapshot.Supply.Balances != nil {
+		supply = snapshot.Supply
+	} else if query.byBalance {
+		log.Printf("Error fetching staking balances: %v", err)
+		http.Error(w, "Staking balances are not available", http.StatusServiceUnavailable)
+		return nil, nil, false
+	}
+	return query, supply, true
+}
+
+// List staking identities handler
+func (s *Service) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	query, supply, ok := s.stakingListing(w, r)
+	if !ok {
+		return
+	}
+
+	page, next, err := s.listItems(query, func(identityURL string, identity *RegistrationIdentity) []*listItem {
+		if !query.matchIdentity(identity) {
+			return nil
+		}
+
+		summary := IdentitySummary{
+			Identity:           identityURL,
+			Status:             identityStatus(identity),
+			DelegatorPayout:    identity.DelegatorPayout,
+			RejectDelegates:    identity.RejectDelegates,
+			AcceptingDelegates: identity.AcceptingDelegates,
+			Accounts:           []AccountSummary{},
+		}
+		staked := new(big.Int)
+		for _, account := range sortedAccounts(identity) {
+			entry, balance := accountSummary(account, supply)
+			summary.Accounts = append(summary.Accounts, entry)
+			staked.Add(staked, balance)
+		}
+		if supply != nil {
+			amount := newAmount(staked, supply.Precision)
+			summary.Staked = &amount
+		}
+		return []*listItem{{identity: identityURL, balance: staked, value: summary}}
+	})
+	if err != nil {
+		log.Printf("Error listing identities: %v", err)
+		http.Error(w, "Failed to list identities", http.StatusInternalServerError)
+		return
+	}
+
+	list := &IdentityList{Identities: []IdentitySummary{}}
+	for _, item := range page {
+		list.Identities = append(list.Identities, item.value.(IdentitySummary))
+	}
+	if next != nil {
+		list.NextCursor = next.encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// List staking accounts handler
+func (s *Service) listAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	query, supply, ok := s.stakingListing(w, r)
+	if !ok {
+		return
+	}
+
+	page, next, err := s.listItems(query, func(identityURL string, identity *RegistrationIdentity) []*listItem {
+		if (query.status != "" && identityStatus(identity) != query.status) ||
+			(query.rejectDelegates != nil && identity.RejectDelegates != *query.rejectDelegates) {
+			return nil
+		}
+
+		var items []*listItem
+		for _, account := range sortedAccounts(identity) {
+			if !query.matchAccount(&account) {
+				continue
+			}
+			summary, balance := accountSummary(account, supply)
+			summary.Identity = identityURL
+			items = append(items, &listItem{identity: identityURL, account: account.Url, balance: balance, value: summary})
+		}
+		return items
+	})
+	if err != nil {
+		log.Printf("Error listing accounts: %v", err)
+		http.Error(w, "Failed to list accounts", http.StatusInternalServerError)
+		return
+	}
+
+	list := &AccountList{Accounts: []AccountSummary{}}
+	for _, item := range page {
+		list.Accounts = append(list.Accounts, item.value.(AccountSummary))
+	}
+	if next != nil {
+		list.NextCursor = next.encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// scriptStakers adds carol, a pure staker with a lockup who rejects
+// delegates, to the scriptSupply network
+func scriptStakers(t *testing.T, fake *fakeAccumulate) {
+	t.Helper()
+	scriptSupply(t, fake)
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://carol.acme/staking", TokenURL: acmeTokenURL, Balance: "70000000000000"})
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://carol.acme/locked", TokenURL: acmeTokenURL, Balance: "10000000000000"})
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity:        "acc://carol.acme",
+		Status:          "registered",
+		RejectDelegates: true,
+		Accounts: []Account{
+			{Type: "pure", Url: "acc://carol.acme/staking"},
+			{Type: "pure", Url: "acc://carol.acme/locked", Lockup: 4, HardLock: true},
+		},
+	})
+}
+
+// listIdentities follows the cursor through every page of the listing and
+// returns the identity URLs in order
+func listIdentities(t *testing.T, router http.Handler, query url.Values) []string {
+	t.Helper()
+	var urls []string
+	for page := 0; ; page++ {
+		rec := get(t, router, "/staking/identities?"+query.Encode())
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query.Encode(), rec.Code, rec.Body.String())
+		}
+		var list IdentityList
+		decode(t, rec, &list)
+		for _, identity := range list.Identities {
+			urls = append(urls, identity.Identity)
+		}
+		if list.NextCursor == "" {
+			return urls
+		}
+		if page > 10 {
+			t.Fatal("pagination does not terminate")
+		}
+		query.Set("cursor", list.NextCursor)
+	}
+}
+
+func TestListIdentities(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptStakers(t, fake)
+	router := newTestServer(t, fake).Router()
+
+	rec := get(t, router, "/staking/identities")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var list IdentityList
+	decode(t, rec, &list)
+	if len(list.Identities) != 3 || list.NextCursor != "" {
+		t.Fatalf("list = %+v", list)
+	}
+	carol := list.Identities[2]
+	if carol.Identity != "acc://carol.acme" || carol.Status != "registered" || !carol.RejectDelegates {
+		t.Errorf("carol = %+v", carol)
+	}
+	if carol.Staked == nil || carol.Staked.Raw != "80000000000000" {
+		t.Errorf("carol staked = %+v", carol.Staked)
+	}
+	if len(carol.Accounts) != 2 || carol.Accounts[0].Url != "acc://carol.acme/locked" || carol.Accounts[0].Balance.Raw != "10000000000000" {
+		t.Errorf("carol accounts = %+v", carol.Accounts)
+	}
+
+	for query, want := range map[string][]string{
+		"type=delegated":        {"acc://bob.acme"},
+		"delegate=alice.acme":   {"acc://bob.acme"},
+		"rejectDelegates=true":  {"acc://carol.acme"},
+		"rejectDelegates=false": {"acc://alice.acme", "acc://bob.acme"},
+		"lockup=4":              {"acc://carol.acme"},
+		"status=deleted":        nil,
+		"type=pure&lockup=0":    {"acc://carol.acme"},
+		"limit=1":               {"acc://alice.acme", "acc://bob.acme", "acc://carol.acme"},
+		"limit=2&sort=balance":  {"acc://alice.acme", "acc://carol.acme", "acc://bob.acme"},
+	} {
+		values, _ := url.ParseQuery(query)
+		if got := listIdentities(t, router, values); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: identities = %v, want %v", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"sort=stake", "limit=0", "lockup=-1", "rejectDelegates=maybe", "cursor=garbage"} {
+		if rec := get(t, router, "/staking/identities?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestListAccounts(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptStakers(t, fake)
+	router := newTestServer(t, fake).Router()
+
+	var urls []string
+	path := "/staking/accounts?sort=balance&limit=3"
+	for {
+		rec := get(t, router, path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var list AccountList
+		decode(t, rec, &list)
+		for _, account := range list.Accounts {
+			if account.Identity == "" || account.Balance == nil {
+				t.Errorf("account = %+v", account)
+			}
+			urls = append(urls, account.Url)
+		}
+		if list.NextCursor == "" {
+			break
+		}
+		path = "/staking/accounts?sort=balance&limit=3&cursor=" + list.NextCursor
+	}
+
+	want := []string{"acc://alice.acme/staking", "acc://carol.acme/staking", "acc://bob.acme/staking", "acc://carol.acme/locked"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("accounts = %v, want %v", urls, want)
+	}
+
+	var list AccountList
+	decode(t, get(t, router, "/staking/accounts?type=pure&lockup=4"), &list)
+	if len(list.Accounts) != 1 || list.Accounts[0].Url != "acc://carol.acme/locked" || list.Accounts[0].Identity != "acc://carol.acme" {
+		t.Errorf("filtered accounts = %+v", list.Accounts)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	streamEventPrefix = "stream:event:"   // event ID -> StreamEvent
+	streamSequenceKey = "stream:sequence" // ID of the last event
+)
+
+// Stream event types
+const (
+	streamRegistry = "registry" // The updater ingested staking registry entries
+	streamSupply   = "supply"   // The supply cache refreshed
+	streamReset    = "reset"    // Events after Last-Event-ID are no longer in the log
+)
+
+const (
+	streamLogLimit  = 1000             // Events kept for resuming
+	streamBuffer    = 64               // Events buffered per subscriber before it is dropped
+	streamKeepalive = 15 * time.Second // Interval of keepalive comments
+	streamRetry     = 5 * time.Second  // Reconnection delay sent to clients
+)
+
+// StreamEvent is an event of the /v1/stream log
+type StreamEvent struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// RegistryUpdate is the data of a registry event
+type RegistryUpdate struct {
+	Time         string   `json:"time"`
+	From         int64    `json:"from"` // First entry processed
+	To           int64    `json:"to"`   // Last entry processed
+	Checkpoint   int64    `json:"checkpoint"`
+	TotalEntries int64    `json:"totalEntries"`
+	Outcomes     Outcomes `json:"outcomes"`
+	Identities   []string `json:"identities"` // Identities set by applied entries
+}
+
+// Outcomes counts the registry entries processed by outcome
+type Outcomes struct {
+	Applied    int `json:"applied"`
+	Superseded int `json:"superseded"`
+	Ignored    int `json:"ignored"`
+	Invalid    int `json:"invalid"`
+	Failed     int `json:"failed"`
+}
+
+func (s *Service) streamEventKey(id uint64) []byte {
+	return s.key(fmt.Sprintf("%s%020d", streamEventPrefix, id))
+}
+
+// lastStreamEventID returns the ID of the last event. The caller must hold
+// streamMu.
+func (s *Service) lastStreamEventID() (uint64, error) {
+	data, err := s.db.Get(s.key(streamSequenceKey), nil)
+	switch {
+	case errors.Is(err, leveldb.ErrNotFound):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	case len(data) != 8:
+		return 0, fmt.Errorf("invalid stream sequence")
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// publish appends an event to the log and sends it to the subscribers.
+// Subscribers that have fallen behind are dropped; they resume from the log
+// when they reconnect.
+func (s *Service) publish(eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[%s] Error encoding %s event: %v", s.network.Name, eventType, err)
+		return
+	}
+
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	last, err := s.lastStreamEventID()
+	if err != nil {
+		log.Printf("[%s] Error reading event log: %v", s.network.Name, err)
+		return
+	}
+	event := &StreamEvent{ID: last + 1, Type: eventType, Data: payload}
+	value, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[%s] Error encoding %s event: %v", s.network.Name, eventType, err)
+		return
+	}
+
+	var sequence [8]byte
+	binary.BigEndian.PutUint64(sequence[:], event.ID)
+	batch := new(leveldb.Batch)
+	batch.Put(s.streamEventKey(event.ID), value)
+	batch.Put(s.key(streamSequenceKey), sequence[:])
+	if event.ID > streamLogLimit {
+		batch.Delete(s.streamEventKey(event.ID - streamLogLimit))
+	}
+	if err := s.db.Write(batch, nil); err != nil {
+		log.Printf("[%s] Error writing event log: %v", s.network.Name, err)
+		return
+	}
+
+	for ch := range s.streamSubs {
+		select {
+		case ch <- event:
+		default:
+			delete(s.streamSubs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber and, if resume is set, returns the
+// logged events after lastID. reset is true if some of them are no longer in
+// the log. A subscriber that does not resume only receives new events.
+func (s *Service) subscribe(lastID uint64, resume bool) (replay []*StreamEvent, ch chan *StreamEvent, reset bool, err error) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	current, err := s.lastStreamEventID()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if !resume {
+		lastID = current
+	}
+	if lastID > current {
+		// The log was reset (e.g. a new database); replay all of it
+		lastID, reset = 0, true
+	}
+	if lastID < current {
+		iter := s.db.NewIterator(&util.Range{
+			Start: s.streamEventKey(lastID + 1),
+			Limit: s.streamEventKey(current + 1),
+		}, nil)
+		for iter.Next() {
+			var event StreamEvent
+			if err := json.Unmarshal(iter.Value(), &event); err != nil {
+				iter.Release()
+				return nil, nil, false, err
+			}
+			replay = append(replay, &event)
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, nil, false, err
+		}
+		reset = reset || len(replay) == 0 || replay[0].ID != lastID+1
+	}
+
+	ch = make(chan *StreamEvent, streamBuffer)
+	if s.streamSubs == nil {
+		s.streamSubs = map[chan *StreamEvent]bool{}
+	}
+	s.streamSubs[ch] = true
+	return replay, ch, reset, nil
+}
+
+// unsubscribe removes a subscriber if publish has not dropped it already
+func (s *Service) unsubscribe(ch chan *StreamEvent) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.streamSubs[ch] {
+		delete(s.streamSubs, ch)
+		close(ch)
+	}
+}
+
+// publishRegistryUpdate publishes a registry event for an updater run that
+// processed entries from to totalEntries-1
+func (s *Service) publishRegistryUpdate(from, totalEntries, checkpoint int64, stats map[string]int, applied map[string]bool) {
+	identities := make([]string, 0, len(applied))
+	for identity := range applied {
+		identities = append(identities, identity)
+	}
+	sort.Strings(identities)
+
+	s.publish(streamRegistry, &RegistryUpdate{
+		Time:         s.now().UTC().Format(time.RFC3339),
+		From:         from,
+		To:           totalEntries - 1,
+		Checkpoint:   checkpoint,
+		TotalEntries: totalEntries,
+		Outcomes: Outcomes{
+			Applied:    stats[entryApplied],
+			Superseded: stats[entrySuperseded],
+			Ignored:    stats[entryIgnored],
+			Invalid:    stats[entryInvalid],
+			Failed:     stats[entryFailed],
+		},
+		Identities: identities,
+	})
+}
+
+// publishSupply publishes a supply event for a refreshed supply
+func (s *Service) publishSupply(supply *Supply, asOf time.Time) {
+	metrics := supply.MetricsV2()
+	metrics.AsOf = asOf.UTC().Format(time.RFC3339)
+	s.publish(streamSupply, metrics)
+}
+
+// writeStreamEvent writes an event in the SSE wire format. Events without an
+// ID do not move the client's Last-Event-ID.
+func writeStreamEvent(w http.ResponseWriter, id uint64, eventType string, data []byte) error {
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+	return err
+}
+
+// Stream events handler
+func (s *Service) streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// EventSource sends Last-Event-ID when it reconnects; the query
+	// parameter lets clients resume on their first connection
+	var lastID uint64
+	resume := false
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("lastEventId")
+	}
+	if v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", v), http.StatusBadRequest)
+			return
+		}
+		lastID, resume = id, true
+	}
+
+	replay, events, reset, err := s.subscribe(lastID, resume)
+	if err != nil {
+		log.Printf("Error reading event log: %v", err)
+		http.Error(w, "Failed to read event log", http.StatusInternalServerError)
+		return
+	}
+	defer s.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+
+	if reset {
+		var oldest uint64
+		if len(replay) > 0 {
+			oldest = replay[0].ID
+		}
+		writeStreamEvent(w, 0, streamReset, []byte(fmt.Sprintf(`{"lastEventId":%d,"oldest":%d}`, lastID, oldest)))
+	}
+	for _, event := range replay {
+		if err := writeStreamEvent(w, event.ID, event.Type, event.Data); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client resumes from the log
+				return
+			}
+			if err := writeStreamEvent(w, event.ID, event.Type, event.Data); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
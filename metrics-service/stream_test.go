@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is an event read from an SSE stream
+type sseEvent struct {
+	ID, Type, Data string
+}
+
+// openStream connects to an SSE stream and returns its events. The stream is
+// closed when the test ends or the returned function is called.
+func openStream(t *testing.T, url, lastEventID string) (<-chan sseEvent, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	ch := make(chan sseEvent, 16)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		var event sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				event.ID = value
+			case "event":
+				event.Type = value
+			case "data":
+				event.Data = value
+			case "":
+				if event.Type != "" {
+					ch <- event
+				}
+				event = sseEvent{}
+			}
+		}
+	}()
+	t.Cleanup(cancel)
+	return ch, cancel
+}
+
+// nextEvent returns the next event of a stream
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+func TestStream(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	server := newTestServer(t, fake)
+	service := server.primary
+	api := httptest.NewServer(server.Router())
+	t.Cleanup(api.Close)
+	ctx := context.Background()
+
+	events, disconnect := openStream(t, api.URL+"/v1/stream", "")
+
+	syncRegistry(t, server)
+	event := nextEvent(t, events)
+	var update RegistryUpdate
+	if err := json.Unmarshal([]byte(event.Data), &update); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != "1" || event.Type != streamRegistry || update.Outcomes.Applied != 2 || strings.Join(update.Identities, ",") != "acc://alice.acme,acc://bob.acme" {
+		t.Errorf("registry event = %+v, data = %+v", event, update)
+	}
+
+	if err := service.supply.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	var supply SupplyMetricsV2
+	if err := json.Unmarshal([]byte(event.Data), &supply); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != "2" || event.Type != streamSupply || supply.Staked.Raw != "150000000000000" || supply.AsOf == "" {
+		t.Errorf("supply event = %+v", event)
+	}
+
+	// Events published while disconnected are replayed on reconnection
+	disconnect()
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://carol.acme", Status: "registered"})
+	syncRegistry(t, server)
+
+	events, _ = openStream(t, api.URL+"/v1/stream", "1")
+	if first, second := nextEvent(t, events), nextEvent(t, events); first.ID != "2" || second.ID != "3" || !strings.Contains(second.Data, "acc://carol.acme") {
+		t.Errorf("replay = %+v, %+v", first, second)
+	}
+
+	// An ID the log does not know resets the client and replays everything
+	events, _ = openStream(t, api.URL+"/v1/stream", "99")
+	if reset, first := nextEvent(t, events), nextEvent(t, events); reset.Type != streamReset || reset.ID != "" || first.ID != "1" {
+		t.Errorf("reset = %+v, first = %+v", reset, first)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/stream", nil)
+	req.Header.Set("Last-Event-ID", "latest")
+	rec := httptest.NewRecorder()
+	server.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid Last-Event-ID status = %d", rec.Code)
+	}
+}
+
+func TestStreamWithoutLastEventID(t *testing.T) {
+	server := newTestServer(t, newFakeAccumulate(t))
+	service := server.primary
+	api := httptest.NewServer(server.Router())
+	t.Cleanup(api.Close)
+	for i := 0; i < 5; i++ {
+		service.publish(streamSupply, map[string]int{"n": i})
+	}
+
+	// A new client is not sent the logged events, only live ones
+	events, _ := openStream(t, api.URL+"/v1/stream", "")
+	service.publish(streamSupply, map[string]int{"n": 5})
+	if event := nextEvent(t, events); event.ID != "6" || event.Data != `{"n":5}` {
+		t.Errorf("first event = %+v, want the live event 6", event)
+	}
+}
+
+func TestStreamLogLimit(t *testing.T) {
+	server := newTestServer(t, newFakeAccumulate(t))
+	service := server.primary
+	for i := 0; i < streamLogLimit+5; i++ {
+		service.publish(streamSupply, map[string]int{"n": i})
+	}
+
+	// Resuming from a pruned event resets the client
+	replay, ch, reset, err := service.subscribe(3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.unsubscribe(ch)
+	if !reset || len(replay) != streamLogLimit || replay[0].ID != 6 {
+		t.Errorf("reset = %v, %d events from %d", reset, len(replay), replay[0].ID)
+	}
+
+	replay, ch2, reset, err := service.subscribe(6, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.unsubscribe(ch2)
+	if reset || len(replay) != streamLogLimit-1 {
+		t.Errorf("reset = %v, %d events", reset, len(replay))
+	}
+}
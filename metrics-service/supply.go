@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ACME has precision=8, meaning 1 ACME = 10^8 smallest units
+const acmePrecision = 8
+
+// acmeTokenURL is the ACME token issuer
+const acmeTokenURL = "acc://ACME"
+
+// SupplyMetrics represents the supply data for ACME token
+//
+// Deprecated: the integer fields are whole ACME, truncated. Use SupplyMetricsV2
+// (/v2/supply) for exact amounts.
+type SupplyMetrics struct {
+	Max               int64 `json:"max"`
+	Total             int64 `json:"total"`
+	Circulating       int64 `json:"circulating"`
+	CirculatingTokens int64 `json:"circulatingTokens"` // Alias for compatibility with Explorer
+	Staked            int64 `json:"staked"`
+
+	StakedByType     map[string]int64  `json:"stakedByType,omitempty"`     // Staked by registered account type
+	ExcludedAccounts []ExcludedAccount `json:"excludedAccounts,omitempty"` // Registered accounts not counted as staked
+
+	AsOf  string `json:"asOf"`  // When the supply was computed (RFC 3339)
+	Stale bool   `json:"stale"` // True if older than the cache duration; a refresh is pending
+}
+
+// SupplyMetricsV2 represents the exact supply data for ACME token
+type SupplyMetricsV2 struct {
+	Precision   int    `json:"precision"`
+	Max         Amount `json:"max"`
+	Total       Amount `json:"total"`
+	Circulating Amount `json:"circulating"`
+	Staked      Amount `json:"staked"`
+
+	StakingAccounts  int               `json:"stakingAccounts"`            // Number of staking accounts summed into Staked
+	MissingAccounts  []string          `json:"missingAccounts,omitempty"`  // Registered staking accounts that do not exist
+	StakedByType     map[string]Amount `json:"stakedByType,omitempty"`     // Staked by registered account type
+	ExcludedAccounts []ExcludedAccount `json:"excludedAccounts,omitempty"` // Registered accounts not counted as staked
+
+	AsOf  string `json:"asOf"`  // When the supply was computed (RFC 3339)
+	Stale bool   `json:"stale"` // True if older than the cache duration; a refresh is pending
+
+	// Set if the latest refresh failed, in which case the previous supply is
+	// served. FailedAccounts lists the staking accounts whose balance could
+	// not be fetched.
+	RefreshError   string           `json:"refreshError,omitempty"`
+	FailedAccounts []BalanceFailure `json:"failedAccounts,omitempty"`
+}
+
+// Amount is an exact token amount
+type Amount struct {
+	Raw     string `json:"raw"`     // Atomic units as a base-10 integer string
+	Decimal string `json:"decimal"` // Raw / 10^precision, e.g. "1234.50000000"
+}
+
+// Supply is the ACME supply in atomic units
+type Supply struct {
+	Precision int
+	Max       *big.Int
+	Total     *big.Int
+	Staked    *big.Int
+
+	StakingAccounts  int                 // Number of staking accounts queried
+	MissingAccounts  []string            // Staking accounts that do not exist
+	Balances         map[string]*big.Int // Balance of each staking account
+	StakedByType     map[string]*big.Int // Staked by registered account type
+	ExcludedAccounts []ExcludedAccount   // Staking accounts not counted as staked
+}
+
+// Circulating returns the issued tokens that are not staked
+func (s *Supply) Circulating() *big.Int {
+	return new(big.Int).Sub(s.Total, s.Staked)
+}
+
+// Metrics returns the legacy whole-token representation of the supply
+func (s *Supply) Metrics() *SupplyMetrics {
+	total := wholeTokens(s.Total, s.Precision)
+	staked := wholeTokens(s.Staked, s.Precision)
+
+	// Circulating = issued - staked
+	circulating := total - staked
+
+	metrics := &SupplyMetrics{
+		Max:               wholeTokens(s.Max, s.Precision),
+		Total:             total,
+		Circulating:       circulating,
+		CirculatingTokens: circulating, // Same as Circulating for compatibility
+		Staked:            staked,
+		ExcludedAccounts:  s.ExcludedAccounts,
+	}
+	if s.StakedByType != nil {
+		metrics.StakedByType = map[string]int64{}
+		for accountType, amount := range s.StakedByType {
+			metrics.StakedByType[accountType] = wholeTokens(amount, s.Precision)
+		}
+	}
+	return metrics
+}
+
+// MetricsV2 returns the exact representation of the supply
+func (s *Supply) MetricsV2() *SupplyMetricsV2 {
+	metrics := &SupplyMetricsV2{
+		Precision:   s.Precision,
+		Max:         newAmount(s.Max, s.Precision),
+		Total:       newAmount(s.Total, s.Precision),
+		Circulating: newAmount(s.Circulating(), s.Precision),
+		Staked:      newAmount(s.Staked, s.Precision),
+
+		StakingAccounts:  s.StakingAccounts,
+		MissingAccounts:  s.MissingAccounts,
+		ExcludedAccounts: s.ExcludedAccounts,
+	}
+	if s.StakedByType != nil {
+		metrics.StakedByType = map[string]Amount{}
+		for accountType, amount := range s.StakedByType {
+			metrics.StakedByType[accountType] = newAmount(amount, s.Precision)
+		}
+	}
+	return metrics
+}
+
+func newAmount(raw *big.Int, precision int) Amount {
+	return Amount{
+		Raw:     raw.String(),
+		Decimal: formatAmount(raw, precision),
+	}
+}
+
+// parseAmount parses a base-10 integer amount as returned by the API
+func parseAmount(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return v, nil
+}
+
+// formatAmount formats an amount in atomic units as a decimal with exactly
+// precision fractional digits
+func formatAmount(raw *big.Int, precision int) string {
+	digits := new(big.Int).Abs(raw).String()
+	sign := ""
+	if raw.Sign() < 0 {
+		sign = "-"
+	}
+	if precision <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= precision {
+		digits = strings.Repeat("0", precision-len(digits)+1) + digits
+	}
+	split := len(digits) - precision
+	return sign + digits[:split] + "." + digits[split:]
+}
+
+// wholeTokens converts atomic units to whole tokens, truncating the
+// fractional part and saturating at the int64 range
+func wholeTokens(raw *big.Int, precision int) int64 {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	whole := new(big.Int).Quo(raw, scale)
+	switch {
+	case whole.IsInt64():
+		return whole.Int64()
+	case whole.Sign() < 0:
+		return math.MinInt64
+	default:
+		return math.MaxInt64
+	}
+}
+
+// Get supply metrics handler
+func (s *Service) getSupplyHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveSupply(w, r, func(snapshot *SupplySnapshot) interface{} {
+		metrics := snapshot.Supply.Metrics()
+		metrics.AsOf = snapshot.AsOf.UTC().Format(time.RFC3339)
+		metrics.Stale = snapshot.Stale
+		return metrics
+	})
+}
+
+// Get exact supply metrics handler
+func (s *Service) getSupplyV2Handler(w http.ResponseWriter, r *http.Request) {
+	s.serveSupply(w, r, func(snapshot *SupplySnapshot) interface{} {
+		metrics := snapshot.Supply.MetricsV2()
+		metrics.AsOf = snapshot.AsOf.UTC().Format(time.RFC3339)
+		metrics.Stale = snapshot.Stale
+		if snapshot.Err != nil {
+			metrics.RefreshError = snapshot.Err.Error()
+			var incomplete *IncompleteBalancesError
+			if errors.As(snapshot.Err, &incomplete) {
+				metrics.FailedAccounts = incomplete.Failed
+			}
+		}
+		return metrics
+	})
+}
+
+// serveSupply serves the cached supply in the representation returned by render
+func (s *Service) serveSupply(w http.ResponseWriter, r *http.Request, render func(*SupplySnapshot) interface{}) {
+	snapshot, err := s.supply.Get(r.Context())
+	if err != nil {
+		log.Printf("Error fetching metrics: %v", err)
+		http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", snapshot.Status)
+	w.Header().Set("Last-Modified", snapshot.AsOf.UTC().Format(http.TimeFormat))
+	json.NewEncoder(w).Encode(render(snapshot))
+}
+
+// Fetch supply from the network
+func (s *Service) fetchSupply(ctx context.Context) (*Supply, error) {
+	// Query ACME token issuer from Accumulate network using v3 API
+	acme, err := s.client.QueryAccount(ctx, acmeTokenURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the issued tokens value (as string from API) - this is in smallest units
+	issued, err := parseAmount(acme.Issued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued amount: %w", err)
+	}
+
+	// Parse the supply limit - this is in smallest units
+	supplyLimit, err := parseAmount(acme.SupplyLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse supply limit: %w", err)
+	}
+
+	precision := acme.Precision
+	if precision == 0 {
+		precision = acmePrecision
+	}
+
+	supply := &Supply{
+		Precision: precision,
+		Max:       supplyLimit,
+		Total:     issued,
+	}
+
+	// Query actual staked amount from registered staking accounts
+	balances, err := s.queryStakedAmount(ctx)
+	switch {
+	case err != nil:
+		// Never report an estimate as the staked total. The cache keeps
+		// serving the previous supply and reports the error.
+		return nil, fmt.Errorf("failed to query staked amount: %w", err)
+	case len(balances.Failed) > 0:
+		// Nor a partial sum. The cache also reports the failed accounts.
+		return nil, &IncompleteBalancesError{Accounts: balances.Accounts, Failed: balances.Failed}
+	default:
+		supply.Staked = balances.Total
+		supply.StakingAccounts = balances.Accounts
+		supply.MissingAccounts = balances.Missing
+		supply.Balances = balances.Balances
+		supply.StakedByType = balances.ByType
+		supply.ExcludedAccounts = balances.Excluded
+		if err := s.recordSnapshot(supply, s.now()); err != nil {
+			log.Printf("Error recording staking snapshot: %v", err)
+		}
+	}
+
+	log.Printf("Fetched metrics: Max=%s, Total=%s, Circulating=%s, Staked=%s",
+		formatAmount(supply.Max, precision), formatAmount(supply.Total, precision),
+		formatAmount(supply.Circulating(), precision), formatAmount(supply.Staked, precision))
+
+	return supply, nil
+}
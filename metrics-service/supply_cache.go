@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// supplyCache holds the latest supply of a network.
+//
+// The supply is refreshed on a schedule by run. Concurrent misses share a
+// single upstream computation, and once a value exists it is always served
+// immediately: an expired value is returned as stale while a refresh runs in
+// the background (stale-while-revalidate).
+type supplyCache struct {
+	fetch  func(context.Context) (*Supply, error)
+	maxAge time.Duration
+	now    func() time.Time
+
+	// Called with each successfully refreshed supply
+	onRefresh func(*Supply, time.Time)
+
+	// Context for refreshes. Refreshes are shared by all callers, so they
+	// must not be cancelled by the request that happened to start them.
+	ctx context.Context
+
+	mu       sync.Mutex
+	value    *Supply
+	asOf     time.Time
+	lastErr  error // Error of the latest refresh, nil if it succeeded
+	inflight *supplyRefresh
+}
+
+// supplyRefresh is an in-flight upstream computation
+type supplyRefresh struct {
+	done  chan struct{}
+	value *Supply
+	asOf  time.Time
+	err   error
+}
+
+// SupplySnapshot is a cached supply with its freshness metadata
+type SupplySnapshot struct {
+	Supply *Supply
+	AsOf   time.Time // When the supply was computed
+	Stale  bool      // True if the supply is older than the cache duration
+	Status string    // X-Cache value: HIT, MISS or STALE
+	Err    error     // Error of the latest refresh, if it failed
+}
+
+func newSupplyCache(fetch func(context.Context) (*Supply, error), maxAge time.Duration) *supplyCache {
+	return &supplyCache{
+		fetch:  fetch,
+		maxAge: maxAge,
+		now:    time.Now,
+		ctx:    context.Background(),
+	}
+}
+
+// Get returns the cached supply. If there is no value yet it waits for the
+// (possibly shared) refresh; if the value has expired it returns it as stale
+// and starts a refresh.
+func (c *supplyCache) Get(ctx context.Context) (*SupplySnapshot, error) {
+	c.mu.Lock()
+	if c.value != nil {
+		snapshot := &SupplySnapshot{Supply: c.value, AsOf: c.asOf, Status: "HIT", Err: c.lastErr}
+		if c.now().Sub(c.asOf) >= c.maxAge {
+			snapshot.Stale = true
+			snapshot.Status = "STALE"
+			c.startLocked()
+		}
+		c.mu.Unlock()
+		return snapshot, nil
+	}
+	refresh := c.startLocked()
+	c.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if refresh.err != nil {
+		return nil, refresh.err
+	}
+	return &SupplySnapshot{Supply: refresh.value, AsOf: refresh.asOf, Status: "MISS"}, nil
+}
+
+// Refresh recomputes the supply, joining a refresh that is already running,
+// and waits for it to complete
+func (c *supplyCache) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	refresh := c.startLocked()
+	c.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startLocked returns the in-flight refresh, starting one if there is none.
+// The caller must hold c.mu.
+func (c *supplyCache) startLocked() *supplyRefresh {
+	if c.inflight != nil {
+		return c.inflight
+	}
+
+	refresh := &supplyRefresh{done: make(chan struct{})}
+	c.inflight = refresh
+	ctx := c.ctx
+	go func() {
+		value, err := c.fetch(ctx)
+
+		c.mu.Lock()
+		refresh.value, refresh.err = value, err
+		c.lastErr = err
+		if err == nil {
+			refresh.asOf = c.now()
+			c.value, c.asOf = value, refresh.asOf
+		}
+		c.inflight = nil
+		c.mu.Unlock()
+
+		if err == nil && c.onRefresh != nil {
+			c.onRefresh(value, refresh.asOf)
+		}
+		close(refresh.done)
+	}()
+	return refresh
+}
+
+// run refreshes the supply immediately and then every interval until ctx is
+// cancelled
+func (c *supplyCache) run(ctx context.Context, interval time.Duration, name string) {
+	c.mu.Lock()
+	c.ctx = ctx
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[%s] Supply refresh error: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for supplyCache.now
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// blockingFetch returns a fetch function that counts its calls and blocks
+// until a value or error is sent on the returned channel
+func blockingFetch() (func(context.Context) (*Supply, error), chan error, *int32) {
+	results := make(chan error)
+	var calls int32
+	fetch := func(ctx context.Context) (*Supply, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if err := <-results; err != nil {
+			return nil, err
+		}
+		return &Supply{Precision: 8, Max: big.NewInt(100), Total: big.NewInt(int64(n)), Staked: big.NewInt(0)}, nil
+	}
+	return fetch, results, &calls
+}
+
+func TestSupplyCacheCoalescesMisses(t *testing.T) {
+	fetch, results, calls := blockingFetch()
+	cache := newSupplyCache(fetch, time.Minute)
+
+	const callers = 50
+	var wg sync.WaitGroup
+	snapshots := make([]*SupplySnapshot, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			snapshot, err := cache.Get(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			snapshots[i] = snapshot
+		}(i)
+	}
+
+	// Let every caller reach the shared refresh before completing it
+	for atomic.LoadInt32(calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	results <- nil
+	wg.Wait()
+
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Fatalf("fetch called %d times, want 1", n)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot == nil || snapshot.Status != "MISS" || snapshot.Stale {
+			t.Fatalf("unexpected snapshot %+v", snapshot)
+		}
+	}
+}
+
+func TestSupplyCacheStaleWhileRevalidate(t *testing.T) {
+	fetch, results, calls := blockingFetch()
+	cache := newSupplyCache(fetch, time.Minute)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	cache.now = clock.Now
+
+	go func() { results <- nil }()
+	first, err := cache.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if snapshot, _ := cache.Get(context.Background()); snapshot.Status != "HIT" || snapshot.Stale {
+		t.Fatalf("fresh value: %+v", snapshot)
+	}
+
+	// Expired: served immediately as stale while one refresh runs
+	clock.Advance(time.Minute)
+	for i := 0; i < 10; i++ {
+		snapshot, err := cache.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Status != "STALE" || !snapshot.Stale || !snapshot.AsOf.Equal(first.AsOf) {
+			t.Fatalf("expired value: %+v", snapshot)
+		}
+	}
+
+	// Completing the refresh replaces the value
+	clock.Advance(time.Second)
+	results <- nil
+	for {
+		snapshot, _ := cache.Get(context.Background())
+		if snapshot.Status == "HIT" {
+			if !snapshot.AsOf.After(first.AsOf) {
+				t.Errorf("asOf = %v, want after %v", snapshot.AsOf, first.AsOf)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("fetch called %d times, want 2", n)
+	}
+}
+
+func TestSupplyCacheKeepsValueOnError(t *testing.T) {
+	fetch, results, _ := blockingFetch()
+	cache := newSupplyCache(fetch, time.Minute)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	cache.now = clock.Now
+
+	// No value yet: the error is returned
+	go func() { results <- errors.New("upstream down") }()
+	if _, err := cache.Get(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	go func() { results <- nil }()
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed refresh leaves the previous value in place, marked stale
+	clock.Advance(2 * time.Minute)
+	go func() { results <- errors.New("upstream down") }()
+	if err := cache.Refresh(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	snapshot, err := cache.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.Stale || snapshot.Supply == nil {
+		t.Errorf("snapshot = %+v, want stale value", snapshot)
+	}
+	go func() { results <- nil }()
+	cache.Refresh(context.Background())
+}
+
+func TestSupplyHandlerConcurrentMisses(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	router := newTestServer(t, fake).Router()
+
+	release := fake.Hold()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		path := "/v1/supply"
+		if i%2 == 1 {
+			path = "/v2/supply"
+		}
+		go func() {
+			defer wg.Done()
+			if rec := get(t, router, path); rec.Code != http.StatusOK {
+				t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	release()
+	wg.Wait()
+
+	if n := fake.ScopeCalls("acc://ACME"); n != 1 {
+		t.Errorf("ACME queried %d times, want 1", n)
+	}
+}
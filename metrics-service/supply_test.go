@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		raw       string
+		precision int
+		want      string
+	}{
+		{"0", 8, "0.00000000"},
+		{"1", 8, "0.00000001"},
+		{"12345678", 8, "0.12345678"},
+		{"123456789", 8, "1.23456789"},
+		{"50000000000000000", 8, "500000000.00000000"},
+		{"-150000000", 8, "-1.50000000"},
+		{"42", 0, "42"},
+		{"123456789012345678901234567890", 8, "1234567890123456789012.34567890"},
+	}
+	for _, c := range cases {
+		raw, err := parseAmount(c.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatAmount(raw, c.precision); got != c.want {
+			t.Errorf("formatAmount(%s, %d) = %s, want %s", c.raw, c.precision, got, c.want)
+		}
+	}
+
+	if _, err := parseAmount("1.5"); err == nil {
+		t.Error("expected an error for a non-integer amount")
+	}
+}
+
+func TestWholeTokens(t *testing.T) {
+	huge, _ := new(big.Int).SetString("1000000000000000000000000000000", 10)
+	if got := wholeTokens(huge, 8); got != math.MaxInt64 {
+		t.Errorf("wholeTokens(huge) = %d, want saturation", got)
+	}
+	if got := wholeTokens(big.NewInt(199999999), 8); got != 1 {
+		t.Errorf("wholeTokens(1.99999999) = %d, want 1", got)
+	}
+}
+
+func TestSupplyV2(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+
+	// Fractional ACME and a balance beyond the int64 range
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://alice.acme/staking", TokenURL: acmeTokenURL, Balance: "100000000000012345"})
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://bob.acme/staking", TokenURL: acmeTokenURL, Balance: "9223372036854775807"})
+	router := newTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Precision != 8 {
+		t.Errorf("precision = %d", metrics.Precision)
+	}
+	if metrics.Staked.Raw != "9323372036854788152" || metrics.Staked.Decimal != "93233720368.54788152" {
+		t.Errorf("staked = %+v", metrics.Staked)
+	}
+	if metrics.Circulating.Raw != "-9293372036854788152" {
+		t.Errorf("circulating = %+v", metrics.Circulating)
+	}
+	if metrics.Max.Decimal != "500000000.00000000" {
+		t.Errorf("max = %+v", metrics.Max)
+	}
+
+	// The legacy endpoint shares the cache and still reports whole tokens
+	rec = get(t, router, "/v1/supply")
+	if got := rec.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("X-Cache = %q, want HIT", got)
+	}
+	var legacy SupplyMetrics
+	decode(t, rec, &legacy)
+	if legacy.Staked != 93233720368 || legacy.Total != 300000000 {
+		t.Errorf("legacy = %+v", legacy)
+	}
+}
+
+func TestSupplyExcludedAccounts(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	scriptSupply(t, fake)
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://carol.acme/staking", TokenURL: acmeTokenURL, Balance: "30000000000000"})
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://carol.acme/other", TokenURL: "acc://carol.acme/tokens", Balance: "90000000000000"})
+	fake.SetAccount(AccountRecord{Type: "dataAccount", URL: "acc://carol.acme/data"})
+	fake.SetAccount(AccountRecord{Type: "tokenAccount", URL: "acc://dave.acme/staking", TokenURL: acmeTokenURL, Balance: "40000000000000"})
+	fake.AddRegistration(t, RegistrationIdentity{
+		Identity: "acc://carol.acme",
+		Status:   "registered",
+		Accounts: []Account{
+			{Type: "stakingValidator", Url: "acc://carol.acme/staking"},
+			{Type: "pure", Url: "acc://carol.acme/other"},
+			{Type: "pure", Url: "acc://carol.acme/data"},
+			// Listed by carol, but staked by alice
+			{Type: "pure", Url: "acc://alice.acme/staking"},
+			{Type: "pure", Url: "acc://dave.acme/staking"},
+		},
+	})
+	router := newTestServer(t, fake).Router()
+
+	rec := get(t, router, "/v2/supply")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics SupplyMetricsV2
+	decode(t, rec, &metrics)
+	if metrics.Staked.Raw != "180000000000000" || metrics.StakingAccounts != 5 {
+		t.Errorf("staked = %+v from %d accounts", metrics.Staked, metrics.StakingAccounts)
+	}
+	byType := map[string]string{}
+	for accountType, amount := range metrics.StakedByType {
+		byType[accountType] = amount.Raw
+	}
+	wantByType := map[string]string{"coreValidator": "100000000000000", "delegated": "50000000000000", "stakingValidator": "30000000000000"}
+	if !reflect.DeepEqual(byType, wantByType) {
+		t.Errorf("stakedByType = %v, want %v", byType, wantByType)
+	}
+	wantExcluded := []ExcludedAccount{
+		{URL: "acc://carol.acme/data", Identity: "acc://carol.acme", Reason: excludedNotTokenAccount, Detail: "account type is dataAccount"},
+		{URL: "acc://carol.acme/other", Identity: "acc://carol.acme", Reason: excludedNotACME, Detail: "token is acc://carol.acme/tokens"},
+		{URL: "acc://dave.acme/staking", Identity: "acc://carol.acme", Reason: excludedOutsideIdentity, Detail: "account is not under acc://carol.acme"},
+	}
+	if !reflect.DeepEqual(metrics.ExcludedAccounts, wantExcluded) {
+		t.Errorf("excludedAccounts = %+v, want %+v", metrics.ExcludedAccounts, wantExcluded)
+	}
+
+	var legacy SupplyMetrics
+	decode(t, get(t, router, "/v1/supply"), &legacy)
+	if legacy.Staked != 1800000 || legacy.StakedByType["stakingValidator"] != 300000 || len(legacy.ExcludedAccounts) != 3 {
+		t.Errorf("legacy = %+v", legacy)
+	}
+}
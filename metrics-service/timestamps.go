@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// X-Cache values of timestamp responses
+const (
+	cacheHitBlock   = "HIT-BLOCK"   // Block timestamp from the cache, never re-queried
+	cacheHitFinal   = "HIT-FINAL"   // Expired or failed transaction from the cache, never re-queried
+	cacheHitPending = "HIT-PENDING" // Signature timestamp from the cache, rechecked in the background
+	cacheHitSig     = "HIT-SIG"     // Signature timestamp from the cache, the upstream query failed
+	cacheMiss       = "MISS"        // First query, now cached
+	cacheUpdate     = "UPDATE"      // Signature timestamp re-queried and updated
+)
+
+// txPrefix is the key prefix of cached timestamps: tx:{txid} -> TimestampData
+const txPrefix = "tx:"
+
+// TimestampResponse is the public part of TimestampData
+type TimestampResponse struct {
+	Chains     []ChainEntry `json:"chains"`
+	Status     string       `json:"status,omitempty"`
+	MinorBlock int64        `json:"minorBlock,omitempty"`
+	MajorBlock int64        `json:"majorBlock,omitempty"`
+}
+
+// response returns the timestamp without the internal cache fields
+func (d *TimestampData) response() *TimestampResponse {
+	return &TimestampResponse{
+		Chains:     d.Chains,
+		Status:     d.Status,
+		MinorBlock: d.MinorBlock,
+		MajorBlock: d.MajorBlock,
+	}
+}
+
+// cleanTxid removes the acc:// prefix and @suffix of a transaction ID
+func cleanTxid(txid string) string {
+	txid = strings.TrimPrefix(txid, "acc://")
+	if idx := strings.Index(txid, "@"); idx >= 0 {
+		txid = txid[:idx]
+	}
+	return txid
+}
+
+// parseTxid returns the cleaned hash of a transaction ID. The hash must be
+// hex so arbitrary strings never reach the upstream queries or the tx: and
+// timestamp:pending: keys.
+func parseTxid(txid string) (string, bool) {
+	id := cleanTxid(txid)
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// getCachedTimestamp returns the cached timestamp of a transaction, or nil
+func (s *Service) getCachedTimestamp(txid string) *TimestampData {
+	data, err := s.db.Get(s.key(txPrefix+txid), nil)
+	if err != nil {
+		return nil
+	}
+
+	cached := &TimestampData{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		log.Printf("Error deserializing cached timestamp for %s: %v", txid, err)
+		return nil
+	}
+	return cached
+}
+
+// cacheStatus returns the X-Cache value of a cached timestamp that is served
+// without querying upstream: block timestamps, expired and failed
+// transactions, and pending transactions until their next check
+func (s *Service) cacheStatus(txid string, cached *TimestampData) (string, bool) {
+	switch {
+	case cached == nil:
+		return "", false
+	case cached.HasBlockTime:
+		s.resolveMajorBlock(txid, cached)
+		return cacheHitBlock, true
+	case cached.resolved():
+		return cacheHitFinal, true
+	case cached.NextCheck > s.now().UnixMilli():
+		return cacheHitPending, true
+	}
+	return "", false
+}
+
+// getTimestamp returns the timestamp of a transaction and its X-Cache value.
+// Cached timestamps are served while they are final or not due for a
+// recheck; others are queried upstream and cached, falling back to the
+// cached signature timestamp, rescheduled, if the query fails.
+func (s *Service) getTimestamp(ctx context.Context, txid string) (*TimestampData, string, error) {
+	cached := s.getCachedTimestamp(txid)
+	if cache, ok := s.cacheStatus(txid, cached); ok {
+		return cached, cache, nil
+	}
+
+	data, err := s.queryTimestamp(ctx, txid, cached)
+	switch {
+	case err == nil && cached != nil:
+		return data, cacheUpdate, nil
+	case err == nil:
+		return data, cacheMiss, nil
+	case cached != nil:
+		rescheduled := *cached
+		s.schedulePending(&rescheduled, cached)
+		if err := s.cacheTimestamp(txid, &rescheduled); err != nil {
+			log.Printf("Error caching timestamp for %s: %v", txid, err)
+		}
+		return &rescheduled, cacheHitSig, nil
+	}
+	return nil, "", err
+}
+
+// queryTimestamp queries the status and timestamp of a transaction and
+// caches them. The block timestamp is used if the v2 API knows it, otherwise
+// the oldest signature timestamp, including the one cached before, and the
+// transaction is scheduled for a recheck unless it failed or expired.
+func (s *Service) queryTimestamp(ctx context.Context, txid string, cached *TimestampData) (*TimestampData, error) {
+	// Query v3 API for transaction status and signatures
+	txRecord, err := s.client.QueryTransaction(ctx, fmt.Sprintf("acc://%s@unknown", txid))
+	if err != nil {
+		log.Printf("Error querying v3 API for %s: %v", txid, err)
+		return nil, err
+	}
+
+	// Try to get block timestamp from v2 timestamp endpoint
+	// This endpoint returns chain entries with minor block numbers if the transaction has been executed
+	// Note: Major block information is not currently available from this endpoint
+	hasBlockData := false
+	tsData := &TimestampData{
+		Status: txRecord.Status,
+	}
+
+	chains, err := s.client.QueryTimestamp(ctx, txid)
+	if err == nil && len(chains) > 0 {
+		// Found chain entries with block data - use this and cache permanently
+		tsData.Chains = chains
+		// Get the block number from the first chain entry (all should have the same block)
+		if chains[0].Block > 0 {
+			tsData.MinorBlock = chains[0].Block
+
+			// Resolve the major block from the block timestamp
+			if blockTime, err := time.Parse(time.RFC3339, chains[0].Time); err == nil {
+				tsData.MajorBlock, tsData.MajorIndexed = s.majorBlockAt(blockTime)
+			}
+
+			tsData.HasBlockTime = true
+			hasBlockData = true
+			log.Printf("Found block timestamp for %s: minor=%d, major=%d", txid, tsData.MinorBlock, tsData.MajorBlock)
+		}
+	}
+
+	// If no block data found, fall back to signature timestamps
+	if !hasBlockData {
+		oldestTimestamp := int64(0)
+		if cached != nil {
+			oldestTimestamp = cached.SignatureTime
+		}
+
+		// Extract timestamps from all signatures (recursively for delegated)
+		oldestTimestamp = oldestSignatureTimestamp(txRecord.Signatures, oldestTimestamp)
+
+		tsData.Chains = []ChainEntry{}
+		tsData.MinorBlock = 0
+		tsData.MajorBlock = 0
+		tsData.HasBlockTime = false
+		tsData.SignatureTime = oldestTimestamp
+
+		// If we found a signature timestamp, add it as a chain entry
+		if oldestTimestamp > 0 {
+			tsData.Chains = []ChainEntry{{
+				Chain: "signature",
+				Block: 0,
+				Time:  time.Unix(0, oldestTimestamp*1000000).Format(time.RFC3339),
+			}}
+		}
+
+		if transactionFailed(txRecord) {
+			tsData.Status = statusFailed
+		}
+		s.schedulePending(tsData, cached)
+	}
+
+	// Cache the result with the minor blocks it reports
+	if err := s.cacheTimestampBlocks(txid, tsData); err != nil {
+		log.Printf("Error caching timestamp for %s: %v", txid, err)
+	} else if hasBlockData {
+		log.Printf("Cached block timestamp for %s: block=%d", txid, tsData.MinorBlock)
+	} else {
+		log.Printf("Cached signature timestamp for %s: %d", txid, tsData.SignatureTime)
+	}
+	return tsData, nil
+}
+
+// cacheTimestampBlocks stores the timestamp of a transaction and, in the
+// same write, the minor block of each chain entry with a block and time.
+// The v2 API reports Directory Network blocks, which the explorer links to
+// as such.
+func (s *Service) cacheTimestampBlocks(txid string, data *TimestampData) error {
+	batch := new(leveldb.Batch)
+	if err := s.putTimestamp(batch, txid, data); err != nil {
+		return err
+	}
+	for _, entry := range data.Chains {
+		blockTime, err := time.Parse(time.RFC3339, entry.Time)
+		if entry.Block <= 0 || err != nil {
+			continue
+		}
+		block := &MinorBlock{Partition: directoryPartition, Index: entry.Block, Time: blockTime.UTC()}
+		if err := s.putMinorBlock(batch, block); err != nil {
+			return err
+		}
+	}
+	return s.db.Write(batch, nil)
+}
+
+// resolveMajorBlock resolves again the major block of a cached block
+// timestamp that was estimated before its major block was indexed, and
+// updates the cache once it is
+func (s *Service) resolveMajorBlock(txid string, data *TimestampData) {
+	if data.MajorIndexed || len(data.Chains) == 0 {
+		return
+	}
+	blockTime, err := time.Parse(time.RFC3339, data.Chains[0].Time)
+	if err != nil {
+		return
+	}
+	data.MajorBlock, data.MajorIndexed = s.majorBlockAt(blockTime)
+	if !data.MajorIndexed {
+		return
+	}
+	if err := s.cacheTimestamp(txid, data); err != nil {
+		log.Printf("Error caching timestamp for %s: %v", txid, err)
+	}
+}
+
+// timestampError returns the HTTP status and message of a failed timestamp
+// query
+func timestampError(err error) (int, string) {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return http.StatusNotFound, "Transaction not found"
+	}
+	return http.StatusInternalServerError, "Failed to query transaction"
+}
+
+// Get timestamp handler
+func (s *Service) getTimestampHandler(w http.ResponseWriter, r *http.Request) {
+	txid, ok := parseTxid(mux.Vars(r)["txid"])
+	if !ok {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	data, cache, err := s.getTimestamp(r.Context(), txid)
+	if err != nil {
+		code, message := timestampError(err)
+		http.Error(w, message, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", cache)
+	json.NewEncoder(w).Encode(data.response())
+}
+
+// TimestampResult is the timestamp of one transaction of a batch
+type TimestampResult struct {
+	TxID string `json:"txid"` // As requested
+	*TimestampResponse
+	Cache string          `json:"cache,omitempty"` // X-Cache value of /v1/timestamp/{txid}
+	Error *TimestampError `json:"error,omitempty"`
+}
+
+// TimestampError is the error of one transaction of a batch, with the
+// status /v1/timestamp/{txid} would have returned
+type TimestampError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// getTimestamps returns the timestamps of txids in order. Transaction IDs
+// are validated by parseTxid. Timestamps are served from the cache as by
+// getTimestamp; the other transactions are resolved by TimestampWorkers
+// concurrent workers, once each, and each resolution is limited to
+// RequestTimeout.
+func (s *Service) getTimestamps(ctx context.Context, txids []string) []TimestampResult {
+	results := make([]TimestampResult, len(txids))
+	pending := map[string][]int{} // Cleaned txid -> indexes in txids
+	var queue []string
+	for i, txid := range txids {
+		results[i].TxID = txid
+		id, ok := parseTxid(txid)
+		if !ok {
+			results[i].Error = &TimestampError{Code: http.StatusBadRequest, Message: "Invalid transaction ID"}
+			continue
+		}
+		cached := s.getCachedTimestamp(id)
+		if cache, ok := s.cacheStatus(id, cached); ok {
+			results[i].TimestampResponse, results[i].Cache = cached.response(), cache
+			continue
+		}
+		if _, ok := pending[id]; !ok {
+			queue = append(queue, id)
+		}
+		pending[id] = append(pending[id], i)
+	}
+
+	type resolved struct {
+		txid   string
+		result TimestampResult
+	}
+	jobs := make(chan string)
+	out := make(chan resolved)
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.TimestampWorkers && i < len(queue); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for txid := range jobs {
+				reqCtx, cancel := context.WithTimeout(ctx, s.config.RequestTimeout)
+				data, cache, err := s.getTimestamp(reqCtx, txid)
+				cancel()
+
+				var result TimestampResult
+				if err != nil {
+					code, message := timestampError(err)
+					result.Error = &TimestampError{Code: code, Message: message}
+				} else {
+					result.TimestampResponse, result.Cache = data.response(), cache
+				}
+				out <- resolved{txid, result}
+			}
+		}()
+	}
+	go func() {
+		for _, txid := range queue {
+			jobs <- txid
+		}
+		close(jobs)
+		wg.Wait()
+		close(out)
+	}()
+
+	for r := range out {
+		for _, i := range pending[r.txid] {
+			result := r.result
+			result.TxID = txids[i]
+			results[i] = result
+		}
+	}
+	return results
+}
+
+// Get timestamps batch handler
+func (s *Service) getTimestampsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		// CORS preflight of the JSON POST. The reverse proxy adds the
+		// Access-Control-Allow-* headers; the route only has to match.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var request struct {
+		TxIDs []string `json:"txids"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&request); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.TxIDs) == 0 {
+		http.Error(w, "Invalid request: txids is required", http.StatusBadRequest)
+		return
+	}
+	if len(request.TxIDs) > s.config.TimestampBatchLimit {
+		http.Error(w, fmt.Sprintf("Invalid request: at most %d txids per request", s.config.TimestampBatchLimit), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": s.getTimestamps(r.Context(), request.TxIDs),
+	})
+}
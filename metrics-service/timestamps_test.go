@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postTimestamps posts a batch of txids to /v1/timestamps
+func postTimestamps(t *testing.T, handler http.Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/timestamps", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTimestampBatch(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	delivered := "a46534924b17040b9bbc6098e42be6629202181283e397e3fd770ff5be1e5ee6"
+	pending := "cc112612e975fa205698d8d24c272bfd2ab599f200268dd06d3814f1434a8e1b"
+	fake.SetTransaction("acc://"+delivered+"@unknown", TransactionRecord{Status: "delivered"})
+	fake.SetTimestamp(delivered, []ChainEntry{{Chain: "main", Block: 18745449, Time: "2026-02-21T19:22:52Z"}})
+	fake.SetTransaction("acc://"+pending+"@unknown", TransactionRecord{Status: "pending"})
+
+	config := DefaultConfig()
+	config.TimestampBatchLimit = 6
+	config.TimestampWorkers = 2
+	router := newMultiNetworkServer(t, config, map[string]*fakeAccumulate{"mainnet": fake}).Router()
+
+	var batch struct {
+		Results []TimestampResult `json:"results"`
+	}
+	rec := postTimestamps(t, router, `{"txids": ["`+delivered+`", "`+pending+`", "deadbeef", "acc://`+delivered+`@alice.acme", "metadata:lastQueriedIndex"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	decode(t, rec, &batch)
+	if len(batch.Results) != 5 {
+		t.Fatalf("results = %+v", batch.Results)
+	}
+	r := batch.Results
+	if r[0].TxID != delivered || r[0].TimestampResponse == nil || r[0].MinorBlock != 18745449 || r[0].MajorBlock != 2310 || r[0].Cache != cacheMiss {
+		t.Errorf("delivered = %+v", r[0])
+	}
+	if r[1].TimestampResponse == nil || r[1].Status != "pending" || r[1].MinorBlock != 0 || r[1].Error != nil {
+		t.Errorf("pending = %+v", r[1])
+	}
+	if r[2].Error == nil || r[2].Error.Code != http.StatusNotFound || r[2].TimestampResponse != nil {
+		t.Errorf("missing = %+v", r[2])
+	}
+	if r[3].TxID != "acc://"+delivered+"@alice.acme" || r[3].MinorBlock != 18745449 {
+		t.Errorf("duplicate = %+v", r[3])
+	}
+	if r[4].Error == nil || r[4].Error.Code != http.StatusBadRequest {
+		t.Errorf("invalid = %+v", r[4])
+	}
+	if got := fake.ScopeCalls("acc://" + delivered + "@unknown"); got != 1 {
+		t.Errorf("duplicate transaction queried %d times", got)
+	}
+
+	// Block timestamps and pending ones until their next check are served
+	// from the cache
+	calls := fake.Calls("transaction")
+	decode(t, postTimestamps(t, router, `{"txids": ["`+delivered+`", "`+pending+`"]}`), &batch)
+	if batch.Results[0].Cache != cacheHitBlock || batch.Results[1].Cache != cacheHitPending {
+		t.Errorf("results = %+v", batch.Results)
+	}
+	if got := fake.Calls("transaction") - calls; got != 0 {
+		t.Errorf("upstream transaction queries = %d, want 0", got)
+	}
+
+	for name, body := range map[string]string{
+		"empty":     `{"txids": []}`,
+		"too many":  `{"txids": ["a", "b", "c", "d", "e", "f", "0"]}`,
+		"malformed": `{"txids": "` + delivered + `"}`,
+		"unknown":   `{"txid": ["` + delivered + `"]}`,
+	} {
+		if rec := postTimestamps(t, router, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	webhookPrefix         = "webhook:hook:"     // webhook ID -> Webhook
+	webhookDeliveryPrefix = "webhook:delivery:" // webhook ID : event ID -> WebhookDelivery
+	webhookPendingPrefix  = "webhook:pending:"  // webhook ID : event ID -> "" for deliveries still to be attempted
+	webhookSequenceKey    = "webhook:sequence"  // ID of the last event
+)
+
+// Webhook event types
+const (
+	eventIdentityCreated = "identity.created"
+	eventIdentityUpdated = "identity.updated"
+	eventIdentityDeleted = "identity.deleted"
+	eventPing            = "ping"
+)
+
+var webhookEventTypes = map[string]bool{
+	eventIdentityCreated: true,
+	eventIdentityUpdated: true,
+	eventIdentityDeleted: true,
+}
+
+// Webhook delivery states
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// webhookHistoryLimit is the number of finished deliveries kept per webhook
+const webhookHistoryLimit = 500
+
+// Webhook delivery request headers
+const (
+	webhookEventHeader     = "X-Metrics-Event"
+	webhookDeliveryHeader  = "X-Metrics-Delivery"
+	webhookSignatureHeader = "X-Metrics-Signature" // sha256={hex HMAC-SHA256 of the body}
+)
+
+// Webhook is a URL that is sent the staking registry events of a network
+type Webhook struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events,omitempty"` // Event types to send, all if empty
+	Secret  string    `json:"secret,omitempty"` // HMAC key, only returned when the webhook is created
+	Created time.Time `json:"created"`
+}
+
+// wants returns true if the webhook subscribes to events of type eventType
+func (h *Webhook) wants(eventType string) bool {
+	if eventType == eventPing || len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the body of a webhook request
+type WebhookEvent struct {
+	ID           uint64                `json:"id"`
+	Type         string                `json:"type"`
+	Network      string                `json:"network"`
+	Time         string                `json:"time"`
+	Identity     string                `json:"identity,omitempty"`
+	Index        int64                 `json:"index,omitempty"` // Registry entry that caused the change
+	Hash         string                `json:"hash,omitempty"`
+	Registration *RegistrationIdentity `json:"registration,omitempty"` // Unset when deleted
+	Previous     *RegistrationIdentity `json:"previous,omitempty"`     // Unset when created
+	Changes      []FieldChange         `json:"changes,omitempty"`
+}
+
+// WebhookDelivery is the delivery of one event to one webhook
+type WebhookDelivery struct {
+	Webhook     string            `json:"webhook"`
+	Event       uint64            `json:"event"`
+	Type        string            `json:"type"`
+	State       string            `json:"state"`
+	NextAttempt *time.Time        `json:"nextAttempt,omitempty"`
+	Attempts    []DeliveryAttempt `json:"attempts"`
+	Payload     json.RawMessage   `json:"payload"`
+}
+
+// DeliveryAttempt is one request of a webhook delivery
+type DeliveryAttempt struct {
+	Time   time.Time `json:"time"`
+	Status int       `json:"status,omitempty"` // HTTP status of the response
+	Error  string    `json:"error,omitempty"`
+}
+
+func (s *Service) webhookKey(id string) []byte {
+	return s.key(webhookPrefix + id)
+}
+
+func (s *Service) deliveryKey(prefix, hookID string, event uint64) []byte {
+	return s.key(fmt.Sprintf("%s%s:%020d", prefix, hookID, event))
+}
+
+// newWebhookID returns a random webhook ID
+func newWebhookID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// signWebhook returns the signature header value of body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// getWebhooks returns the webhooks of the network ordered by creation
+func (s *Service) getWebhooks() ([]*Webhook, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(webhookPrefix)), nil)
+	defer iter.Release()
+
+	hooks := []*Webhook{}
+	for iter.Next() {
+		var hook Webhook
+		if err := json.Unmarshal(iter.Value(), &hook); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, &hook)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Created.Before(hooks[j].Created) })
+	return hooks, nil
+}
+
+// getWebhook returns a webhook, or leveldb.ErrNotFound
+func (s *Service) getWebhook(id string) (*Webhook, error) {
+	data, err := s.db.Get(s.webhookKey(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var hook Webhook
+	if err := json.Unmarshal(data, &hook); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// nextEventID adds the allocation of an event ID to batch. The batch must be
+// written with webhookMu held.
+func (s *Service) nextEventID(batch *leveldb.Batch) (uint64, error) {
+	var id uint64
+	data, err := s.db.Get(s.key(webhookSequenceKey), nil)
+	switch {
+	case err == nil && len(data) == 8:
+		id = binary.BigEndian.Uint64(data)
+	case err != nil && !errors.Is(err, leveldb.ErrNotFound):
+		return 0, err
+	}
+	id++
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], id)
+	batch.Put(s.key(webhookSequenceKey), b[:])
+	return id, nil
+}
+
+// queueEvent adds a delivery of event to every webhook that subscribes to it
+// to batch, and returns the number of deliveries. The batch must be written
+// with webhookMu held.
+func (s *Service) queueEvent(batch *leveldb.Batch, event *WebhookEvent, hooks []*Webhook) (int, error) {
+	var targets []*Webhook
+	for _, hook := range hooks {
+		if hook.wants(event.Type) {
+			targets = append(targets, hook)
+		}
+	}
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	id, err := s.nextEventID(batch)
+	if err != nil {
+		return 0, err
+	}
+	event.ID = id
+	event.Network = s.network.Name
+	now := s.now().UTC()
+	event.Time = now.Format(time.RFC3339)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, hook := range targets {
+		delivery := &WebhookDelivery{
+			Webhook:     hook.ID,
+			Event:       id,
+			Type:        event.Type,
+			State:       deliveryPending,
+			NextAttempt: &now,
+			Attempts:    []DeliveryAttempt{},
+			Payload:     payload,
+		}
+		data, err := json.Marshal(delivery)
+		if err != nil {
+			return 0, err
+		}
+		batch.Put(s.deliveryKey(webhookDeliveryPrefix, hook.ID, id), data)
+		batch.Put(s.deliveryKey(webhookPendingPrefix, hook.ID, id), nil)
+	}
+	return len(targets), nil
+}
+
+// queueIdentityEvent adds the event of an identity changing from previous to
+// current to batch, where either may be nil. It returns true if a delivery
+// was queued.
+func (s *Service) queueIdentityEvent(batch *leveldb.Batch, entry *RegistryEntry, identity string, previous, current *RegistrationIdentity) (bool, error) {
+	event := &WebhookEvent{
+		Identity:     identity,
+		Index:        entry.Index,
+		Hash:         entry.Hash,
+		Registration: current,
+		Previous:     previous,
+	}
+	switch {
+	case previous == nil && current == nil:
+		return false, nil
+	case previous == nil:
+		event.Type = eventIdentityCreated
+	case current == nil:
+		event.Type = eventIdentityDeleted
+	default:
+		event.Changes = diffFields(registrationFields(previous), registrationFields(current))
+		if len(event.Changes) == 0 {
+			return false, nil
+		}
+		event.Type = eventIdentityUpdated
+	}
+
+	hooks, err := s.getWebhooks()
+	if err != nil {
+		return false, err
+	}
+	n, err := s.queueEvent(batch, event, hooks)
+	return n > 0, err
+}
+
+// notifyWebhooks wakes the webhook dispatcher
+func (s *Service) notifyWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhooks delivers queued webhook events until ctx is cancelled
+func (s *Service) runWebhooks(ctx context.Context) {
+	for {
+		wait := time.Minute
+		if next := s.deliverWebhooks(ctx); !next.IsZero() {
+			if d := next.Sub(s.now()); d < wait {
+				wait = d
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.webhookWake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// deliverWebhooks attempts the deliveries that are due, one webhook at a
+// time in event order and the webhooks concurrently. It returns when the
+// next pending delivery is due, or zero if there is none.
+func (s *Service) deliverWebhooks(ctx context.Context) time.Time {
+	pending := map[string][]uint64{}
+	prefix := s.key(webhookPendingPrefix)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	for iter.Next() {
+		var event uint64
+		key := string(iter.Key()[len(prefix):])
+		sep := strings.LastIndexByte(key, ':')
+		if sep < 0 {
+			continue
+		}
+		if _, err := fmt.Sscanf(key[sep+1:], "%d", &event); err != nil {
+			continue
+		}
+		pending[key[:sep]] = append(pending[key[:sep]], event)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		log.Printf("[%s] Error reading webhook queue: %v", s.network.Name, err)
+		return time.Time{}
+	}
+
+	var mu sync.Mutex
+	var next time.Time
+	var wg sync.WaitGroup
+	for hookID, events := range pending {
+		wg.Add(1)
+		go func(hookID string, events []uint64) {
+			defer wg.Done()
+			due := s.deliverQueue(ctx, hookID, events)
+			mu.Lock()
+			if !due.IsZero() && (next.IsZero() || due.Before(next)) {
+				next = due
+			}
+			mu.Unlock()
+		}(hookID, events)
+	}
+	wg.Wait()
+	return next
+}
+
+// deliverQueue attempts the due deliveries of one webhook. A delivery that
+// is waiting for a retry holds back the later ones so events arrive in
+// order. It returns when the first undelivered event is due.
+func (s *Service) deliverQueue(ctx context.Context, hookID string, events []uint64) time.Time {
+	hook, err := s.getWebhook(hookID)
+	if err != nil {
+		log.Printf("[%s] Error reading webhook %s: %v", s.network.Name, hookID, err)
+		return time.Time{}
+	}
+
+	for _, event := range events {
+		if ctx.Err() != nil {
+			return time.Time{}
+		}
+		delivery, err := s.getDelivery(hookID, event)
+		if err != nil {
+			log.Printf("[%s] Error reading webhook delivery %s:%d: %v", s.network.Name, hookID, event, err)
+			return time.Time{}
+		}
+		if delivery.NextAttempt != nil && delivery.NextAttempt.After(s.now()) {
+			return *delivery.NextAttempt
+		}
+
+		s.attemptDelivery(ctx, hook, delivery)
+		if err := s.putDelivery(delivery); err != nil {
+			log.Printf("[%s] Error recording webhook delivery %s:%d: %v", s.network.Name, hookID, event, err)
+			return time.Time{}
+		}
+		if delivery.State == deliveryPending {
+			return *delivery.NextAttempt
+		}
+	}
+	return time.Time{}
+}
+
+// attemptDelivery sends a delivery once and updates its state
+func (s *Service) attemptDelivery(ctx context.Context, hook *Webhook, delivery *WebhookDelivery) {
+	attempt := DeliveryAttempt{Time: s.now().UTC()}
+	ctx, cancel := context.WithTimeout(ctx, s.config.RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhookEventHeader, delivery.Type)
+		req.Header.Set(webhookDeliveryHeader, fmt.Sprint(delivery.Event))
+		req.Header.Set(webhookSignatureHeader, signWebhook(hook.Secret, delivery.Payload))
+
+		var resp *http.Response
+		resp, err = http.DefaultClient.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+			attempt.Status = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("unexpected status %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case err == nil:
+		delivery.State = deliveryDelivered
+		delivery.NextAttempt = nil
+	case len(delivery.Attempts) >= s.config.WebhookAttempts:
+		log.Printf("[%s] Webhook delivery %s:%d failed after %d attempts: %v", s.network.Name, hook.ID, delivery.Event, len(delivery.Attempts), err)
+		delivery.State = deliveryFailed
+		delivery.NextAttempt = nil
+	default:
+		next := attempt.Time.Add(s.config.WebhookBackoff << (len(delivery.Attempts) - 1))
+		delivery.NextAttempt = &next
+	}
+}
+
+func (s *Service) getDelivery(hookID string, event uint64) (*WebhookDelivery, error) {
+	data, err := s.db.Get(s.deliveryKey(webhookDeliveryPrefix, hookID, event), nil)
+	if err != nil {
+		return nil, err
+	}
+	var delivery WebhookDelivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// putDelivery stores a delivery and, once it is finished, removes it from
+// the queue and prunes the history of its webhook
+func (s *Service) putDelivery(delivery *WebhookDelivery) error {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	// The webhook may have been deleted while the request was in flight
+	if _, err := s.getWebhook(delivery.Webhook); err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(s.deliveryKey(webhookDeliveryPrefix, delivery.Webhook, delivery.Event), data)
+	if delivery.State != deliveryPending {
+		batch.Delete(s.deliveryKey(webhookPendingPrefix, delivery.Webhook, delivery.Event))
+		if err := s.pruneDeliveries(batch, delivery.Webhook); err != nil {
+			return err
+		}
+	}
+	return s.db.Write(batch, nil)
+}
+
+// pruneDeliveries adds the deletion of the oldest finished deliveries of a
+// webhook beyond webhookHistoryLimit to batch
+func (s *Service) pruneDeliveries(batch *leveldb.Batch, hookID string) error {
+	prefix := s.key(webhookDeliveryPrefix + hookID + ":")
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	var keys [][]byte
+	for iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	for _, key := range keys[:max(len(keys)-webhookHistoryLimit, 0)] {
+		pending := append(s.key(webhookPendingPrefix), key[len(s.key(webhookDeliveryPrefix)):]...)
+		if ok, err := s.db.Has(pending, nil); err != nil {
+			return err
+		} else if !ok {
+			batch.Delete(key)
+		}
+	}
+	return nil
+}
+
+// getDeliveries returns the deliveries of a webhook, newest first
+func (s *Service) getDeliveries(hookID, state string, limit int) ([]*WebhookDelivery, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(s.key(webhookDeliveryPrefix+hookID+":")), nil)
+	defer iter.Release()
+
+	deliveries := []*WebhookDelivery{}
+	for ok := iter.Last(); ok && len(deliveries) < limit; ok = iter.Prev() {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal(iter.Value(), &delivery); err != nil {
+			return nil, err
+		}
+		if state == "" || delivery.State == state {
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, iter.Error()
+}
+
+// deleteWebhook deletes a webhook with its queue and delivery history
+func (s *Service) deleteWebhook(id string) error {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	batch.Delete(s.webhookKey(id))
+	for _, prefix := range []string{webhookDeliveryPrefix, webhookPendingPrefix} {
+		if err := deletePrefix(s.db, batch, s.key(prefix+id+":")); err != nil {
+			return err
+		}
+	}
+	return s.db.Write(batch, nil)
+}
+
+// requireAdmin wraps an admin handler with bearer token authentication. The
+// admin API is disabled if no token is configured.
+func (s *Server) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			http.Error(w, "Admin API is disabled (no admin token configured)", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// List webhooks handler
+func (s *Service) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := s.getWebhooks()
+	if err != nil {
+		log.Printf("Error reading webhooks: %v", err)
+		http.Error(w, "Failed to read webhooks", http.StatusInternalServerError)
+		return
+	}
+	for _, hook := range hooks {
+		hook.Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// Create webhook handler
+func (s *Service) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&request); err != nil {
+		http.Error(w, "Invalid webhook: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateURL("url", request.URL); err != nil {
+		http.Error(w, "Invalid webhook: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, e := range request.Events {
+		if !webhookEventTypes[e] {
+			http.Error(w, fmt.Sprintf("Invalid webhook: unknown event type %q", e), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, err := newWebhookID()
+	if err == nil && request.Secret == "" {
+		var secret [32]byte
+		_, err = rand.Read(secret[:])
+		request.Secret = hex.EncodeToString(secret[:])
+	}
+	hook := &Webhook{
+		ID:      id,
+		URL:     request.URL,
+		Events:  request.Events,
+		Secret:  request.Secret,
+		Created: s.now().UTC(),
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(hook)
+	}
+	if err == nil {
+		s.webhookMu.Lock()
+		err = s.db.Put(s.webhookKey(id), data, nil)
+		s.webhookMu.Unlock()
+	}
+	if err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[%s] Created webhook %s for %s", s.network.Name, id, hook.URL)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// loadWebhook returns the webhook of the {id} route variable, or writes an
+// error response and returns nil
+func (s *Service) loadWebhook(w http.ResponseWriter, r *http.Request) *Webhook {
+	hook, err := s.getWebhook(mux.Vars(r)["id"])
+	if errors.Is(err, leveldb.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error reading webhook: %v", err)
+		http.Error(w, "Failed to read webhook", http.StatusInternalServerError)
+		return nil
+	}
+	return hook
+}
+
+// Get webhook handler
+func (s *Service) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook := s.loadWebhook(w, r)
+	if hook == nil {
+		return
+	}
+	hook.Secret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hook)
+}
+
+// Delete webhook handler
+func (s *Service) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook := s.loadWebhook(w, r)
+	if hook == nil {
+		return
+	}
+	if err := s.deleteWebhook(hook.ID); err != nil {
+		log.Printf("Error deleting webhook %s: %v", hook.ID, err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[%s] Deleted webhook %s", s.network.Name, hook.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Ping webhook handler
+func (s *Service) pingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook := s.loadWebhook(w, r)
+	if hook == nil {
+		return
+	}
+
+	event := &WebhookEvent{Type: eventPing}
+	s.webhookMu.Lock()
+	batch := new(leveldb.Batch)
+	_, err := s.queueEvent(batch, event, []*Webhook{hook})
+	if err == nil {
+		err = s.db.Write(batch, nil)
+	}
+	s.webhookMu.Unlock()
+	if err != nil {
+		log.Printf("Error queueing webhook ping: %v", err)
+		http.Error(w, "Failed to queue ping", http.StatusInternalServerError)
+		return
+	}
+	s.notifyWebhooks()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(event)
+}
+
+// List webhook deliveries handler
+func (s *Service) listDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	hook := s.loadWebhook(w, r)
+	if hook == nil {
+		return
+	}
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", deliveryPending, deliveryDelivered, deliveryFailed:
+	default:
+		http.Error(w, fmt.Sprintf("invalid state %q (want pending, delivered or failed)", state), http.StatusBadRequest)
+		return
+	}
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > webhookHistoryLimit {
+			http.Error(w, fmt.Sprintf("invalid limit %q (want 1-%d)", v, webhookHistoryLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := s.getDeliveries(hook.ID, state, limit)
+	if err != nil {
+		log.Printf("Error reading webhook deliveries: %v", err)
+		http.Error(w, "Failed to read webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
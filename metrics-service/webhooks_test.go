@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "test-admin-token"
+
+// admin performs an authenticated admin request against handler
+func admin(t *testing.T, handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// newAdminTestServer returns a test server with the admin API enabled
+func newAdminTestServer(t *testing.T, fake *fakeAccumulate) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.AdminToken = testAdminToken
+	return newMultiNetworkServer(t, config, map[string]*fakeAccumulate{"mainnet": fake})
+}
+
+// webhookReceiver records the webhook requests it receives. Requests to
+// /down always fail and the first request to /flaky fails.
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string][]*http.Request
+	bodies   map[string][][]byte
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	r := &webhookReceiver{requests: map[string][]*http.Request{}, bodies: map[string][][]byte{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests[req.URL.Path] = append(r.requests[req.URL.Path], req)
+		r.bodies[req.URL.Path] = append(r.bodies[req.URL.Path], body)
+		n := len(r.requests[req.URL.Path])
+		r.mu.Unlock()
+
+		if req.URL.Path == "/down" || (req.URL.Path == "/flaky" && n == 1) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// events returns the events received on path, checking their signatures
+func (r *webhookReceiver) events(t *testing.T, path, secret string) []WebhookEvent {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []WebhookEvent
+	for i, req := range r.requests[path] {
+		body := r.bodies[path][i]
+		if got := req.Header.Get(webhookSignatureHeader); got != signWebhook(secret, body) {
+			t.Errorf("%s: bad signature %q", path, got)
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if req.Header.Get(webhookEventHeader) != event.Type {
+			t.Errorf("%s: event header %q for %s", path, req.Header.Get(webhookEventHeader), event.Type)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func eventTypes(events []WebhookEvent) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type+" "+e.Identity)
+	}
+	return types
+}
+
+func TestWebhooks(t *testing.T) {
+	fake := newFakeAccumulate(t)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered", Accounts: []Account{{Type: "pure", Url: "acc://alice.acme/staking"}}})
+	receiver := newWebhookReceiver(t)
+
+	config := DefaultConfig()
+	config.AdminToken = testAdminToken
+	config.WebhookAttempts = 2
+	config.WebhookBackoff = time.Minute
+	server := newMultiNetworkServer(t, config, map[string]*fakeAccumulate{"mainnet": fake})
+	service := server.primary
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return clock }
+	router := server.Router()
+	ctx := context.Background()
+
+	for _, path := range []string{"/admin/webhooks", "/admin/registry/gaps", "/mainnet/admin/registry/gaps"} {
+		if rec := get(t, router, path); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: unauthenticated status = %d", path, rec.Code)
+		}
+	}
+	if rec := admin(t, router, "POST", "/admin/webhooks", `{"url": "ftp://example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid URL status = %d", rec.Code)
+	}
+
+	create := func(body string) Webhook {
+		t.Helper()
+		clock = clock.Add(time.Second)
+		rec := admin(t, router, "POST", "/admin/webhooks", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var hook Webhook
+		decode(t, rec, &hook)
+		return hook
+	}
+	flaky := create(`{"url": "` + receiver.URL + `/flaky"}`)
+	deletes := create(`{"url": "` + receiver.URL + `/deletes", "events": ["identity.deleted"], "secret": "s3cret"}`)
+	down := create(`{"url": "` + receiver.URL + `/down"}`)
+	if len(flaky.Secret) != 64 || deletes.Secret != "s3cret" {
+		t.Errorf("secrets = %q, %q", flaky.Secret, deletes.Secret)
+	}
+
+	var hooks []Webhook
+	decode(t, admin(t, router, "GET", "/admin/webhooks", ""), &hooks)
+	if len(hooks) != 3 || hooks[0].ID != flaky.ID || hooks[0].Secret != "" {
+		t.Errorf("webhooks = %+v", hooks)
+	}
+
+	// alice is created, then updated; bob is created and deleted
+	syncRegistry(t, server)
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://alice.acme", Status: "registered", DelegatorPayout: "acc://alice.acme/rewards", Accounts: []Account{{Type: "pure", Url: "acc://alice.acme/staking"}}})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "registered"})
+	fake.AddRegistration(t, RegistrationIdentity{Identity: "acc://bob.acme", Status: "deleted"})
+	syncRegistry(t, server)
+
+	// The first request to /flaky fails and holds back the later events
+	next := service.deliverWebhooks(ctx)
+	if want := clock.Add(time.Minute); !next.Equal(want) {
+		t.Errorf("next attempt = %v, want %v", next, want)
+	}
+	if got := eventTypes(receiver.events(t, "/flaky", flaky.Secret)); len(got) != 1 {
+		t.Errorf("flaky received %v", got)
+	}
+	var deliveries []WebhookDelivery
+	decode(t, admin(t, router, "GET", "/admin/webhooks/"+flaky.ID+"/deliveries?state=pending", ""), &deliveries)
+	if len(deliveries) != 4 || deliveries[3].Event != 1 || len(deliveries[3].Attempts) != 1 || deliveries[3].Attempts[0].Status != http.StatusServiceUnavailable {
+		t.Errorf("pending deliveries = %+v", deliveries)
+	}
+
+	// /down keeps retrying its later events
+	clock = clock.Add(time.Minute)
+	if next, want := service.deliverWebhooks(ctx), clock.Add(time.Minute); !next.Equal(want) {
+		t.Errorf("next attempt = %v, want %v", next, want)
+	}
+	events := receiver.events(t, "/flaky", flaky.Secret)
+	want := []string{
+		"identity.created acc://alice.acme",
+		"identity.created acc://alice.acme",
+		"identity.updated acc://alice.acme",
+		"identity.created acc://bob.acme",
+		"identity.deleted acc://bob.acme",
+	}
+	if got := eventTypes(events); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("flaky received %v, want %v", got, want)
+	}
+	if updated := events[2]; len(updated.Changes) != 1 || updated.Changes[0].Field != "delegatorPayout" || updated.Previous == nil || updated.Index != 1 || updated.Network != "mainnet" {
+		t.Errorf("updated event = %+v", updated)
+	}
+	if deleted := events[4]; deleted.Registration != nil || deleted.Previous == nil || deleted.ID != 4 {
+		t.Errorf("deleted event = %+v", deleted)
+	}
+	if got := eventTypes(receiver.events(t, "/deletes", "s3cret")); len(got) != 1 || got[0] != "identity.deleted acc://bob.acme" {
+		t.Errorf("deletes received %v", got)
+	}
+
+	// /down gives up on its first event after two attempts and moves on
+	decode(t, admin(t, router, "GET", "/admin/webhooks/"+down.ID+"/deliveries?state=failed", ""), &deliveries)
+	if len(deliveries) != 1 || deliveries[0].Event != 1 || len(deliveries[0].Attempts) != 2 {
+		t.Errorf("failed deliveries = %+v", deliveries)
+	}
+
+	// Ping and delete
+	if rec := admin(t, router, "POST", "/admin/webhooks/"+deletes.ID+"/ping", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("ping status %d: %s", rec.Code, rec.Body.String())
+	}
+	service.deliverWebhooks(ctx)
+	if got := eventTypes(receiver.events(t, "/deletes", "s3cret")); len(got) != 2 || got[1] != "ping " {
+		t.Errorf("deletes received %v", got)
+	}
+	if rec := admin(t, router, "DELETE", "/admin/webhooks/"+down.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d", rec.Code)
+	}
+	if rec := admin(t, router, "GET", "/admin/webhooks/"+down.ID+"/deliveries", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted webhook status = %d", rec.Code)
+	}
+}
+
+func TestWebhooksDisabled(t *testing.T) {
+	server := newTestServer(t, newFakeAccumulate(t))
+	if rec := admin(t, server.Router(), "GET", "/admin/webhooks", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
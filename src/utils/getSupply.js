@@ -12,12 +12,9 @@ export default async function getSupply(network, setSupply, setAPR) {
     } else {
       throw new Error('Can not get ACME supply metrics');
     }
-    const unissued =
-      (Number(response.data.max) - Number(response.data.total)) / 10 ** 8;
-    const rewards = ((unissued * 0.16) / 365) * 7;
-    const rate = rewards / (response.data.staked / 10 ** 8);
-    const apr = (1 + rate) ** 52 - 1;
-    setAPR?.(apr);
+    if (setAPR) {
+      setAPR(await getAPR(network, response.data));
+    }
   } catch (error) {
     // Silently fail - supply metrics are non-critical
     // If metrics API is down, just don't show supply data
@@ -25,3 +22,21 @@ export default async function getSupply(network, setSupply, setAPR) {
     setSupply(null);
   }
 }
+
+// getAPR returns the staking APR computed by the metrics service, or
+// estimates it from the supply if the service does not provide it
+async function getAPR(network, supply) {
+  try {
+    const response = await axios.get(network.metrics + '/staking/apr');
+    if (typeof response?.data?.apr === 'number') {
+      return response.data.apr;
+    }
+  } catch (error) {
+    console.warn('Failed to fetch staking APR:', error.message);
+  }
+
+  const unissued = (Number(supply.max) - Number(supply.total)) / 10 ** 8;
+  const rewards = ((unissued * 0.16) / 365) * 7;
+  const rate = rewards / (supply.staked / 10 ** 8);
+  return (1 + rate) ** 52 - 1;
+}